@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"gopkg.in/yaml.v2"
+)
+
+// checkConfig is the main config plus the options of check-config.
+type checkConfig struct {
+	Config `yaml:",inline"`
+	quiet  bool
+}
+
+func (c *checkConfig) RegisterFlags(f *flag.FlagSet) {
+	c.Config.RegisterFlags(f)
+	f.BoolVar(&c.quiet, "quiet", false, "Only print problems, not the resolved config")
+}
+
+func (c *checkConfig) Clone() flagext.Registerer {
+	return func(c checkConfig) *checkConfig {
+		return &c
+	}(*c)
+}
+
+// runCheckConfig parses the config the same way as the main process, prints it with every default filled in
+// and lists all the problems with it. It exits 1 if there are any.
+func runCheckConfig(args []string) int {
+	f := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	var config checkConfig
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: adsb-loki check-config -config.file <file> [options]\n\n"+
+			"Check the config file and flags without starting anything, the same flags as the main process are accepted.\n"+
+			"The resolved config is printed to stdout and problems to stderr.\n\n")
+		f.PrintDefaults()
+	}
+	if err := parseConfig(&config, f, args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed parsing config: %v\n", err)
+		return 1
+	}
+	if !config.quiet {
+		out, err := yaml.Marshal(&config.Config.Config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print config: %v\n", err)
+			return 1
+		}
+		os.Stdout.Write(out)
+	}
+	errs := config.Validate()
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "found %d problem(s)\n", len(errs))
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "config is valid")
+	return 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/history"
+	"github.com/slim-bean/adsb-loki/pkg/lokiquery"
+	"github.com/slim-bean/adsb-loki/pkg/track"
+)
+
+// parseTime accepts RFC3339, a date, or a duration which is taken as that long before now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, must be RFC3339, YYYY-MM-DD or a duration ago such as 6h", s)
+}
+
+// output returns stdout if file is empty.
+func output(file string) (io.WriteCloser, error) {
+	if file == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(file)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// runExport writes the track of one aircraft, fetched from a running adsb-loki or from Loki.
+func runExport(args []string) int {
+	f := flag.NewFlagSet("export", flag.ContinueOnError)
+	var (
+		hex, format, session, out, server, from, to string
+		gap                                         time.Duration
+		lc                                          lokiquery.Config
+	)
+	f.StringVar(&hex, "hex", "", "ICAO hex address of the aircraft to export")
+	f.StringVar(&format, "format", track.GeoJSON, "Output format, one of geojson, kml or gpx")
+	f.StringVar(&session, "session", "all", "Which sessions to export, all, last or the number of a session counting from 1 for the oldest")
+	f.StringVar(&out, "o", "", "File to write to, defaults to stdout")
+	f.StringVar(&server, "server", "http://localhost:8090", "adsb-loki server to export the in memory track from, used unless -loki.url is set")
+	f.StringVar(&from, "from", "24h", "Start of the time range when exporting from Loki, RFC3339, YYYY-MM-DD or a duration ago")
+	f.StringVar(&to, "to", "0s", "End of the time range when exporting from Loki")
+	f.DurationVar(&gap, "session-gap", 10*time.Minute, "Gap between positions which starts a new session when exporting from Loki")
+	lc.RegisterFlags(f)
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: adsb-loki export -hex <hex> [options]\n\nExport the track of an aircraft as GeoJSON, KML or GPX.\n\n")
+		f.PrintDefaults()
+	}
+	if err := f.Parse(args); err != nil {
+		return 2
+	}
+	if hex == "" {
+		f.Usage()
+		return 2
+	}
+	hex = strings.ToLower(hex)
+
+	w, err := output(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create output: %v\n", err)
+		return 1
+	}
+	if lc.URL == "" {
+		err = exportFromServer(w, server, hex, format, session)
+	} else {
+		err = exportFromLoki(w, lc, hex, format, session, from, to, gap)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func exportFromServer(w io.Writer, server, hex, format, session string) error {
+	q := url.Values{}
+	q.Set("format", format)
+	q.Set("session", session)
+	resp, err := http.Get(strings.TrimRight(server, "/") + "/tracks/" + url.PathEscape(hex) + "?" + q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func exportFromLoki(w io.Writer, lc lokiquery.Config, hex, format, session, from, to string, gap time.Duration) error {
+	now := time.Now()
+	start, err := parseTime(from, now)
+	if err != nil {
+		return err
+	}
+	end, err := parseTime(to, now)
+	if err != nil {
+		return err
+	}
+	sessions, err := history.Fetch(context.Background(), lokiquery.New(lc), history.Query{Hex: hex}, start, end, gap)
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return fmt.Errorf("no positions for %s between %s and %s", hex, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	tracks, err := track.Select(sessions, session)
+	if err != nil {
+		return err
+	}
+	return track.Write(w, format, tracks)
+}
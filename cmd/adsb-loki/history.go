@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/history"
+	"github.com/slim-bean/adsb-loki/pkg/lokiquery"
+	"github.com/slim-bean/adsb-loki/pkg/track"
+)
+
+// runHistory queries Loki for the past flights of an aircraft and prints them as a table or exports them.
+func runHistory(args []string) int {
+	f := flag.NewFlagSet("history", flag.ContinueOnError)
+	var (
+		q                     history.Query
+		format, out, from, to string
+		gap                   time.Duration
+		lc                    lokiquery.Config
+	)
+	f.StringVar(&q.Hex, "hex", "", "ICAO hex address of the aircraft")
+	f.StringVar(&q.Registration, "registration", "", "Registration of the aircraft")
+	f.StringVar(&q.Callsign, "callsign", "", "Flight callsign, which may have been used by more than one aircraft")
+	f.StringVar(&format, "format", "table", "Output format, one of table, csv, geojson, kml or gpx")
+	f.StringVar(&out, "o", "", "File to write to, defaults to stdout")
+	f.StringVar(&from, "from", "24h", "Start of the time range, RFC3339, YYYY-MM-DD or a duration ago")
+	f.StringVar(&to, "to", "0s", "End of the time range, RFC3339, YYYY-MM-DD or a duration ago")
+	f.DurationVar(&gap, "session-gap", 10*time.Minute, "Gap between positions which starts a new session")
+	lc.RegisterFlags(f)
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: adsb-loki history -loki.url <url> (-hex <hex> | -registration <reg> | -callsign <callsign>) [options]\n\nList the flights of an aircraft stored in Loki.\n\n")
+		f.PrintDefaults()
+	}
+	if err := f.Parse(args); err != nil {
+		return 2
+	}
+	if _, err := q.LogQL(); err != nil || lc.URL == "" {
+		f.Usage()
+		return 2
+	}
+	now := time.Now()
+	start, err := parseTime(from, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	end, err := parseTime(to, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	tracks, err := history.Fetch(context.Background(), lokiquery.New(lc), q, start, end, gap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history query failed: %v\n", err)
+		return 1
+	}
+	w, err := output(out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create output: %v\n", err)
+		return 1
+	}
+	switch format {
+	case "table":
+		err = history.WriteTable(w, tracks)
+	case "csv":
+		err = history.WriteCSV(w, tracks)
+	default:
+		err = track.Write(w, format, tracks)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write history: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/grafana/loki/clients/pkg/promtail/client"
+	lokiflagext "github.com/grafana/loki/pkg/util/flagext"
+	"github.com/prometheus/client_golang/prometheus"
+
+	lokiconfig "github.com/grafana/loki/pkg/util/cfg"
+
+	"github.com/slim-bean/adsb-loki/pkg/adsbloki"
+	"github.com/slim-bean/adsb-loki/pkg/aircraft"
+	"github.com/slim-bean/adsb-loki/pkg/backfill"
+)
+
+// importConfig is the main config, so imported reports are enriched and labelled the same as live ones,
+// plus the options of the import.
+type importConfig struct {
+	Config    `yaml:",inline"`
+	from, to  string
+	chunkSize int
+	rate      float64
+}
+
+func (c *importConfig) RegisterFlags(f *flag.FlagSet) {
+	c.Config.RegisterFlags(f)
+	f.StringVar(&c.from, "from", "", "Only import reports after this time, RFC3339, YYYY-MM-DD or a duration ago")
+	f.StringVar(&c.to, "to", "", "Only import reports before this time, RFC3339, YYYY-MM-DD or a duration ago")
+	f.IntVar(&c.chunkSize, "chunk-size", 1000, "How many lines to send to Loki at a time")
+	f.Float64Var(&c.rate, "rate", 2000, "Maximum lines per second to send to Loki, 0 for no limit")
+}
+
+func (c *importConfig) Clone() flagext.Registerer {
+	return func(c importConfig) *importConfig {
+		return &c
+	}(*c)
+}
+
+// subcommandConfig is a config which embeds the main config.
+type subcommandConfig interface {
+	lokiconfig.Cloneable
+	flagext.Registerer
+}
+
+// parseConfig loads the main config for a subcommand from the config file and flags in args.
+func parseConfig(c subcommandConfig, f *flag.FlagSet, args []string) error {
+	return lokiconfig.Unmarshal(c,
+		func(lokiconfig.Cloneable) error {
+			c.RegisterFlags(f)
+			return nil
+		},
+		lokiconfig.YAMLFlag(args, "config.file"),
+		func(lokiconfig.Cloneable) error {
+			return f.Parse(args)
+		},
+	)
+}
+
+// isBackfillFile matches the files readsb writes which can be imported when walking a directory.
+func isBackfillFile(name string) bool {
+	for _, prefix := range []string{"history_", "trace_full_", "trace_recent_"} {
+		if strings.HasPrefix(name, prefix) && strings.Contains(name, ".json") {
+			return true
+		}
+	}
+	return false
+}
+
+// runImport pushes readsb history snapshots and globe_history traces to Loki with their original timestamps.
+func runImport(args []string) int {
+	f := flag.NewFlagSet("import", flag.ContinueOnError)
+	var config importConfig
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: adsb-loki import -config.file <file> [options] <file or directory>...\n\n"+
+			"Import readsb history_*.json and globe_history trace files into Loki, directories are searched for them.\n"+
+			"Aircraft details come from the database of the main process, which must have run at least once and must be stopped\n"+
+			"while importing.\n\n")
+		f.PrintDefaults()
+	}
+	if err := parseConfig(&config, f, args); err != nil {
+		fmt.Fprintf(os.Stderr, "failed parsing config: %v\n", err)
+		return 2
+	}
+	if f.NArg() == 0 {
+		f.Usage()
+		return 2
+	}
+	var from, to time.Time
+	now := time.Now()
+	for _, t := range []struct {
+		s   string
+		dst *time.Time
+	}{{config.from, &from}, {config.to, &to}} {
+		if t.s == "" {
+			continue
+		}
+		v, err := parseTime(t.s, now)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		*t.dst = v
+	}
+
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	logger = log.With(logger, "ts", log.DefaultTimestamp, "caller", log.DefaultCaller)
+
+	c := backfill.NewCollector()
+	files := 0
+	for _, root := range f.Args() {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// Files named on the command line are always read, only directories are filtered.
+			if info.IsDir() || path != root && !isBackfillFile(info.Name()) {
+				return nil
+			}
+			if err := c.AddFile(path); err != nil {
+				level.Warn(logger).Log("msg", "skipping file", "err", err)
+				return nil
+			}
+			files++
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", root, err)
+			return 1
+		}
+	}
+	rpts := c.Reports(from, to)
+	level.Info(logger).Log("msg", "read files", "files", files, "reports", len(rpts))
+
+	am, err := aircraft.OpenReadOnly(logger, config.AircraftManagerConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open the aircraft db, stop adsb-loki while importing: %v\n", err)
+		return 1
+	}
+	defer am.Close()
+	pl, err := adsbloki.NewPipeline(logger, &config.Config.Config, am)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to init the pipeline: %v\n", err)
+		return 1
+	}
+	defer pl.Stop()
+	entries, err := backfill.Entries(pl, rpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to process reports: %v\n", err)
+		return 1
+	}
+
+	lc, err := client.NewMulti(prometheus.DefaultRegisterer, logger, lokiflagext.LabelSet{}, config.ClientConfigs...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create Loki client(s): %v\n", err)
+		return 1
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	err = backfill.Push(ctx, lc.Chan(), entries, config.chunkSize, config.rate)
+	// Stopping the client flushes whatever it has batched.
+	lc.Stop()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import interrupted: %v\n", err)
+		return 1
+	}
+	level.Info(logger).Log("msg", "import complete", "lines", len(entries))
+	return 0
+}
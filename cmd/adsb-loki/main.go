@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
@@ -11,6 +12,7 @@ import (
 	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/common/version"
 	"github.com/slim-bean/adsb-loki/pkg/aircraft"
 
@@ -18,6 +20,8 @@ import (
 
 	"github.com/slim-bean/adsb-loki/pkg/adsbloki"
 	"github.com/slim-bean/adsb-loki/pkg/cfg"
+	"github.com/slim-bean/adsb-loki/pkg/modules"
+	"github.com/slim-bean/adsb-loki/pkg/server"
 )
 
 type Config struct {
@@ -42,6 +46,20 @@ func (c *Config) Clone() flagext.Registerer {
 
 func main() {
 
+	// Subcommands have their own flags so are handled before the main config is parsed.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-config":
+			os.Exit(runCheckConfig(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "history":
+			os.Exit(runHistory(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		}
+	}
+
 	var config Config
 
 	if err := lokiconfig.Parse(&config); err != nil {
@@ -52,36 +70,96 @@ func main() {
 		fmt.Println(version.Print("adsb-loki"))
 		os.Exit(0)
 	}
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		}
+		os.Exit(1)
+	}
 
 	var logger log.Logger
 	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	logger = log.With(logger, "ts", log.DefaultTimestamp, "caller", log.DefaultCaller)
 
 	shutdown := make(chan struct{})
-	go sig(logger, shutdown)
+	reload := make(chan struct{}, 1)
+	go sig(logger, shutdown, reload)
 
 	m, err := aircraft.NewAircraftManager(logger, config.AircraftManagerConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to init the registration manager: %v\n", err)
 		os.Exit(1)
 	}
-	m.Run()
 
-	al, err := adsbloki.NewADSBLoki(logger, &config.Config, m)
+	// The server starts first and stops last so /ready and /health can be checked the whole time,
+	// adsb-loki stops before the aircraft manager so everything is flushed before the db is closed.
+	var mods []modules.Module
+	var router *mux.Router
+	var serverDeps []string
+	if config.ServerConfig.HTTPListenAddress != "" {
+		srv, err := server.New(logger, config.ServerConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to init the http server: %v\n", err)
+			os.Exit(1)
+		}
+		router = srv.Router
+		mods = append(mods, modules.Module{Name: "server", Service: srv})
+		serverDeps = []string{"server"}
+	}
+
+	al, err := adsbloki.NewADSBLoki(logger, &config.Config, m, router)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to init the application: %v\n", err)
 		os.Exit(1)
 	}
+	mods = append(mods,
+		modules.Module{Name: "aircraft-manager", Deps: serverDeps, Service: m},
+		modules.Module{Name: "adsb-loki", Deps: []string{"aircraft-manager"}, Service: al},
+	)
+
+	mgr, err := modules.New(logger, mods...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to init the modules: %v\n", err)
+		os.Exit(1)
+	}
+	rl := newReloader(logger, al, os.Args[1:])
+	if router != nil {
+		router.HandleFunc("/ready", mgr.Ready).Methods(http.MethodGet)
+		router.HandleFunc("/health", mgr.Health).Methods(http.MethodGet)
+		router.Handle("/-/reload", rl).Methods(http.MethodPost)
+	}
+	if err := mgr.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to start the modules: %v\n", err)
+		os.Exit(1)
+	}
 
-	<-shutdown
-	al.Stop()
+	exitCode := 0
+loop:
+	for {
+		select {
+		case <-reload:
+			// The result is logged and counted by the reloader.
+			_ = rl.reload()
+		case <-shutdown:
+			break loop
+		case name := <-mgr.Finished():
+			// Only a replay finishes by itself, there's nothing left to do once it has.
+			level.Info(logger).Log("msg", "module finished, shutting down", "module", name)
+			break loop
+		case err := <-mgr.Failed():
+			level.Error(logger).Log("msg", "module failed, shutting down", "err", err)
+			exitCode = 1
+			break loop
+		}
+	}
+	mgr.Stop()
 	level.Info(logger).Log("msg", "shutdown complete")
-	os.Exit(0)
+	os.Exit(exitCode)
 }
 
-func sig(logger log.Logger, shutdown chan struct{}) {
+func sig(logger log.Logger, shutdown, reload chan struct{}) {
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
 	defer signal.Stop(sigs)
 	buf := make([]byte, 1<<20)
 	for {
@@ -92,6 +170,13 @@ func sig(logger log.Logger, shutdown chan struct{}) {
 				level.Info(logger).Log("msg", "=== received SIGINT/SIGTERM ===")
 				close(shutdown)
 				return
+			case syscall.SIGHUP:
+				level.Info(logger).Log("msg", "=== received SIGHUP ===")
+				select {
+				case reload <- struct{}{}:
+				default:
+					level.Warn(logger).Log("msg", "reload already pending, ignoring SIGHUP")
+				}
 			case syscall.SIGQUIT:
 				stacklen := runtime.Stack(buf, true)
 				level.Info(logger).Log("msg", fmt.Sprintf("=== received SIGQUIT ===\n*** goroutine dump...\n%s\n*** end", buf[:stacklen]))
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/slim-bean/adsb-loki/pkg/cfg"
+)
+
+var (
+	reloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "adsb_loki",
+		Name:      "config_reloads_total",
+		Help:      "Number of config reloads by result, success or failure.",
+	}, []string{"result"})
+	lastReloadSuccessful = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "adsb_loki",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last config reload succeeded.",
+	})
+	lastReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "adsb_loki",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Time of the last successful config reload, or of starting if there hasn't been one.",
+	})
+)
+
+type reloadable interface {
+	Reload(config *cfg.Config) error
+}
+
+// reloader parses the config file and the command line again and applies the result.
+type reloader struct {
+	logger log.Logger
+	target reloadable
+	args   []string
+}
+
+func newReloader(logger log.Logger, target reloadable, args []string) *reloader {
+	lastReloadSuccessful.Set(1)
+	lastReloadSuccess.Set(float64(time.Now().Unix()))
+	return &reloader{
+		logger: log.With(logger, "component", "reloader"),
+		target: target,
+		args:   args,
+	}
+}
+
+func (r *reloader) reload() error {
+	level.Info(r.logger).Log("msg", "reloading config")
+	err := r.apply()
+	if err != nil {
+		reloads.WithLabelValues("failure").Inc()
+		lastReloadSuccessful.Set(0)
+		level.Error(r.logger).Log("msg", "failed to reload config, keeping the current config", "err", err)
+		return err
+	}
+	reloads.WithLabelValues("success").Inc()
+	lastReloadSuccessful.Set(1)
+	lastReloadSuccess.Set(float64(time.Now().Unix()))
+	level.Info(r.logger).Log("msg", "config reloaded")
+	return nil
+}
+
+func (r *reloader) apply() error {
+	var next Config
+	f := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	if err := parseConfig(&next, f, r.args); err != nil {
+		return fmt.Errorf("failed parsing config: %s", err)
+	}
+	if errs := next.Validate(); len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return fmt.Errorf("invalid config: %s", strings.Join(msgs, "; "))
+	}
+	return r.target.Reload(&next.Config)
+}
+
+// ServeHTTP reloads on POST /-/reload, the error is returned if it fails.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := r.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "config reloaded")
+}
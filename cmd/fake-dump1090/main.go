@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/slim-bean/adsb-loki/pkg/simulator"
+)
+
+type Config struct {
+	Simulator          simulator.Config
+	HTTPListenAddress  string
+	BeastListenAddress string
+	SBSListenAddress   string
+	Interval           time.Duration
+	MalformedRate      float64
+	Emergency          string
+	EmergencyAfter     time.Duration
+	StaleAfter         time.Duration
+	StaleFor           time.Duration
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	c.Simulator.RegisterFlags(f)
+	f.StringVar(&c.HTTPListenAddress, "http-listen-address", ":8080", "Address to serve /data/aircraft.json and the /faults endpoints on")
+	f.StringVar(&c.BeastListenAddress, "beast-listen-address", "", "Address to stream Beast binary output on, e.g. :30005, disabled if empty")
+	f.StringVar(&c.SBSListenAddress, "sbs-listen-address", "", "Address to stream SBS BaseStation output on, e.g. :30003, disabled if empty")
+	f.DurationVar(&c.Interval, "interval", time.Second, "How often the aircraft move and the streams are sent")
+	f.Float64Var(&c.MalformedRate, "fault.malformed-rate", 0, "Fraction of aircraft.json responses to cut short")
+	f.StringVar(&c.Emergency, "fault.emergency", "", "Emergency squawk, 7500, 7600 or 7700, for a random aircraft to start squawking")
+	f.DurationVar(&c.EmergencyAfter, "fault.emergency-after", 0, "How long after starting the emergency begins")
+	f.DurationVar(&c.StaleAfter, "fault.stale-after", 0, "How long after starting aircraft.json freezes, 0 never freezes it")
+	f.DurationVar(&c.StaleFor, "fault.stale-for", 30*time.Second, "How long aircraft.json stays frozen")
+}
+
+func main() {
+	var config Config
+	config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	logger = log.With(logger, "ts", log.DefaultTimestamp, "caller", log.DefaultCaller)
+
+	sim := simulator.New(config.Simulator, time.Now())
+	srv := simulator.NewServer(logger, sim)
+	if err := srv.SetMalformedRate(config.MalformedRate); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid fault.malformed-rate: %v\n", err)
+		os.Exit(1)
+	}
+
+	var beast, sbs *simulator.Stream
+	var err error
+	if config.BeastListenAddress != "" {
+		if beast, err = simulator.Listen(logger, config.BeastListenAddress); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start the beast stream: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if config.SBSListenAddress != "" {
+		if sbs, err = simulator.Listen(logger, config.SBSListenAddress); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start the sbs stream: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	ln, err := net.Listen("tcp", config.HTTPListenAddress)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to listen: %v\n", err)
+		os.Exit(1)
+	}
+	httpSrv := &http.Server{Handler: srv}
+	go func() {
+		if err := httpSrv.Serve(ln); err != http.ErrServerClosed {
+			level.Error(logger).Log("msg", "http server stopped", "err", err)
+		}
+	}()
+	level.Info(logger).Log("msg", "simulating", "aircraft", config.Simulator.Aircraft, "listen_address", ln.Addr())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	start := time.Now()
+	emergencyStarted, staleStarted := config.Emergency == "", config.StaleAfter == 0
+	t := time.NewTicker(config.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-sigs:
+			httpSrv.Close()
+			if beast != nil {
+				beast.Close()
+			}
+			if sbs != nil {
+				sbs.Close()
+			}
+			level.Info(logger).Log("msg", "shutdown complete")
+			return
+		case now := <-t.C:
+			sim.Advance(now)
+			elapsed := now.Sub(start)
+			if !emergencyStarted && elapsed >= config.EmergencyAfter {
+				emergencyStarted = true
+				hex, err := sim.Emergency(config.Emergency)
+				if err != nil {
+					level.Error(logger).Log("msg", "failed to start emergency", "err", err)
+				} else {
+					level.Info(logger).Log("msg", "emergency started", "hex", hex, "squawk", config.Emergency)
+				}
+			}
+			if !staleStarted && elapsed >= config.StaleAfter {
+				staleStarted = true
+				level.Info(logger).Log("msg", "aircraft.json is stale", "for", config.StaleFor)
+				srv.SetStale(true)
+				time.AfterFunc(config.StaleFor, func() {
+					srv.SetStale(false)
+					level.Info(logger).Log("msg", "aircraft.json is no longer stale")
+				})
+			}
+			if srv.Stale() {
+				continue
+			}
+			b, s := sim.Streams()
+			if beast != nil {
+				beast.Send(b)
+			}
+			if sbs != nil {
+				sbs.Send(s)
+			}
+		}
+	}
+}
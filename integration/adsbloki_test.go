@@ -0,0 +1,325 @@
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	"github.com/grafana/loki/clients/pkg/promtail/client"
+
+	"github.com/slim-bean/adsb-loki/pkg/adsbloki"
+	"github.com/slim-bean/adsb-loki/pkg/aircraft"
+	"github.com/slim-bean/adsb-loki/pkg/cfg"
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+	"github.com/slim-bean/adsb-loki/pkg/simulator"
+	"github.com/slim-bean/adsb-loki/pkg/watchlist"
+)
+
+// harness is adsb-loki running in process against a simulated receiver and a fake Loki.
+type harness struct {
+	sim      *simulator.Simulator
+	dump     *simulator.Server
+	loki     *FakeLoki
+	config   *cfg.Config
+	stopSim  chan struct{}
+	simDone  chan struct{}
+	started  time.Time
+	aircraft []string
+}
+
+func newHarness(t *testing.T, aircraftCount int) *harness {
+	dir := t.TempDir()
+	h := &harness{
+		sim:     simulator.New(simulator.Config{Lat: 51.47, Lon: -0.46, Aircraft: aircraftCount, RangeKm: 250, Seed: 7}, time.Now()),
+		loki:    NewFakeLoki(),
+		stopSim: make(chan struct{}),
+		simDone: make(chan struct{}),
+		started: time.Now(),
+	}
+	t.Cleanup(h.loki.Close)
+	h.dump = simulator.NewServer(log.NewNopLogger(), h.sim)
+	dump := httptest.NewServer(h.dump)
+	t.Cleanup(dump.Close)
+	for _, ac := range h.sim.Report().Aircraft {
+		h.aircraft = append(h.aircraft, ac.Hex)
+	}
+
+	// Move the aircraft on in real time so every fetch is a new report.
+	go func() {
+		defer close(h.simDone)
+		tick := time.NewTicker(100 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-h.stopSim:
+				return
+			case now := <-tick.C:
+				h.sim.Advance(now)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(h.stopSim)
+		<-h.simDone
+	})
+
+	// Start from the defaults, the same as running with no flags.
+	h.config = &cfg.Config{}
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	h.config.RegisterFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	h.config.ClientConfigs = []client.Config{clientConfig(t, h.loki)}
+	h.config.ADSBURL = dump.URL + "/data/aircraft.json"
+	h.config.AircraftManagerConfig.Directory = dir
+	h.config.AircraftManagerConfig.BoltDbFile = filepath.Join(dir, "aircraft.db")
+	h.config.RouteConfig.BoltDbFile = filepath.Join(dir, "routes.db")
+	h.config.OverflightConfig.BoltDbFile = filepath.Join(dir, "overflights.db")
+	return h
+}
+
+// clientConfig pushes to loki with short waits so tests don't have to.
+func clientConfig(t *testing.T, loki *FakeLoki) client.Config {
+	pushURL, err := url.Parse(loki.PushURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Config{
+		URL:           flagext.URLValue{URL: pushURL},
+		BatchWait:     100 * time.Millisecond,
+		BatchSize:     client.BatchSize,
+		Timeout:       5 * time.Second,
+		BackoffConfig: util.BackoffConfig{MinBackoff: 10 * time.Millisecond, MaxBackoff: 100 * time.Millisecond, MaxRetries: 3},
+	}
+}
+
+// waitFor polls until cond is true or fails the test after the timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func Test_EndToEnd(t *testing.T) {
+	h := newHarness(t, 5)
+
+	// The first aircraft gets local details and a tag which is turned into a label.
+	tagged := h.aircraft[0]
+	overrides := filepath.Join(t.TempDir(), "overrides.yaml")
+	err := ioutil.WriteFile(overrides, []byte(fmt.Sprintf("aircraft:\n  %s:\n    registration: N123PD\n    owner: County Sheriff\n    tags: [police]\n", tagged)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.config.AircraftManagerConfig.OverridesFile = overrides
+	h.config.Labels.Tags = flagext.StringSliceCSV{"police"}
+
+	emergency, err := h.sim.Emergency("7700")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := log.NewNopLogger()
+	am, err := aircraft.NewAircraftManager(logger, h.config.AircraftManagerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := adsbloki.NewADSBLoki(logger, h.config, am, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.StartAndAwaitRunning(context.Background(), al); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for a few reports of every aircraft, and the emergency event, to reach Loki.
+	waitFor(t, 15*time.Second, "aircraft streams", func() bool {
+		for _, hex := range h.aircraft {
+			n := 0
+			for _, s := range h.loki.Streams(map[string]string{"job": "adsb", "hex": hex}) {
+				n += len(s.Entries)
+			}
+			if n < 3 {
+				return false
+			}
+		}
+		return len(h.loki.Streams(map[string]string{"event": event.EmergencyStart})) > 0
+	})
+
+	if err := services.StopAndAwaitTerminated(context.Background(), al); err != nil {
+		t.Fatal(err)
+	}
+	stopped := time.Now()
+	flushed := h.loki.Entries()
+	time.Sleep(1500 * time.Millisecond)
+	if n := h.loki.Entries(); n != flushed {
+		t.Errorf("expected nothing to be pushed after Stop, got %d more entries", n-flushed)
+	}
+	if n := h.loki.OutOfOrder(); n != 0 {
+		t.Errorf("expected every stream to be in order, %d entries were rejected", n)
+	}
+
+	for _, hex := range h.aircraft {
+		streams := h.loki.Streams(map[string]string{"job": "adsb", "hex": hex})
+		if len(streams) != 1 {
+			t.Fatalf("expected one stream for %s, got %d", hex, len(streams))
+		}
+		s := streams[0]
+		if hex == tagged && (s.Labels["registration"] != "N123PD" || s.Labels["tag_police"] != "true") {
+			t.Errorf("expected registration and tag labels from the overrides, got %v", s.Labels)
+		}
+		for i, e := range s.Entries {
+			if e.Timestamp.Before(h.started.Add(-time.Second)) || e.Timestamp.After(stopped) {
+				t.Errorf("%s: timestamp %s is not from the report", hex, e.Timestamp)
+			}
+			if i > 0 && !e.Timestamp.After(s.Entries[i-1].Timestamp) {
+				t.Errorf("%s: timestamps don't increase at %d", hex, i)
+			}
+			ac := model.Aircraft{}
+			if err := json.Unmarshal([]byte(e.Line), &ac); err != nil {
+				t.Fatalf("%s: invalid line %q: %s", hex, e.Line, err)
+			}
+			if ac.Hex != hex || ac.Flight == nil || *ac.Flight != strings.TrimSpace(*ac.Flight) {
+				t.Errorf("%s: unexpected line %s", hex, e.Line)
+			}
+			if hex == tagged && (ac.Owner == nil || *ac.Owner != "County Sheriff") {
+				t.Errorf("expected the owner from the overrides, got %s", e.Line)
+			}
+			if hex == emergency && (ac.SquawkInfo == nil || !ac.SquawkInfo.Emergency) {
+				t.Errorf("expected the emergency squawk to be described, got %s", e.Line)
+			}
+		}
+	}
+
+	events := h.loki.Streams(map[string]string{"job": "adsb", "event": event.EmergencyStart})
+	if len(events) != 1 || len(events[0].Entries) != 1 {
+		t.Fatalf("expected one emergency event, got %v", events)
+	}
+	e := event.Event{}
+	if err := json.Unmarshal([]byte(events[0].Entries[0].Line), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Hex != emergency || !e.Time.Equal(events[0].Entries[0].Timestamp) {
+		t.Errorf("unexpected emergency event %+v", e)
+	}
+}
+
+func Test_Reload(t *testing.T) {
+	h := newHarness(t, 3)
+	logger := log.NewNopLogger()
+	am, err := aircraft.NewAircraftManager(logger, h.config.AircraftManagerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := adsbloki.NewADSBLoki(logger, h.config, am, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.StartAndAwaitRunning(context.Background(), al); err != nil {
+		t.Fatal(err)
+	}
+	defer services.StopAndAwaitTerminated(context.Background(), al)
+	hex := h.aircraft[0]
+	waitFor(t, 15*time.Second, "the first Loki", func() bool {
+		return len(h.loki.Streams(map[string]string{"hex": hex})) > 0
+	})
+
+	// An invalid watchlist fails the whole reload so the new client is never used.
+	second := NewFakeLoki()
+	defer second.Close()
+	invalid := *h.config
+	invalid.ClientConfigs = []client.Config{clientConfig(t, second)}
+	invalid.WatchlistConfig.Rules = []watchlist.Rule{{Name: "empty"}}
+	if err := al.Reload(&invalid); err == nil {
+		t.Fatal("expected the invalid watchlist to fail the reload")
+	}
+
+	next := *h.config
+	next.ClientConfigs = []client.Config{clientConfig(t, second)}
+	next.WatchlistConfig.Rules = []watchlist.Rule{{Name: "followed", Hex: []string{hex}}}
+	if err := al.Reload(&next); err != nil {
+		t.Fatal(err)
+	}
+	// The old client is flushed by the reload, nothing more is sent to it.
+	sent := h.loki.Entries()
+	waitFor(t, 15*time.Second, "the second Loki", func() bool {
+		return len(second.Streams(map[string]string{"hex": hex, "watchlist": "followed"})) > 0
+	})
+	if n := h.loki.Entries(); n != sent {
+		t.Errorf("expected nothing to be sent to the old client after the reload, got %d more entries", n-sent)
+	}
+	if len(second.Streams(map[string]string{"event": event.Watchlist})) != 1 {
+		t.Error("expected a watchlist event from the new rule")
+	}
+	if h.loki.OutOfOrder() != 0 || second.OutOfOrder() != 0 {
+		t.Error("expected every stream to be in order")
+	}
+}
+
+func Test_FeedStale(t *testing.T) {
+	h := newHarness(t, 3)
+	h.config.SourceConfig.Interval = 100 * time.Millisecond
+	h.config.SourceConfig.StaleAfter = time.Second
+	logger := log.NewNopLogger()
+	am, err := aircraft.NewAircraftManager(logger, h.config.AircraftManagerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := adsbloki.NewADSBLoki(logger, h.config, am, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := services.StartAndAwaitRunning(context.Background(), al); err != nil {
+		t.Fatal(err)
+	}
+	defer services.StopAndAwaitTerminated(context.Background(), al)
+	hex := h.aircraft[0]
+	entries := func() int {
+		n := 0
+		for _, s := range h.loki.Streams(map[string]string{"hex": hex}) {
+			n += len(s.Entries)
+		}
+		return n
+	}
+	waitFor(t, 15*time.Second, "reports", func() bool { return entries() > 0 })
+
+	// A frozen aircraft.json keeps the same now, it is only sent once and the feed goes stale.
+	if err := h.dump.SetStale(true); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 15*time.Second, "the feed stale event", func() bool {
+		return len(h.loki.Streams(map[string]string{"event": event.FeedStale})) == 1
+	})
+	frozen := entries()
+	time.Sleep(500 * time.Millisecond)
+	if n := entries(); n > frozen {
+		t.Errorf("expected the frozen report to be skipped, got %d more entries", n-frozen)
+	}
+
+	if err := h.dump.SetStale(false); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 15*time.Second, "the feed resumed event", func() bool {
+		return len(h.loki.Streams(map[string]string{"event": event.FeedResumed})) == 1 && entries() > frozen
+	})
+	if h.loki.OutOfOrder() != 0 {
+		t.Error("expected every stream to be in order")
+	}
+}
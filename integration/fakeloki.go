@@ -0,0 +1,157 @@
+// Package integration runs adsb-loki end to end against a simulated receiver and a fake Loki.
+package integration
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+var labelPattern = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+
+// Stream is everything pushed for one set of labels, in the order it arrived.
+type Stream struct {
+	Labels  map[string]string
+	Entries []logproto.Entry
+}
+
+// FakeLoki accepts snappy compressed protobuf pushes on /loki/api/v1/push and keeps every stream in memory.
+// Like Loki it rejects an entry older than the newest in its stream, those are counted as OutOfOrder.
+type FakeLoki struct {
+	*httptest.Server
+
+	mtx        sync.Mutex
+	streams    map[string]*Stream
+	outOfOrder int
+}
+
+func NewFakeLoki() *FakeLoki {
+	l := &FakeLoki{streams: map[string]*Stream{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loki/api/v1/push", l.push)
+	l.Server = httptest.NewServer(mux)
+	return l
+}
+
+// PushURL is the URL to configure the promtail client with.
+func (l *FakeLoki) PushURL() string {
+	return l.URL + "/loki/api/v1/push"
+}
+
+func parseLabels(s string) (map[string]string, error) {
+	lbls := map[string]string{}
+	for _, m := range labelPattern.FindAllStringSubmatch(s, -1) {
+		v, err := strconv.Unquote(`"` + m[2] + `"`)
+		if err != nil {
+			return nil, err
+		}
+		lbls[m[1]] = v
+	}
+	if len(lbls) == 0 {
+		return nil, fmt.Errorf("no labels in %q", s)
+	}
+	return lbls, nil
+}
+
+// key is the labels sorted so the same labels in any order are the same stream.
+func key(lbls map[string]string) string {
+	names := make([]string, 0, len(lbls))
+	for n := range lbls {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	k := ""
+	for _, n := range names {
+		k += n + "=" + strconv.Quote(lbls[n]) + ","
+	}
+	return k
+}
+
+func (l *FakeLoki) push(w http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		http.Error(w, "unexpected content type "+ct, http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := logproto.PushRequest{}
+	if err := req.Unmarshal(decoded); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	for _, s := range req.Streams {
+		lbls, err := parseLabels(s.Labels)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		k := key(lbls)
+		stream, ok := l.streams[k]
+		if !ok {
+			stream = &Stream{Labels: lbls}
+			l.streams[k] = stream
+		}
+		for _, e := range s.Entries {
+			if n := len(stream.Entries); n > 0 && e.Timestamp.Before(stream.Entries[n-1].Timestamp) {
+				l.outOfOrder++
+				continue
+			}
+			stream.Entries = append(stream.Entries, e)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Streams returns a copy of every stream which has all of the matchers as labels.
+func (l *FakeLoki) Streams(matchers map[string]string) []Stream {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	var streams []Stream
+	for _, s := range l.streams {
+		matches := true
+		for n, v := range matchers {
+			matches = matches && s.Labels[n] == v
+		}
+		if matches {
+			streams = append(streams, Stream{Labels: s.Labels, Entries: append([]logproto.Entry(nil), s.Entries...)})
+		}
+	}
+	return streams
+}
+
+// Entries counts the entries received in every stream.
+func (l *FakeLoki) Entries() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	n := 0
+	for _, s := range l.streams {
+		n += len(s.Entries)
+	}
+	return n
+}
+
+// OutOfOrder counts the entries which were rejected for being older than their stream.
+func (l *FakeLoki) OutOfOrder() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	return l.outOfOrder
+}
@@ -1,17 +1,37 @@
 package adsbloki
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
 	"github.com/grafana/loki/clients/pkg/promtail/api"
 	"github.com/grafana/loki/pkg/logproto"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/slim-bean/adsb-loki/pkg/piaware"
 	"time"
 
+	"github.com/cortexproject/cortex/pkg/util/services"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
 	"github.com/prometheus/common/model"
 	"github.com/slim-bean/adsb-loki/pkg/aircraft"
+	"github.com/slim-bean/adsb-loki/pkg/alert"
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/geofence"
+	adsbmodel "github.com/slim-bean/adsb-loki/pkg/model"
+	"github.com/slim-bean/adsb-loki/pkg/overflight"
+	"github.com/slim-bean/adsb-loki/pkg/recording"
+	"github.com/slim-bean/adsb-loki/pkg/source"
+	"github.com/slim-bean/adsb-loki/pkg/squawk"
+	"github.com/slim-bean/adsb-loki/pkg/track"
+	"github.com/slim-bean/adsb-loki/pkg/watchlist"
 
 	"github.com/grafana/loki/clients/pkg/promtail/client"
 	"github.com/grafana/loki/pkg/util/flagext"
@@ -20,87 +40,316 @@ import (
 )
 
 type aDSBLoki struct {
-	config   *cfg.Config
-	logger   log.Logger
-	client   client.Client
-	pi       *piaware.Piaware
-	shutdown chan struct{}
-	done     chan struct{}
+	services.Service
+
+	config    *cfg.Config
+	logger    log.Logger
+	client    client.Client
+	source    source.Source
+	replay    *recording.Replay
+	recorder  *recording.Recorder
+	pipeline  *Pipeline
+	zones     *geofence.Tracker
+	passes    *overflight.Tracker
+	tracks    *track.Store
+	detectors []event.Detector
+	alerts    *alert.Dispatcher
+	events    event.Sinks
+
+	// mtx is held while processing a report so a reload is applied between reports.
+	mtx sync.Mutex
+	// reloadMtx stops reloads from overlapping.
+	reloadMtx sync.Mutex
 }
 
-func NewADSBLoki(logger log.Logger, cfg *cfg.Config, am *aircraft.Manager) (*aDSBLoki, error) {
+// NewADSBLoki builds the pipeline, it runs when the service is started. HTTP handlers are added to router if it's not nil.
+func NewADSBLoki(logger log.Logger, cfg *cfg.Config, am *aircraft.Manager, router *mux.Router) (*aDSBLoki, error) {
 	c, err := client.NewMulti(prometheus.DefaultRegisterer, logger, flagext.LabelSet{}, cfg.ClientConfigs...)
 	if err != nil {
 		level.Error(logger).Log("msg", "failed to create new Loki client(s)", "err", err)
 		return nil, err
 	}
 
-	pa := piaware.New(am, cfg.ADSBURL)
+	pl, err := NewPipeline(logger, cfg, am)
+	if err != nil {
+		return nil, err
+	}
+	// The geofences always run, without any zones they do nothing until some are added by a reload.
+	zones, err := geofence.New(cfg.GeofenceConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to load geofences", "err", err)
+		return nil, err
+	}
+	detectors := []event.Detector{squawk.NewMonitor(cfg.SquawkConfig), pl.Watchlist(), zones}
+
+	var passes *overflight.Tracker
+	if len(cfg.OverflightConfig.Points) > 0 {
+		passes, err = overflight.New(logger, cfg.OverflightConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to start overflight tracking", "err", err)
+			return nil, err
+		}
+		detectors = append(detectors, passes)
+		if router != nil {
+			router.Handle("/overflights", passes).Methods(http.MethodGet)
+		}
+	}
+
+	var tracks *track.Store
+	if cfg.TrackConfig.Retention > 0 {
+		tracks = track.NewStore(cfg.TrackConfig)
+		if router != nil {
+			router.Handle("/tracks", tracks).Methods(http.MethodGet)
+			router.Handle("/tracks/{hex}", tracks).Methods(http.MethodGet)
+		}
+	}
+
+	var src source.Source
+	var replay *recording.Replay
+	var receiver *piaware.Piaware
+	if cfg.RecordingConfig.Replay != "" {
+		replay, err = recording.NewReplay(logger, cfg.RecordingConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to open recordings to replay", "err", err)
+			return nil, err
+		}
+		src = replay
+	} else {
+		receiver, err = piaware.New(cfg.ADSBURL, cfg.SourceConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to configure the receiver", "err", err)
+			return nil, err
+		}
+	}
+
+	var recorder *recording.Recorder
+	if cfg.RecordingConfig.Dir != "" {
+		recorder, err = recording.NewRecorder(logger, cfg.RecordingConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to start recording", "err", err)
+			return nil, err
+		}
+	}
 
 	adsb := &aDSBLoki{
-		config:   cfg,
-		logger:   log.With(logger, "component", "adsbloki"),
-		client:   c,
-		pi:       pa,
-		shutdown: make(chan struct{}),
-		done:     make(chan struct{}),
+		config:    cfg,
+		logger:    log.With(logger, "component", "adsbloki"),
+		client:    c,
+		source:    src,
+		replay:    replay,
+		recorder:  recorder,
+		pipeline:  pl,
+		zones:     zones,
+		passes:    passes,
+		tracks:    tracks,
+		detectors: detectors,
+	}
+	adsb.events = event.Sinks{eventSink{adsb}}
+	if cfg.AlertConfig.Enabled() {
+		adsb.alerts, err = alert.New(logger, cfg.AlertConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to configure alerts", "err", err)
+			return nil, err
+		}
+		adsb.events = append(adsb.events, adsb.alerts)
+	}
+	if receiver != nil {
+		// The poller sends feed events between reports so they need the lock process holds.
+		adsb.source = source.NewPoller(receiver, cfg.SourceConfig.Config, lockedSink{adsb})
 	}
 
-	go adsb.run()
+	adsb.Service = services.NewBasicService(nil, adsb.running, adsb.stopping).WithName("adsb-loki")
 	level.Info(logger).Log("msg", "initialized")
 	return adsb, nil
 }
 
-func (a *aDSBLoki) run() {
-	t := time.NewTicker(time.Second)
-	defer func() {
-		t.Stop()
-		level.Info(a.logger).Log("msg", "run loop shut down")
-		close(a.done)
-	}()
+func (a *aDSBLoki) running(ctx context.Context) error {
+	defer level.Info(a.logger).Log("msg", "run loop shut down")
 	level.Info(a.logger).Log("msg", "run loop started")
 	for {
-		select {
-		case <-a.shutdown:
+		rpt, err := a.source.Next(ctx)
+		if ctx.Err() != nil {
 			level.Info(a.logger).Log("msg", "run loop shutting down")
-			return
-		case <-t.C:
-			rpt, err := a.pi.GetReport()
-			if err != nil {
-				level.Error(a.logger).Log("msg", "error getting report", "err", err)
-				continue
-			}
-			for _, ac := range rpt.Aircraft {
-				bts, err := json.Marshal(ac)
-				if err != nil {
-					level.Error(a.logger).Log("msg", "error getting aircraft info", "err", err)
-					continue
-				}
-				lbls := model.LabelSet{
-					model.LabelName("job"): model.LabelValue("adsb"),
-					model.LabelName("hex"): model.LabelValue(ac.Hex),
-				}
-				if ac.Registration != nil {
-					lbls[model.LabelName("registration")] = model.LabelValue(*ac.Registration)
-				}
-				e := api.Entry{
-					Labels: lbls,
-					Entry: logproto.Entry{
-						Timestamp: time.Unix(int64(rpt.Now), 0),
-						Line:      string(bts),
-					},
-				}
-				a.client.Chan() <- e
-			}
+			return nil
+		}
+		if err == io.EOF {
+			level.Info(a.logger).Log("msg", "replay finished")
+			return nil
+		}
+		if err != nil {
+			level.Error(a.logger).Log("msg", "error getting report", "err", err)
+			continue
 		}
+		if a.recorder != nil {
+			a.recorder.Record(time.Now(), rpt)
+		}
+		a.process(rpt)
 	}
 }
 
-func (a *aDSBLoki) Stop() {
-	level.Info(a.logger).Log("msg", "shutdown called")
-	close(a.shutdown)
-	<-a.done
+// process runs a report through the pipeline, detectors and sinks.
+func (a *aDSBLoki) process(rpt *adsbmodel.Report) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.pipeline.Process(rpt)
+	for _, d := range a.detectors {
+		for _, e := range d.Process(rpt) {
+			a.events.Send(e)
+		}
+	}
+	if a.tracks != nil {
+		a.tracks.Add(rpt)
+	}
+	for _, ac := range rpt.Aircraft {
+		bts, err := json.Marshal(ac)
+		if err != nil {
+			level.Error(a.logger).Log("msg", "error getting aircraft info", "err", err)
+			continue
+		}
+		e := api.Entry{
+			Labels: a.pipeline.Labels(ac),
+			Entry: logproto.Entry{
+				Timestamp: rpt.Time(),
+				Line:      string(bts),
+			},
+		}
+		a.client.Chan() <- e
+	}
+}
+
+// lockedSink sends events from outside process, without racing a reload which switches clients.
+type lockedSink struct {
+	a *aDSBLoki
+}
+
+func (s lockedSink) Send(e event.Event) {
+	s.a.mtx.Lock()
+	defer s.a.mtx.Unlock()
+	s.a.events.Send(e)
+}
+
+// eventSink sends events to Loki as a separate stream for each type of event.
+type eventSink struct {
+	a *aDSBLoki
+}
+
+func (s eventSink) Send(e event.Event) {
+	bts, err := json.Marshal(e)
+	if err != nil {
+		level.Error(s.a.logger).Log("msg", "error marshalling event", "err", err)
+		return
+	}
+	s.a.client.Chan() <- api.Entry{
+		Labels: model.LabelSet{
+			model.LabelName("job"):   model.LabelValue("adsb"),
+			model.LabelName("event"): model.LabelValue(e.Type),
+		},
+		Entry: logproto.Entry{
+			Timestamp: e.Time,
+			Line:      string(bts),
+		},
+	}
+}
+
+// reloadable are the config sections Reload applies, any other change needs a restart.
+var reloadable = []string{"clients", "labels", "watchlist", "geofences"}
+
+func isReloadable(key string) bool {
+	for _, r := range reloadable {
+		if key == r || strings.HasPrefix(key, r+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload applies the labels, watchlist, geofences and Loki clients from config, any other changes are logged and ignored.
+// Nothing is changed if any of them are invalid. Replaced clients are stopped after the switch so entries they have queued are still sent.
+func (a *aDSBLoki) Reload(config *cfg.Config) error {
+	a.reloadMtx.Lock()
+	defer a.reloadMtx.Unlock()
+	if s := a.State(); s != services.Running {
+		return fmt.Errorf("can't reload while %s", s)
+	}
+	current := a.currentConfig()
+	changed := cfg.Diff(current, config)
+	if len(changed) == 0 {
+		level.Info(a.logger).Log("msg", "config unchanged")
+		return nil
+	}
+
+	wl, err := watchlist.New(config.WatchlistConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load watchlist: %s", err)
+	}
+	zones, err := geofence.New(config.GeofenceConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load geofences: %s", err)
+	}
+	var c client.Client
+	if !reflect.DeepEqual(current.ClientConfigs, config.ClientConfigs) {
+		c, err = client.NewMulti(prometheus.DefaultRegisterer, a.logger, flagext.LabelSet{}, config.ClientConfigs...)
+		if err != nil {
+			return fmt.Errorf("failed to create new Loki client(s): %s", err)
+		}
+	}
+
+	// Only the reloadable sections are applied, so other changes are reported again by the next reload until a restart.
+	applied := *current
+	applied.ClientConfigs = config.ClientConfigs
+	applied.Labels = config.Labels
+	applied.WatchlistConfig = config.WatchlistConfig
+	applied.GeofenceConfig = config.GeofenceConfig
+
+	a.mtx.Lock()
+	a.pipeline.reload(&applied, wl)
+	a.zones.Update(zones)
+	old := a.client
+	if c != nil {
+		a.client = c
+	}
+	a.config = &applied
+	a.mtx.Unlock()
+	if c != nil {
+		old.Stop()
+	}
+
+	for _, key := range changed {
+		if isReloadable(key) {
+			level.Info(a.logger).Log("msg", "config changed", "key", key)
+		} else {
+			level.Warn(a.logger).Log("msg", "config changed but needs a restart to take effect", "key", key)
+		}
+	}
+	return nil
+}
+
+func (a *aDSBLoki) currentConfig() *cfg.Config {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.config
+}
+
+// stopping flushes everything still queued for Loki and the alert sinks before closing the route and overflight dbs.
+func (a *aDSBLoki) stopping(_ error) error {
 	level.Info(a.logger).Log("msg", "closing clients")
+	if a.alerts != nil {
+		a.alerts.Stop()
+	}
+	// A reload which started before stopping has switched clients by the time the lock is free.
+	a.mtx.Lock()
 	a.client.Stop()
+	a.mtx.Unlock()
+	a.pipeline.Stop()
+	if a.recorder != nil {
+		a.recorder.Stop()
+	}
+	if a.replay != nil {
+		a.replay.Stop()
+	}
+	if a.passes != nil {
+		a.passes.Stop()
+	}
 	level.Info(a.logger).Log("msg", "clients close, shutdown complete")
+	return nil
 }
@@ -0,0 +1,159 @@
+package adsbloki
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/common/model"
+
+	"github.com/slim-bean/adsb-loki/pkg/aircraft"
+	"github.com/slim-bean/adsb-loki/pkg/cfg"
+	"github.com/slim-bean/adsb-loki/pkg/enrich"
+	"github.com/slim-bean/adsb-loki/pkg/icao"
+	"github.com/slim-bean/adsb-loki/pkg/icaotype"
+	adsbmodel "github.com/slim-bean/adsb-loki/pkg/model"
+	"github.com/slim-bean/adsb-loki/pkg/operator"
+	"github.com/slim-bean/adsb-loki/pkg/privacy"
+	"github.com/slim-bean/adsb-loki/pkg/route"
+	"github.com/slim-bean/adsb-loki/pkg/squawk"
+	"github.com/slim-bean/adsb-loki/pkg/watchlist"
+)
+
+// Pipeline enriches and filters reports and labels their aircraft, it is shared by the live run loop
+// and anything importing old reports so both end up with the same lines in Loki.
+type Pipeline struct {
+	config    *cfg.Config
+	enricher  enrich.Enricher
+	privacy   *privacy.Filter
+	watchlist *watchlist.Watchlist
+	routes    *route.Provider
+	tagLabels map[string]model.LabelName
+}
+
+func NewPipeline(logger log.Logger, cfg *cfg.Config, am *aircraft.Manager) (*Pipeline, error) {
+	ops, err := operator.New(logger, cfg.OperatorConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to load operators", "err", err)
+		return nil, err
+	}
+
+	// The address lookup runs before the operators so callsigns matching a computed registration are recognised.
+	chain := enrich.Chain{am, icao.Enricher{}, ops}
+
+	if cfg.AircraftTypeConfig.File != "" {
+		types, err := icaotype.New(logger, cfg.AircraftTypeConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to load aircraft types", "err", err)
+			return nil, err
+		}
+		chain = append(chain, types)
+	}
+
+	var routes *route.Provider
+	if cfg.RouteConfig.RoutesFile != "" {
+		routes, err = route.New(logger, cfg.RouteConfig)
+		if err != nil {
+			level.Error(logger).Log("msg", "failed to load routes", "err", err)
+			return nil, err
+		}
+		chain = append(chain, routes)
+	}
+
+	squawks, err := squawk.New(cfg.SquawkConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to load squawk codes", "err", err)
+		return nil, err
+	}
+	chain = append(chain, squawks)
+
+	pf, err := privacy.New(cfg.PrivacyConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to configure privacy", "err", err)
+		return nil, err
+	}
+
+	// The watchlist runs after the privacy filter so rules can't match on anything which was redacted.
+	wl, err := watchlist.New(cfg.WatchlistConfig)
+	if err != nil {
+		level.Error(logger).Log("msg", "failed to load watchlist", "err", err)
+		return nil, err
+	}
+
+	return &Pipeline{
+		config:    cfg,
+		enricher:  chain,
+		privacy:   pf,
+		watchlist: wl,
+		routes:    routes,
+		tagLabels: tagLabels(cfg.Labels.Tags),
+	}, nil
+}
+
+func tagLabels(tags []string) map[string]model.LabelName {
+	lbls := map[string]model.LabelName{}
+	for _, t := range tags {
+		lbls[t] = cfg.TagLabelName(t)
+	}
+	return lbls
+}
+
+// reload switches to the labels and watchlist rules of cfg, the watchlist keeps what it has already spotted.
+func (p *Pipeline) reload(cfg *cfg.Config, wl *watchlist.Watchlist) {
+	p.config = cfg
+	p.tagLabels = tagLabels(cfg.Labels.Tags)
+	p.watchlist.Update(wl)
+}
+
+// Process enriches the report in place and applies the privacy filter and watchlist.
+func (p *Pipeline) Process(rpt *adsbmodel.Report) {
+	p.enricher.Enrich(rpt)
+	// Nothing may see the report before the privacy filter, it must stay directly after enrichment.
+	p.privacy.Apply(rpt)
+	p.watchlist.Enrich(rpt)
+}
+
+// Flush returns the positions the privacy filter is holding back which are due by until, after the watchlist
+// has run on them the same as in Process.
+func (p *Pipeline) Flush(until time.Time) []*adsbmodel.Report {
+	rpts := p.privacy.Flush(until)
+	for _, rpt := range rpts {
+		p.watchlist.Enrich(rpt)
+	}
+	return rpts
+}
+
+// Watchlist returns the watchlist used by Process so it can also be run as a detector.
+func (p *Pipeline) Watchlist() *watchlist.Watchlist {
+	return p.watchlist
+}
+
+// Labels returns the Loki stream labels for an aircraft which has been through Process.
+func (p *Pipeline) Labels(ac adsbmodel.Aircraft) model.LabelSet {
+	lbls := model.LabelSet{
+		model.LabelName("job"): model.LabelValue("adsb"),
+		model.LabelName("hex"): model.LabelValue(ac.Hex),
+	}
+	if ac.Registration != nil {
+		lbls[model.LabelName("registration")] = model.LabelValue(*ac.Registration)
+	}
+	if p.config.Labels.Operator && ac.Operator != nil {
+		lbls[model.LabelName("operator")] = model.LabelValue(ac.Operator.ICAO)
+	}
+	if len(ac.Watchlist) > 0 {
+		lbls[model.LabelName("watchlist")] = model.LabelValue(strings.Join(ac.Watchlist, ","))
+	}
+	for _, t := range ac.Tags {
+		if ln, ok := p.tagLabels[t]; ok {
+			lbls[ln] = model.LabelValue("true")
+		}
+	}
+	return lbls
+}
+
+func (p *Pipeline) Stop() {
+	if p.routes != nil {
+		p.routes.Stop()
+	}
+}
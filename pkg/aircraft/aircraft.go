@@ -1,31 +1,50 @@
 package aircraft
 
 import (
-	"bufio"
 	"compress/gzip"
+	"context"
 	"encoding/csv"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
-	"unsafe"
 
+	"github.com/cortexproject/cortex/pkg/util/services"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/gocarina/gocsv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/slim-bean/adsb-loki/pkg/download"
 	"github.com/slim-bean/adsb-loki/pkg/model"
 	bolt "go.etcd.io/bbolt"
 )
 
 const (
-	regfile     = "aircraft.csv.gz"
-	tempRegfile = "aircraft.csv.gz.tmp"
+	regfile = "aircraft.csv.gz"
+
+	// maxLoggedParseErrors is how many skipped lines are logged individually after an import.
+	maxLoggedParseErrors = 10
+)
+
+var (
+	aircraftBucket = []byte("aircraft")
+
+	importedRecords = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "adsb_loki",
+		Name:      "aircraft_import_records",
+		Help:      "Number of aircraft records stored by the last import of the registration file.",
+	})
+	importSkippedRecords = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "adsb_loki",
+		Name:      "aircraft_import_skipped_records",
+		Help:      "Number of malformed records skipped by the last import of the registration file.",
+	})
 )
 
 var (
@@ -36,6 +55,12 @@ type Config struct {
 	Directory  string `yaml:"directory"`
 	BoltDbFile string `yaml:"db_file"`
 	URL        string `yaml:"url"`
+	CacheSize  int    `yaml:"cache_size"`
+
+	OverridesFile          string        `yaml:"overrides_file"`
+	OverridesCheckInterval time.Duration `yaml:"overrides_check_interval"`
+
+	Download download.Config `yaml:"download,omitempty"`
 }
 
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
@@ -46,14 +71,34 @@ func (c *Config) RegisterFlags(f *flag.FlagSet) {
 	f.StringVar(&c.Directory, "aircraft-manager.directory", path, "Where to save the downloaded aircraft info, defaults to the current working directory")
 	f.StringVar(&c.BoltDbFile, "aircraft-manager.db-file", filepath.Join(path, "aircraft.db"), "Where to save the aircraft db, defaults to the current working directory ./aircraft.db")
 	f.StringVar(&c.URL, "aircraft-manager.url", "https://github.com/wiedehopf/tar1090-db/raw/csv/aircraft.csv.gz", "Where to get aircraft information")
+	f.IntVar(&c.CacheSize, "aircraft-manager.cache-size", 10000, "How many decoded aircraft lookups (including unknown hexes) to keep in memory, 0 disables the cache")
+	f.StringVar(&c.OverridesFile, "aircraft-manager.overrides-file", "", "Optional YAML file of locally maintained aircraft details and tags which are merged over the downloaded details")
+	f.DurationVar(&c.OverridesCheckInterval, "aircraft-manager.overrides-check-interval", 10*time.Second, "How often to check the overrides file for changes")
+	c.Download.RegisterFlagsWithPrefix("aircraft-manager", f)
 }
 
+// Manager is a service which looks up aircraft in the boltdb opened by NewAircraftManager. Once running it
+// checks for a new registration file, then every minute, and loads it into the db, lookups use what is
+// already in the db until then. The db is closed when it stops.
 type Manager struct {
-	logger   log.Logger
-	config   Config
-	db       *bolt.DB
-	shutdown chan struct{}
-	done     chan struct{}
+	services.Service
+
+	logger     log.Logger
+	config     Config
+	db         *bolt.DB
+	downloader *download.Downloader
+	cache      *lruCache
+	overrides  *overrides
+	statsMtx   sync.Mutex
+	stats      ImportStats
+}
+
+// ImportStats describes the result of the last import of the registration file.
+type ImportStats struct {
+	Time    time.Time
+	Records int
+	Skipped int
+	Errors  []ParseError
 }
 
 func NewAircraftManager(logger log.Logger, config Config) (*Manager, error) {
@@ -62,11 +107,16 @@ func NewAircraftManager(logger log.Logger, config Config) (*Manager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error opening boltdb file: %s", err)
 	}
-	m := &Manager{
-		logger: log.With(logger, "component", "manager"),
-		config: config,
-		db:     db,
+	m := newManager(logger, config, db)
+	migrated, err := migrateEncoding(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating boltdb file to current encoding: %s", err)
+	}
+	if migrated > 0 {
+		level.Info(logger).Log("msg", "migrated aircraft records to current encoding", "records", migrated)
 	}
+	m.downloader = download.New(m.logger, config.Download, config.URL, path.Join(config.Directory, regfile), download.ValidateGzip)
 
 	gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
 		r := csv.NewReader(in)
@@ -74,115 +124,233 @@ func NewAircraftManager(logger log.Logger, config Config) (*Manager, error) {
 		r.Comma = ';'
 		return r
 	})
+	m.Service = services.NewBasicService(nil, m.running, m.stopping).WithName("aircraft-manager")
 	level.Info(logger).Log("msg", "mananger initialized")
 	return m, nil
 }
 
-func (m *Manager) Run() {
-	m.checkAndUpdateRegistrationFile()
-	m.loadRegistrationInfo()
-	go m.run()
+// OpenReadOnly opens the db written by a running manager for lookups only, nothing is downloaded and the
+// encoding isn't migrated. It fails after a second if another process has the db open for writing,
+// call Close when finished with it.
+func OpenReadOnly(logger log.Logger, config Config) (*Manager, error) {
+	db, err := bolt.Open(config.BoltDbFile, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("timed out waiting for %s, it is probably open in a running adsb-loki", config.BoltDbFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening boltdb file: %s", err)
+	}
+	return newManager(logger, config, db), nil
+}
+
+func newManager(logger log.Logger, config Config, db *bolt.DB) *Manager {
+	m := &Manager{
+		logger: log.With(logger, "component", "manager"),
+		config: config,
+		db:     db,
+	}
+	if config.CacheSize > 0 {
+		m.cache = newLRUCache(config.CacheSize)
+	}
+	if config.OverridesFile != "" {
+		m.overrides = newOverrides(m.logger, config.OverridesFile)
+		m.overrides.reloadIfChanged()
+	}
+	return m
+}
+
+// Close closes a db opened with OpenReadOnly, a started manager closes its db when it stops.
+func (m *Manager) Close() error {
+	return m.db.Close()
 }
 
-func (m *Manager) run() {
+func (m *Manager) running(ctx context.Context) error {
 	t := time.NewTicker(time.Minute)
+	// A nil channel never fires so the select below ignores overrides if they are not configured.
+	var overridesC <-chan time.Time
+	if m.overrides != nil {
+		ot := time.NewTicker(m.config.OverridesCheckInterval)
+		defer ot.Stop()
+		overridesC = ot.C
+	}
 	defer func() {
 		t.Stop()
 		level.Info(m.logger).Log("msg", "run loop shut down")
-		close(m.done)
 	}()
 	level.Info(m.logger).Log("msg", "run loop started")
+	// The download can take minutes so it's done here rather than when starting, nothing waits for it.
+	// The file is only loaded if it changed or the db has never been loaded.
+	if m.checkAndUpdateRegistrationFile(ctx) || ctx.Err() == nil && m.empty() {
+		m.loadRegistrationInfo()
+	}
 	for {
 		select {
-		case <-m.shutdown:
+		case <-ctx.Done():
 			level.Info(m.logger).Log("msg", "run loop shutting down")
-			return
+			return nil
 		case <-t.C:
-			if m.checkAndUpdateRegistrationFile() {
+			if m.checkAndUpdateRegistrationFile(ctx) {
 				m.loadRegistrationInfo()
 			}
+		case <-overridesC:
+			m.overrides.reloadIfChanged()
 		}
 	}
 }
 
+// Lookup returns the details for a single lowercase hex or nil if the aircraft is unknown.
+// The returned details may be shared with the cache and must not be modified.
 func (m *Manager) Lookup(hex string) *model.Details {
+	var gen uint64
+	if m.cache != nil {
+		if d, ok := m.cache.get(hex); ok {
+			return m.applyOverrides(hex, d)
+		}
+		gen = m.cache.generation()
+	}
 	var d *model.Details
 	err := m.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("aircraft"))
-		v := b.Get([]byte(hex))
-		if v != nil {
-			d = &model.Details{}
-			err := json.Unmarshal(v, d)
+		var err error
+		d, err = getDetails(tx.Bucket(aircraftBucket), hex)
+		return err
+	})
+	if err != nil {
+		level.Error(m.logger).Log("msg", "failed to retrieve aircraft info from boltdb", "err", err)
+		return nil
+	}
+	if m.cache != nil {
+		m.cache.add(hex, d, gen)
+	}
+	return m.applyOverrides(hex, d)
+}
+
+// LookupAll returns the details for every hex in hexes, in the same order, using at most one boltdb transaction.
+// Unknown aircraft have a nil entry in the returned slice.
+// The returned details may be shared with the cache and must not be modified.
+func (m *Manager) LookupAll(hexes []string) []*model.Details {
+	result := m.lookupAll(hexes)
+	if m.overrides != nil {
+		for i, hex := range hexes {
+			result[i] = m.overrides.apply(hex, result[i])
+		}
+	}
+	return result
+}
+
+func (m *Manager) lookupAll(hexes []string) []*model.Details {
+	result := make([]*model.Details, len(hexes))
+	misses := make([]int, 0, len(hexes))
+	for i, hex := range hexes {
+		if m.cache != nil {
+			if d, ok := m.cache.get(hex); ok {
+				result[i] = d
+				continue
+			}
+		}
+		misses = append(misses, i)
+	}
+	if len(misses) == 0 {
+		return result
+	}
+	var gen uint64
+	if m.cache != nil {
+		gen = m.cache.generation()
+	}
+	err := m.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(aircraftBucket)
+		for _, i := range misses {
+			d, err := getDetails(b, hexes[i])
 			if err != nil {
 				return err
 			}
+			result[i] = d
 		}
 		return nil
 	})
 	if err != nil {
 		level.Error(m.logger).Log("msg", "failed to retrieve aircraft info from boltdb", "err", err)
+		return result
 	}
-	return d
-}
-
-func (m *Manager) Stop() {
-	level.Info(m.logger).Log("msg", "stop called")
-	close(m.shutdown)
-	m.db.Close()
-	<-m.done
-	level.Info(m.logger).Log("msg", "shutdown complete")
+	if m.cache != nil {
+		for _, i := range misses {
+			m.cache.add(hexes[i], result[i], gen)
+		}
+	}
+	return result
 }
 
-func (m *Manager) checkAndUpdateRegistrationFile() bool {
-	fi, err := os.Stat(path.Join(m.config.Directory, regfile))
-	if err == nil {
-		if time.Since(fi.ModTime()) < 24*time.Hour {
-			return false
+// Enrich adds the registration details to every aircraft in the report.
+// The details are copied into each aircraft but their pointer fields are shared with the cache,
+// so later steps must replace them rather than modify what they point to.
+func (m *Manager) Enrich(rpt *model.Report) {
+	hexes := make([]string, len(rpt.Aircraft))
+	for i, ac := range rpt.Aircraft {
+		hexes[i] = strings.ToLower(ac.Hex)
+	}
+	for i, details := range m.LookupAll(hexes) {
+		if details != nil {
+			rpt.Aircraft[i].Details = *details
 		}
-	} else if !os.IsNotExist(err) {
-		level.Error(m.logger).Log("msg", "failed to stat registration file, cannot update", "err", err)
-		return false
 	}
+}
 
-	// File does not exist or it's more than 24 hours old.
-	level.Info(m.logger).Log("msg", "downloading new registration file")
-
-	// Get the data
-	resp, err := http.Get(m.config.URL)
-	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to download new registration file", "url", m.config.URL, "err", err)
-		return false
+func (m *Manager) applyOverrides(hex string, d *model.Details) *model.Details {
+	if m.overrides == nil {
+		return d
 	}
-	defer resp.Body.Close()
+	return m.overrides.apply(hex, d)
+}
 
-	// Create the file
-	out, err := os.Create(path.Join(m.config.Directory, tempRegfile))
-	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to create temp registration file", "err", err)
-		return false
+func getDetails(b *bolt.Bucket, hex string) (*model.Details, error) {
+	if b == nil {
+		return nil, nil
+	}
+	v := b.Get([]byte(hex))
+	if v == nil {
+		return nil, nil
 	}
+	return decodeDetails(v)
+}
 
-	defer os.Remove(out.Name())
+// LastImport returns the statistics of the most recent successful import of the registration file.
+func (m *Manager) LastImport() ImportStats {
+	m.statsMtx.Lock()
+	defer m.statsMtx.Unlock()
+	return m.stats
+}
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to copy file to temp file", "err", err)
-		out.Close()
-		return false
+// stopping closes the db once the run loop has finished with it.
+func (m *Manager) stopping(_ error) error {
+	if err := m.db.Close(); err != nil {
+		return fmt.Errorf("error closing boltdb file: %s", err)
 	}
+	level.Info(m.logger).Log("msg", "shutdown complete")
+	return nil
+}
 
-	out.Close()
+// empty is whether the registration file has never been loaded into the db.
+func (m *Manager) empty() bool {
+	empty := true
+	err := m.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(aircraftBucket); b != nil {
+			k, _ := b.Cursor().First()
+			empty = k == nil
+		}
+		return nil
+	})
+	return err != nil || empty
+}
 
-	err = os.Rename(path.Join(m.config.Directory, tempRegfile), path.Join(m.config.Directory, regfile))
+func (m *Manager) checkAndUpdateRegistrationFile(ctx context.Context) bool {
+	updated, err := m.downloader.Update(ctx)
 	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to rename temp registration file to file", "err", err)
+		if ctx.Err() != nil {
+			return false
+		}
+		level.Error(m.logger).Log("msg", "failed to update registration file", "url", m.config.URL, "err", err)
 		return false
 	}
-
-	level.Info(m.logger).Log("msg", "new registration file downloaded and replaced existing file")
-
-	return true
+	return updated
 }
 
 func (m *Manager) loadRegistrationInfo() {
@@ -199,161 +367,52 @@ func (m *Manager) loadRegistrationInfo() {
 	defer reader.Close()
 	defer file.Close()
 	jp := NewCsvParser(reader)
+	records := 0
 	err = m.db.Update(func(tx *bolt.Tx) error {
-		_ = tx.DeleteBucket([]byte("aircraft"))
-		b, err := tx.CreateBucket([]byte("aircraft"))
+		_ = tx.DeleteBucket(aircraftBucket)
+		b, err := tx.CreateBucket(aircraftBucket)
 		if err != nil {
 			return fmt.Errorf("create bucket: %s", err)
 		}
 		for jp.Next() {
 			h, d := jp.Details()
-			ac, err := json.Marshal(d)
-			if err != nil {
-				level.Warn(m.logger).Log("msg", "failed to marshal aircraft details into json for storage in boltdb", "err", err)
-				continue
-			}
-			err = b.Put([]byte(h), ac)
+			// bolt keeps a reference to the value until the transaction commits so each record needs its own slice.
+			err = b.Put([]byte(h), appendDetails(nil, d))
 			if err != nil {
 				return fmt.Errorf("adding key: %s", err)
 			}
+			records++
 		}
-		return nil
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		return meta.Put(encodingKey, []byte{currentEncoding})
 	})
 	if err != nil {
 		level.Error(m.logger).Log("msg", "errors updating boltdb database with new info", "err", err)
 		return
 	}
-	level.Info(m.logger).Log("msg", "finished updating aircraft registration details")
-
-}
-
-// CsvParser is built to parse the CSV file at github.com/wiedehopf/tar1090-db/raw/csv/aircraft.csv.gz
-// It's also built to do this while minimizing allocations and as such does some risky slice->string conversions
-// The custome CsvParser was built to work around the non-standard CSV format created by this python
-// spamwriter = csv.writer(csvfile,
-//                delimiter=';', escapechar='\\',
-//                quoting=csv.QUOTE_NONE, quotechar=None,
-//                lineterminator='\n')
-// Which is semicolon delimited, no header, non quoted and uses backslashes to escape
-// There currently aren't any lines in the file that use the escape char so I'm not 100% sure the code here handles that correctly.
-type CsvParser struct {
-	s             *bufio.Scanner
-	details       *model.Details
-	hex           string
-	hexSlice      []byte
-	regSlice      []byte
-	typeCodeSlice []byte
-	optCodeSlice  []byte
-	descSlice     []byte
-	manSlice      []byte
-	ownSlice      []byte
-}
-
-func NewCsvParser(r io.Reader) *CsvParser {
-	return &CsvParser{
-		s:             bufio.NewScanner(r),
-		details:       &model.Details{},
-		hexSlice:      make([]byte, 1000),
-		regSlice:      make([]byte, 1000),
-		typeCodeSlice: make([]byte, 1000),
-		optCodeSlice:  make([]byte, 1000),
-		descSlice:     make([]byte, 1000),
-		manSlice:      make([]byte, 1000),
-		ownSlice:      make([]byte, 1000),
+	if m.cache != nil {
+		m.cache.purge()
 	}
-}
-
-func (j *CsvParser) Next() bool {
-	if !j.s.Scan() {
-		return false
+	stats := ImportStats{
+		Time:    time.Now(),
+		Records: records,
+		Skipped: jp.Skipped(),
+		Errors:  jp.Errors(),
 	}
-	lastPos := 0
-	part := 0
-	lb := j.s.Bytes()
-	//Reset details to be empty
-	j.details.Owner = nil
-	j.details.PIA = nil
-	j.details.Military = nil
-	j.details.LADD = nil
-	j.details.Interesting = nil
-	j.details.Manufactured = nil
-	j.details.Description = nil
-	j.details.TypeCode = nil
-	j.details.Registration = nil
-	for p, r := range lb {
-		if r == ';' {
-			if p > 0 && lb[p-1] == '\\' {
-				continue
-			}
-			var start, end int
-			if p-lastPos > 1 {
-				if lastPos == 0 {
-					start = lastPos
-					end = p
-				} else {
-					start = lastPos + 1
-					end = p
-				}
-				switch part {
-				case 0:
-					j.hexSlice = j.hexSlice[:0]
-					j.hexSlice = append(j.hexSlice, lb[start:end]...)
-					j.hex = *(*string)(unsafe.Pointer(&j.hexSlice))
-				case 1:
-					j.regSlice = j.regSlice[:0]
-					j.regSlice = append(j.regSlice, lb[start:end]...)
-					j.details.Registration = (*string)(unsafe.Pointer(&j.regSlice))
-				case 2:
-					j.typeCodeSlice = j.typeCodeSlice[:0]
-					j.typeCodeSlice = append(j.typeCodeSlice, lb[start:end]...)
-					j.details.TypeCode = (*string)(unsafe.Pointer(&j.typeCodeSlice))
-				case 3:
-					j.optCodeSlice = j.optCodeSlice[:0]
-					j.optCodeSlice = append(j.optCodeSlice, lb[start:end]...)
-					if len(j.optCodeSlice) >= 1 {
-						if j.optCodeSlice[0] == '1' {
-							j.details.Military = &trueVar
-						}
-					}
-					if len(j.optCodeSlice) >= 2 {
-						if j.optCodeSlice[1] == '1' {
-							j.details.Interesting = &trueVar
-						}
-					}
-					if len(j.optCodeSlice) >= 3 {
-						if j.optCodeSlice[2] == '1' {
-							j.details.PIA = &trueVar
-						}
-					}
-					if len(j.optCodeSlice) >= 4 {
-						if j.optCodeSlice[3] == '1' {
-							j.details.LADD = &trueVar
-						}
-					}
-				case 4:
-					j.descSlice = j.descSlice[:0]
-					j.descSlice = append(j.descSlice, lb[start:end]...)
-					j.details.Description = (*string)(unsafe.Pointer(&j.descSlice))
-				case 5:
-					j.manSlice = j.manSlice[:0]
-					j.manSlice = append(j.manSlice, lb[start:end]...)
-					j.details.Manufactured = (*string)(unsafe.Pointer(&j.manSlice))
-				case 6:
-					j.ownSlice = j.ownSlice[:0]
-					j.ownSlice = append(j.ownSlice, lb[start:end]...)
-					j.details.Owner = (*string)(unsafe.Pointer(&j.ownSlice))
-				}
-			}
-			part++
-			lastPos = p
-
+	m.statsMtx.Lock()
+	m.stats = stats
+	m.statsMtx.Unlock()
+	importedRecords.Set(float64(stats.Records))
+	importSkippedRecords.Set(float64(stats.Skipped))
+	for i, pe := range stats.Errors {
+		if i >= maxLoggedParseErrors {
+			break
 		}
+		level.Warn(m.logger).Log("msg", "skipped malformed line in registration file", "line", pe.Line, "reason", pe.Reason)
 	}
-	return true
-}
+	level.Info(m.logger).Log("msg", "finished updating aircraft registration details", "records", stats.Records, "skipped", stats.Skipped)
 
-// Details returns a pointer to the current details
-// NOTE everything about the returned object is UNSAFE it is intended that this object be serialized to a string immediately before calling Next()
-func (j *CsvParser) Details() (string, *model.Details) {
-	return strings.TrimSpace(strings.ToLower(j.hex)), j.details
 }
@@ -2,14 +2,22 @@ package aircraft
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/services"
 	"github.com/go-kit/kit/log"
+	"github.com/slim-bean/adsb-loki/pkg/download"
 	"github.com/slim-bean/adsb-loki/pkg/model"
 )
 
@@ -55,7 +63,7 @@ var expectedDetails = map[string]*model.Details{
 	"38be7b": {
 		Registration: stringP("F-PGMG"),
 		TypeCode:     stringP("D11"),
-		Description:  stringP("Jod\\;el D.119-D"),
+		Description:  stringP("Jod;el D.119-D"),
 	},
 	"3ebbb4": {
 		Registration: stringP("3X+XX"),
@@ -113,6 +121,79 @@ func Test_JsonParser(t *testing.T) {
 	}
 }
 
+func Test_CsvParserEscapes(t *testing.T) {
+	input := "400001;G-ABCD;C152;0000;Back\\\\slash and semi\\;colon;;Multi\\\nline owner;\n" +
+		"400002;G-EFGH;C152;0000;Ends in backslash\\\\;;;\n"
+	p := NewCsvParser(strings.NewReader(input))
+	expected := []struct {
+		hex, desc string
+		owner     *string
+		line      int
+	}{
+		{"400001", "Back\\slash and semi;colon", stringP("Multi\nline owner"), 1},
+		{"400002", "Ends in backslash\\", nil, 3},
+	}
+	for _, e := range expected {
+		if !p.Next() {
+			t.Fatalf("expected record for %s", e.hex)
+		}
+		h, d := p.Details()
+		if h != e.hex || *d.Description != e.desc || p.Line() != e.line {
+			t.Fatalf("expected %s %q on line %d, got %s %q on line %d", e.hex, e.desc, e.line, h, *d.Description, p.Line())
+		}
+		if (e.owner == nil) != (d.Owner == nil) || e.owner != nil && *e.owner != *d.Owner {
+			t.Fatalf("%s: unexpected owner %v", e.hex, d.Owner)
+		}
+	}
+	if p.Next() {
+		t.Fatal("expected end of input")
+	}
+}
+
+func Test_CsvParserMalformed(t *testing.T) {
+	input := "400001;G-ABCD;C152;0000;Cessna 152;;;\n" +
+		"400002;G-EFGH;C152\n" +
+		"\n" +
+		"XYZ123;G-IJKL;C152;0000;Bad hex;;;\n" +
+		"400003;G-MNOP;C152;0000;" + strings.Repeat("x", 200) + ";;;\n" +
+		"400004;G-QRST;C152;0000;Cessna 152;1980;Owner;future;columns;;\n"
+	p := NewCsvParserWithLimit(strings.NewReader(input), 100)
+	var hexes []string
+	var extra []string
+	for p.Next() {
+		h, _ := p.Details()
+		// The hex is only valid until the next call to Next.
+		hexes = append(hexes, string([]byte(h)))
+		if h == "400004" {
+			extra = p.Extra()
+		}
+	}
+	if strings.Join(hexes, ",") != "400001,400004" {
+		t.Fatalf("unexpected records %v", hexes)
+	}
+	if strings.Join(extra, ",") != "future,columns" {
+		t.Fatalf("unexpected extra fields %v", extra)
+	}
+	if p.Skipped() != 3 {
+		t.Fatalf("expected 3 skipped lines, got %d", p.Skipped())
+	}
+	lines := []int{}
+	for _, e := range p.Errors() {
+		lines = append(lines, e.Line)
+	}
+	if fmt.Sprint(lines) != "[2 4 5]" {
+		t.Fatalf("unexpected error lines %v: %v", lines, p.Errors())
+	}
+}
+
+func Test_ImportStats(t *testing.T) {
+	m := newTestManager(t, testFile+"BADHEX;;;;;;;\n", 0)
+	stats := m.LastImport()
+	if stats.Records != len(expectedDetails) || stats.Skipped != 1 || len(stats.Errors) != 1 || stats.Errors[0].Line != 11 {
+		t.Fatalf("unexpected import stats %+v", stats)
+	}
+}
+
 func Benchmark_loadRegistrationInfo(b *testing.B) {
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	path, err := os.Getwd()
@@ -133,3 +214,64 @@ func Benchmark_loadRegistrationInfo(b *testing.B) {
 		m.loadRegistrationInfo()
 	}
 }
+
+func Test_OpenReadOnly(t *testing.T) {
+	m := newTestManager(t, testFile, 0)
+	start := time.Now()
+	if _, err := OpenReadOnly(log.NewNopLogger(), m.config); err == nil || !strings.Contains(err.Error(), "running adsb-loki") {
+		t.Fatalf("expected a timeout while the db is open, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected to give up after a second, took %s", elapsed)
+	}
+
+	m.db.Close()
+	ro, err := OpenReadOnly(log.NewNopLogger(), m.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	if d := ro.Lookup("a08ae3"); d == nil || *d.Registration != "N134JP" {
+		t.Errorf("expected details from the db, got %+v", d)
+	}
+}
+
+func Test_StartBeforeDownload(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(testFile))
+		gz.Close()
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	m, err := NewAircraftManager(log.NewNopLogger(), Config{
+		Directory:  dir,
+		BoltDbFile: filepath.Join(dir, "aircraft.db"),
+		URL:        srv.URL + "/aircraft.csv.gz",
+		Download: download.Config{
+			Timeout: 5 * time.Second,
+			Backoff: util.BackoffConfig{MinBackoff: 10 * time.Millisecond, MaxBackoff: 10 * time.Millisecond, MaxRetries: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := services.StartAndAwaitRunning(ctx, m); err != nil {
+		t.Fatalf("expected the manager to run without waiting for the download: %s", err)
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for m.LastImport().Records != len(expectedDetails) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the download to be loaded, got %+v", m.LastImport())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := services.StopAndAwaitTerminated(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package aircraft
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// lruCache is a bounded least recently used cache of decoded aircraft details.
+// A nil *model.Details is a valid value and records that the hex is not in the database.
+// The cached details are shared by every lookup so must never be modified.
+type lruCache struct {
+	mtx     sync.Mutex
+	size    int
+	ll      *list.List
+	entries map[string]*list.Element
+	// gen changes on every purge so details read from the db before the purge aren't added after it.
+	gen uint64
+}
+
+type cacheEntry struct {
+	hex     string
+	details *model.Details
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		ll:      list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns the cached details for hex and whether the hex was found in the cache at all.
+func (c *lruCache) get(hex string) (*model.Details, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	e, ok := c.entries[hex]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).details, true
+}
+
+// generation must be called before reading the details which are passed to add.
+func (c *lruCache) generation() uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.gen
+}
+
+// add caches the details unless the cache has been purged since gen, in which case they may be stale.
+func (c *lruCache) add(hex string, d *model.Details, gen uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if e, ok := c.entries[hex]; ok {
+		c.ll.MoveToFront(e)
+		e.Value.(*cacheEntry).details = d
+		return
+	}
+	c.entries[hex] = c.ll.PushFront(&cacheEntry{hex: hex, details: d})
+	if c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).hex)
+	}
+}
+
+func (c *lruCache) purge() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+	c.gen++
+}
+
+func (c *lruCache) len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,134 @@
+package aircraft
+
+import (
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+// newTestManager creates a Manager backed by a temporary boltdb loaded with content.
+func newTestManager(tb testing.TB, content string, cacheSize int) *Manager {
+	dir := tb.TempDir()
+	f, err := os.Create(filepath.Join(dir, regfile))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	w := gzip.NewWriter(f)
+	if _, err := w.Write([]byte(content)); err != nil {
+		tb.Fatal(err)
+	}
+	w.Close()
+	f.Close()
+
+	m, err := NewAircraftManager(log.NewNopLogger(), Config{
+		Directory:  dir,
+		BoltDbFile: filepath.Join(dir, "aircraft.db"),
+		CacheSize:  cacheSize,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	m.loadRegistrationInfo()
+	tb.Cleanup(func() { m.db.Close() })
+	return m
+}
+
+// syntheticFile returns a registration file with n aircraft and the hexes of every other one of them,
+// the remaining hexes are not in the file.
+func syntheticFile(n int) (string, []string) {
+	sb := strings.Builder{}
+	hexes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		hex := fmt.Sprintf("%06x", i*2)
+		fmt.Fprintf(&sb, "%s;N%dAB;C172;0000;Cessna 172;1978;SOME FLYING CLUB;\n", hex, i)
+		hexes = append(hexes, hex, fmt.Sprintf("%06x", i*2+1))
+	}
+	return sb.String(), hexes
+}
+
+func Test_LRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", expectedDetails["38bb7b"], 0)
+	c.add("b", nil, 0)
+	if d, ok := c.get("b"); !ok || d != nil {
+		t.Fatal("expected negative entry to be cached")
+	}
+	// a is now the least recently used
+	c.add("c", expectedDetails["a08ae3"], 0)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected a to be evicted")
+	}
+	if c.len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", c.len())
+	}
+	c.purge()
+	if _, ok := c.get("c"); ok || c.len() != 0 {
+		t.Fatal("expected cache to be empty after purge")
+	}
+
+	// Details read before the purge may be stale so they aren't cached after it.
+	c.add("a", expectedDetails["38bb7b"], 0)
+	if _, ok := c.get("a"); ok {
+		t.Fatal("expected details from before the purge not to be cached")
+	}
+	c.add("a", expectedDetails["38bb7b"], c.generation())
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("expected details from after the purge to be cached")
+	}
+}
+
+func Test_LookupAll(t *testing.T) {
+	for _, size := range []int{0, 3, 100} {
+		t.Run(fmt.Sprintf("cache_%d", size), func(t *testing.T) {
+			m := newTestManager(t, testFile, size)
+			hexes := []string{"38bb7b", "ffffff", "a0002b", "38bb7b", "ae595d", "000000"}
+			// Run twice so the second pass is served from the cache.
+			for pass := 0; pass < 2; pass++ {
+				all := m.LookupAll(hexes)
+				for i, hex := range hexes {
+					single := m.Lookup(hex)
+					if (single == nil) != (all[i] == nil) {
+						t.Fatalf("pass %d: Lookup and LookupAll disagree for %s", pass, hex)
+					}
+					if single != nil && *single.Registration != *expectedDetails[hex].Registration {
+						t.Fatalf("pass %d: wrong registration for %s: %s", pass, hex, *single.Registration)
+					}
+				}
+			}
+			if m.cache != nil {
+				m.loadRegistrationInfo()
+				if m.cache.len() != 0 {
+					t.Fatal("expected cache to be invalidated on reload")
+				}
+			}
+		})
+	}
+}
+
+func benchmarkLookup(b *testing.B, cacheSize int, batch bool) {
+	content, hexes := syntheticFile(20000)
+	m := newTestManager(b, content, cacheSize)
+	// A report typically has a few hundred aircraft, half of which are unknown here.
+	report := hexes[:200]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if batch {
+			m.LookupAll(report)
+			continue
+		}
+		for _, hex := range report {
+			m.Lookup(hex)
+		}
+	}
+}
+
+func Benchmark_Lookup_NoCache(b *testing.B)    { benchmarkLookup(b, 0, false) }
+func Benchmark_Lookup_Cache(b *testing.B)      { benchmarkLookup(b, 10000, false) }
+func Benchmark_LookupAll_NoCache(b *testing.B) { benchmarkLookup(b, 0, true) }
+func Benchmark_LookupAll_Cache(b *testing.B)   { benchmarkLookup(b, 10000, true) }
@@ -0,0 +1,282 @@
+package aircraft
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+const (
+	// DefaultMaxLineLength is the longest record NewCsvParser will accept, longer records are skipped.
+	DefaultMaxLineLength = 4096
+
+	// maxParseErrors limits how many ParseErrors are kept, every skipped line is still counted.
+	maxParseErrors = 100
+)
+
+// The known fields of a record, in order.
+const (
+	fieldHex = iota
+	fieldRegistration
+	fieldTypeCode
+	fieldFlags
+	fieldDescription
+	fieldManufactured
+	fieldOwner
+	numFields
+)
+
+// ParseError describes a record which was skipped.
+type ParseError struct {
+	Line   int
+	Reason string
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// CsvParser is built to parse the CSV file at github.com/wiedehopf/tar1090-db/raw/csv/aircraft.csv.gz
+// It's also built to do this while minimizing allocations and as such does some risky slice->string conversions
+// The custome CsvParser was built to work around the non-standard CSV format created by this python
+// spamwriter = csv.writer(csvfile,
+//                delimiter=';', escapechar='\\',
+//                quoting=csv.QUOTE_NONE, quotechar=None,
+//                lineterminator='\n')
+// Which is semicolon delimited, no header, non quoted and uses backslashes to escape.
+// With these settings python escapes the delimiter, the escape char itself and the line terminator,
+// so `\;` is a literal semicolon, `\\` a literal backslash and a backslash at the end of a line continues the record on the next line.
+// Records with fewer than the 7 known fields or an invalid hex are skipped and reported,
+// any fields after the known ones are made available through Extra.
+type CsvParser struct {
+	r       *bufio.Reader
+	details *model.Details
+	hex     string
+	record  []byte
+	fields  [][]byte
+	extra   [][]byte
+
+	maxLineLength int
+	line          int
+	recordLine    int
+	skipped       int
+	errors        []ParseError
+}
+
+func NewCsvParser(r io.Reader) *CsvParser {
+	return NewCsvParserWithLimit(r, DefaultMaxLineLength)
+}
+
+// NewCsvParserWithLimit creates a CsvParser which skips records longer than maxLineLength bytes.
+func NewCsvParserWithLimit(r io.Reader, maxLineLength int) *CsvParser {
+	fields := make([][]byte, numFields)
+	for i := range fields {
+		fields[i] = make([]byte, 0, 128)
+	}
+	return &CsvParser{
+		r:             bufio.NewReaderSize(r, maxLineLength+1),
+		details:       &model.Details{},
+		record:        make([]byte, 0, maxLineLength),
+		fields:        fields,
+		maxLineLength: maxLineLength,
+	}
+}
+
+// Next advances to the next valid record, skipping and recording any malformed ones.
+// It returns false at the end of the input.
+func (j *CsvParser) Next() bool {
+	for {
+		ok, err := j.readRecord()
+		if !ok {
+			return false
+		}
+		if err == nil {
+			if len(bytes.TrimSpace(j.record)) == 0 {
+				continue
+			}
+			err = j.parse()
+		}
+		if err != nil {
+			j.skip(err.Error())
+			continue
+		}
+		return true
+	}
+}
+
+// Line returns the line number the current record started on.
+func (j *CsvParser) Line() int {
+	return j.recordLine
+}
+
+// Skipped returns how many records have been skipped so far.
+func (j *CsvParser) Skipped() int {
+	return j.skipped
+}
+
+// Errors returns why records were skipped, only the first 100 are kept.
+func (j *CsvParser) Errors() []ParseError {
+	return j.errors
+}
+
+// Extra returns any fields after the known ones for the current record, trailing empty fields are omitted.
+func (j *CsvParser) Extra() []string {
+	if len(j.extra) == 0 {
+		return nil
+	}
+	extra := make([]string, len(j.extra))
+	for i := range j.extra {
+		extra[i] = string(j.extra[i])
+	}
+	return extra
+}
+
+// Details returns a pointer to the current details
+// NOTE everything about the returned object is UNSAFE it is intended that this object be serialized to a string immediately before calling Next()
+func (j *CsvParser) Details() (string, *model.Details) {
+	return strings.TrimSpace(strings.ToLower(j.hex)), j.details
+}
+
+func (j *CsvParser) skip(reason string) {
+	j.skipped++
+	if len(j.errors) < maxParseErrors {
+		j.errors = append(j.errors, ParseError{Line: j.recordLine, Reason: reason})
+	}
+}
+
+// readRecord reads the next record into j.record, joining lines which end in an escaped line terminator.
+// It returns false when there is no more input, an error means the record was read but is unusable.
+func (j *CsvParser) readRecord() (bool, error) {
+	j.record = j.record[:0]
+	j.recordLine = j.line + 1
+	tooLong := false
+	for {
+		l, err := j.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// Keep consuming the over long line so the next record starts at the right place.
+			tooLong = true
+			continue
+		}
+		if len(l) == 0 {
+			if len(j.record) > 0 || tooLong {
+				// The input ended on an escaped line terminator, use what we have.
+				break
+			}
+			return false, nil
+		}
+		j.line++
+		if !tooLong && len(j.record)+len(l) <= j.maxLineLength+1 {
+			j.record = append(j.record, l...)
+		} else {
+			tooLong = true
+		}
+		if err != nil || l[len(l)-1] != '\n' || !escaped(l, len(l)-1) {
+			break
+		}
+	}
+	if tooLong {
+		return true, fmt.Errorf("record is longer than %d bytes", j.maxLineLength)
+	}
+	j.record = bytes.TrimSuffix(j.record, []byte{'\n'})
+	return true, nil
+}
+
+// escaped reports whether the byte at pos is preceded by an odd number of escape chars.
+func escaped(b []byte, pos int) bool {
+	n := 0
+	for i := pos - 1; i >= 0 && b[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// parse splits j.record into unescaped fields and populates the details.
+func (j *CsvParser) parse() error {
+	for i := range j.fields {
+		j.fields[i] = j.fields[i][:0]
+	}
+	j.extra = j.extra[:0]
+	field := 0
+	cur := &j.fields[0]
+	for p := 0; p < len(j.record); p++ {
+		c := j.record[p]
+		switch {
+		case c == '\\' && p+1 < len(j.record):
+			p++
+			*cur = append(*cur, j.record[p])
+		case c == ';':
+			field++
+			if field < numFields {
+				cur = &j.fields[field]
+				continue
+			}
+			if n := field - numFields; n < cap(j.extra) {
+				j.extra = j.extra[:n+1]
+				j.extra[n] = j.extra[n][:0]
+			} else {
+				j.extra = append(j.extra, nil)
+			}
+			cur = &j.extra[len(j.extra)-1]
+		default:
+			*cur = append(*cur, c)
+		}
+	}
+	if field+1 < numFields {
+		return fmt.Errorf("expected at least %d fields, found %d", numFields, field+1)
+	}
+	for len(j.extra) > 0 && len(j.extra[len(j.extra)-1]) == 0 {
+		j.extra = j.extra[:len(j.extra)-1]
+	}
+
+	hex := bytes.TrimSpace(j.fields[fieldHex])
+	if !validHex(hex) {
+		return fmt.Errorf("invalid hex %q", hex)
+	}
+	j.hex = *(*string)(unsafe.Pointer(&hex))
+
+	//Reset details to be empty
+	*j.details = model.Details{}
+	j.details.Registration = j.stringField(fieldRegistration)
+	j.details.TypeCode = j.stringField(fieldTypeCode)
+	j.details.Description = j.stringField(fieldDescription)
+	j.details.Manufactured = j.stringField(fieldManufactured)
+	j.details.Owner = j.stringField(fieldOwner)
+	flags := j.fields[fieldFlags]
+	if len(flags) >= 1 && flags[0] == '1' {
+		j.details.Military = &trueVar
+	}
+	if len(flags) >= 2 && flags[1] == '1' {
+		j.details.Interesting = &trueVar
+	}
+	if len(flags) >= 3 && flags[2] == '1' {
+		j.details.PIA = &trueVar
+	}
+	if len(flags) >= 4 && flags[3] == '1' {
+		j.details.LADD = &trueVar
+	}
+	return nil
+}
+
+func (j *CsvParser) stringField(i int) *string {
+	if len(j.fields[i]) == 0 {
+		return nil
+	}
+	return (*string)(unsafe.Pointer(&j.fields[i]))
+}
+
+func validHex(h []byte) bool {
+	if len(h) != 6 {
+		return false
+	}
+	for _, c := range h {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
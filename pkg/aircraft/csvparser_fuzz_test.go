@@ -0,0 +1,66 @@
+//go:build go1.18
+// +build go1.18
+
+package aircraft
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var csvEscaper = strings.NewReplacer(`\`, `\\`, `;`, `\;`, "\n", "\\\n")
+
+func FuzzCsvParser(f *testing.F) {
+	for _, l := range strings.SplitAfter(testFile, "\n") {
+		f.Add([]byte(l))
+	}
+	f.Add([]byte("400001;G-ABCD;C152;0000;a\\\nb;;;\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p := NewCsvParserWithLimit(bytes.NewReader(data), 256)
+		records := 0
+		for p.Next() {
+			h, _ := p.Details()
+			if !validHex([]byte(h)) {
+				t.Fatalf("returned invalid hex %q", h)
+			}
+			records++
+		}
+		if lines := bytes.Count(data, []byte{'\n'}) + 1; records+p.Skipped() > lines {
+			t.Fatalf("%d records and %d skipped from %d lines", records, p.Skipped(), lines)
+		}
+	})
+}
+
+func FuzzCsvParserRoundTrip(f *testing.F) {
+	f.Add("F-PGMG", "Jod;el D.119-D", "OWNER\\")
+	f.Add("N1BR", "Cessna 240", "VAN BORTEL\nAIRCRAFT INC")
+	f.Fuzz(func(t *testing.T, reg, desc, owner string) {
+		line := "a0002b;" + csvEscaper.Replace(reg) + ";C240;0001;" + csvEscaper.Replace(desc) + ";2015;" + csvEscaper.Replace(owner) + ";\n"
+		p := NewCsvParserWithLimit(strings.NewReader(line), len(line)+1)
+		if !p.Next() {
+			t.Fatalf("failed to parse %q: %v", line, p.Errors())
+		}
+		_, d := p.Details()
+		for _, c := range []struct {
+			name     string
+			expected string
+			got      *string
+		}{
+			{"registration", reg, d.Registration},
+			{"description", desc, d.Description},
+			{"owner", owner, d.Owner},
+		} {
+			got := ""
+			if c.got != nil {
+				got = *c.got
+			}
+			if got != c.expected {
+				t.Fatalf("%s: expected %q got %q from %q", c.name, c.expected, got, line)
+			}
+		}
+		if d.LADD == nil || !*d.LADD {
+			t.Fatal("expected LADD flag to be set")
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package aircraft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Records in the aircraft bucket are stored in a compact binary format:
+//
+//   byte 0      encoding version
+//   byte 1      flags: military, interesting, pia, ladd (bit 0 to 3)
+//   byte 2      bitmask of which string fields follow: registration, type code, description, manufactured, owner (bit 0 to 4)
+//   ...         each present string as a uvarint length followed by the bytes
+//
+// Databases written by older versions stored JSON, those records always start with '{' and are still readable.
+const (
+	encodingV1      = byte(1)
+	currentEncoding = encodingV1
+
+	flagMilitary    = 1 << 0
+	flagInteresting = 1 << 1
+	flagPIA         = 1 << 2
+	flagLADD        = 1 << 3
+
+	numStringFields = 5
+)
+
+var (
+	metaBucket  = []byte("meta")
+	encodingKey = []byte("encoding")
+
+	errShortRecord = errors.New("aircraft record is truncated")
+)
+
+// detailsAlloc lets decodeDetails allocate the details and all of its strings at once.
+type detailsAlloc struct {
+	details model.Details
+	strs    [numStringFields]string
+}
+
+func stringFields(d *model.Details) [numStringFields]**string {
+	return [numStringFields]**string{&d.Registration, &d.TypeCode, &d.Description, &d.Manufactured, &d.Owner}
+}
+
+// appendDetails appends the binary encoding of d to buf.
+func appendDetails(buf []byte, d *model.Details) []byte {
+	var flags, mask byte
+	if d.Military != nil && *d.Military {
+		flags |= flagMilitary
+	}
+	if d.Interesting != nil && *d.Interesting {
+		flags |= flagInteresting
+	}
+	if d.PIA != nil && *d.PIA {
+		flags |= flagPIA
+	}
+	if d.LADD != nil && *d.LADD {
+		flags |= flagLADD
+	}
+	fields := stringFields(d)
+	for i, f := range fields {
+		if *f != nil {
+			mask |= 1 << uint(i)
+		}
+	}
+	buf = append(buf, currentEncoding, flags, mask)
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, f := range fields {
+		if *f == nil {
+			continue
+		}
+		n := binary.PutUvarint(lenBuf[:], uint64(len(**f)))
+		buf = append(buf, lenBuf[:n]...)
+		buf = append(buf, **f...)
+	}
+	return buf
+}
+
+// decodeDetails decodes a record written by appendDetails, or a legacy JSON record.
+// The bool fields of the returned details point at shared values and must not be modified.
+func decodeDetails(v []byte) (*model.Details, error) {
+	if len(v) == 0 {
+		return nil, errShortRecord
+	}
+	if v[0] == '{' {
+		d := &model.Details{}
+		if err := json.Unmarshal(v, d); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	if v[0] != encodingV1 {
+		return nil, fmt.Errorf("unknown aircraft record encoding version %d", v[0])
+	}
+	if len(v) < 3 {
+		return nil, errShortRecord
+	}
+	flags, mask := v[1], v[2]
+	a := &detailsAlloc{}
+	d := &a.details
+	if flags&flagMilitary != 0 {
+		d.Military = &trueVar
+	}
+	if flags&flagInteresting != 0 {
+		d.Interesting = &trueVar
+	}
+	if flags&flagPIA != 0 {
+		d.PIA = &trueVar
+	}
+	if flags&flagLADD != 0 {
+		d.LADD = &trueVar
+	}
+	if mask == 0 {
+		return d, nil
+	}
+	// Convert the remainder to a string once and slice the fields out of it.
+	rest := string(v[3:])
+	pos := 0
+	for i, f := range stringFields(d) {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		l, n := binary.Uvarint(v[3+pos:])
+		if n <= 0 || uint64(len(rest)-pos-n) < l {
+			return nil, errShortRecord
+		}
+		pos += n
+		a.strs[i] = rest[pos : pos+int(l)]
+		*f = &a.strs[i]
+		pos += int(l)
+	}
+	return d, nil
+}
+
+// migrateEncoding rewrites every record in the aircraft bucket using the current encoding,
+// it is a no-op if the database is already using the current encoding.
+func migrateEncoding(db *bolt.DB) (int, error) {
+	migrated := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		if v := meta.Get(encodingKey); len(v) == 1 && v[0] == currentEncoding {
+			return nil
+		}
+		if b := tx.Bucket(aircraftBucket); b != nil {
+			// Modifying a bucket while iterating it invalidates the cursor so collect the new values first.
+			var keys, values [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				if len(v) > 0 && v[0] == currentEncoding {
+					return nil
+				}
+				d, err := decodeDetails(v)
+				if err != nil {
+					return fmt.Errorf("decoding %s: %s", k, err)
+				}
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, appendDetails(nil, d))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for i := range keys {
+				if err := b.Put(keys[i], values[i]); err != nil {
+					return err
+				}
+			}
+			migrated = len(keys)
+		}
+		return meta.Put(encodingKey, []byte{currentEncoding})
+	})
+	return migrated, err
+}
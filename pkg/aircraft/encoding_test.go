@@ -0,0 +1,125 @@
+package aircraft
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_EncodingRoundTrip(t *testing.T) {
+	for hex, d := range expectedDetails {
+		enc := appendDetails(nil, d)
+		got, err := decodeDetails(enc)
+		if err != nil {
+			t.Fatalf("%s: %v", hex, err)
+		}
+		if !reflect.DeepEqual(d, got) {
+			t.Fatalf("%s: expected %+v got %+v", hex, d, got)
+		}
+		// Truncated records must be rejected rather than decoded into garbage.
+		for i := 1; i < len(enc); i++ {
+			if _, err := decodeDetails(enc[:i]); err == nil && enc[2] != 0 {
+				t.Fatalf("%s: expected error decoding record truncated to %d bytes", hex, i)
+			}
+		}
+	}
+}
+
+func Test_DecodeLegacyJSON(t *testing.T) {
+	d := expectedDetails["a0002b"]
+	bts, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeDetails(bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d, got) {
+		t.Fatalf("expected %+v got %+v", d, got)
+	}
+}
+
+func Test_MigrateEncoding(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aircraft.db")
+	db, err := bolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write a database the way older versions did, JSON records and no meta bucket.
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(aircraftBucket)
+		if err != nil {
+			return err
+		}
+		for hex, d := range expectedDetails {
+			bts, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(hex), bts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	m, err := NewAircraftManager(log.NewNopLogger(), Config{Directory: t.TempDir(), BoltDbFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.db.Close()
+	err = m.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(aircraftBucket).ForEach(func(k, v []byte) error {
+			if v[0] != currentEncoding {
+				t.Errorf("%s was not migrated", k)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for hex, d := range expectedDetails {
+		if got := m.Lookup(hex); !reflect.DeepEqual(d, got) {
+			t.Fatalf("%s: expected %+v got %+v", hex, d, got)
+		}
+	}
+	if n, err := migrateEncoding(m.db); err != nil || n != 0 {
+		t.Fatalf("expected second migration to be a no-op, migrated=%d err=%v", n, err)
+	}
+}
+
+func benchmarkDecode(b *testing.B, v []byte) {
+	b.ReportAllocs()
+	var d *model.Details
+	var err error
+	for i := 0; i < b.N; i++ {
+		d, err = decodeDetails(v)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	_ = d
+}
+
+func Benchmark_DecodeDetails_JSON(b *testing.B) {
+	v, err := json.Marshal(expectedDetails["a08ae3"])
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkDecode(b, v)
+}
+
+func Benchmark_DecodeDetails_Binary(b *testing.B) {
+	benchmarkDecode(b, appendDetails(nil, expectedDetails["a08ae3"]))
+}
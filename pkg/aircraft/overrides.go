@@ -0,0 +1,168 @@
+package aircraft
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/yaml.v2"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Override holds locally maintained details for one aircraft, any field which is set replaces the
+// value from the registration database and tags are added to it.
+//
+//  aircraft:
+//    a1b2c3:
+//      registration: N123PD
+//      owner: County Sheriff
+//      tags: [police, local]
+type Override struct {
+	Registration *string  `yaml:"registration,omitempty"`
+	TypeCode     *string  `yaml:"type_code,omitempty"`
+	Military     *bool    `yaml:"military,omitempty"`
+	Interesting  *bool    `yaml:"interesting,omitempty"`
+	PIA          *bool    `yaml:"pia,omitempty"`
+	LADD         *bool    `yaml:"ladd,omitempty"`
+	Description  *string  `yaml:"description,omitempty"`
+	Manufactured *string  `yaml:"manufactured,omitempty"`
+	Owner        *string  `yaml:"owner,omitempty"`
+	Tags         []string `yaml:"tags,omitempty"`
+}
+
+type overridesFile struct {
+	Aircraft map[string]Override `yaml:"aircraft"`
+}
+
+// overrides is a hot reloadable set of Override keyed on lowercase hex.
+type overrides struct {
+	logger  log.Logger
+	file    string
+	mtx     sync.RWMutex
+	entries map[string]Override
+	modTime time.Time
+	// failedModTime is the modification time of a file which failed to load, it isn't tried again until it changes.
+	failedModTime time.Time
+}
+
+func newOverrides(logger log.Logger, file string) *overrides {
+	return &overrides{
+		logger:  log.With(logger, "overrides_file", file),
+		file:    file,
+		entries: map[string]Override{},
+	}
+}
+
+// reloadIfChanged loads the overrides file if its modification time has changed since the last load.
+// A file which fails to parse leaves the previous overrides in place and is only logged once for each change.
+func (o *overrides) reloadIfChanged() bool {
+	fi, err := os.Stat(o.file)
+	if err != nil {
+		if os.IsNotExist(err) && !o.modTime.IsZero() {
+			level.Warn(o.logger).Log("msg", "overrides file was removed, clearing overrides")
+			o.mtx.Lock()
+			o.entries = map[string]Override{}
+			o.modTime = time.Time{}
+			o.failedModTime = time.Time{}
+			o.mtx.Unlock()
+			return true
+		}
+		if !os.IsNotExist(err) {
+			level.Error(o.logger).Log("msg", "failed to stat overrides file", "err", err)
+		}
+		return false
+	}
+	o.mtx.RLock()
+	unchanged := fi.ModTime().Equal(o.modTime) || fi.ModTime().Equal(o.failedModTime)
+	o.mtx.RUnlock()
+	if unchanged {
+		return false
+	}
+	entries, err := loadOverrides(o.file)
+	if err != nil {
+		level.Error(o.logger).Log("msg", "failed to load overrides file, keeping previous overrides", "err", err)
+		o.mtx.Lock()
+		o.failedModTime = fi.ModTime()
+		o.mtx.Unlock()
+		return false
+	}
+	o.mtx.Lock()
+	o.entries = entries
+	o.modTime = fi.ModTime()
+	o.failedModTime = time.Time{}
+	o.mtx.Unlock()
+	level.Info(o.logger).Log("msg", "loaded aircraft overrides", "aircraft", len(entries))
+	return true
+}
+
+func loadOverrides(file string) (map[string]Override, error) {
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	f := overridesFile{}
+	if err := yaml.UnmarshalStrict(bts, &f); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]Override, len(f.Aircraft))
+	for hex, o := range f.Aircraft {
+		h := strings.ToLower(strings.TrimSpace(hex))
+		if !validHex([]byte(h)) {
+			return nil, fmt.Errorf("invalid hex %q", hex)
+		}
+		entries[h] = o
+	}
+	return entries, nil
+}
+
+// apply returns d merged with the override for hex, d is returned unchanged if there is no override.
+// d is never modified as it may be shared with the cache.
+func (o *overrides) apply(hex string, d *model.Details) *model.Details {
+	o.mtx.RLock()
+	ov, ok := o.entries[hex]
+	o.mtx.RUnlock()
+	if !ok {
+		return d
+	}
+	merged := &model.Details{}
+	if d != nil {
+		*merged = *d
+	}
+	if ov.Registration != nil {
+		merged.Registration = ov.Registration
+	}
+	if ov.TypeCode != nil {
+		merged.TypeCode = ov.TypeCode
+	}
+	if ov.Military != nil {
+		merged.Military = ov.Military
+	}
+	if ov.Interesting != nil {
+		merged.Interesting = ov.Interesting
+	}
+	if ov.PIA != nil {
+		merged.PIA = ov.PIA
+	}
+	if ov.LADD != nil {
+		merged.LADD = ov.LADD
+	}
+	if ov.Description != nil {
+		merged.Description = ov.Description
+	}
+	if ov.Manufactured != nil {
+		merged.Manufactured = ov.Manufactured
+	}
+	if ov.Owner != nil {
+		merged.Owner = ov.Owner
+	}
+	if len(ov.Tags) > 0 {
+		merged.Tags = append(append([]string(nil), merged.Tags...), ov.Tags...)
+	}
+	return merged
+}
@@ -0,0 +1,82 @@
+package aircraft
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func writeOverrides(t *testing.T, file, content string, mtime time.Time) {
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_Overrides(t *testing.T) {
+	m := newTestManager(t, testFile, 100)
+	file := filepath.Join(t.TempDir(), "overrides.yaml")
+	writeOverrides(t, file, `
+aircraft:
+  A0002B:
+    owner: Local Flight School
+    type_code: C172
+    tags: [local, school]
+  abcdef:
+    registration: N123PD
+    owner: County Sheriff
+    tags: [police]
+`, time.Now().Add(-time.Minute))
+	var logs bytes.Buffer
+	m.overrides = newOverrides(log.NewLogfmtLogger(&logs), file)
+	if !m.overrides.reloadIfChanged() {
+		t.Fatal("expected overrides to load")
+	}
+
+	all := m.LookupAll([]string{"a0002b", "abcdef", "38bb7b"})
+	d := all[0]
+	if *d.Owner != "Local Flight School" || *d.TypeCode != "C172" || *d.Registration != "N1BR" || !reflect.DeepEqual(d.Tags, []string{"local", "school"}) {
+		t.Fatalf("unexpected merged details %+v", d)
+	}
+	if d := all[1]; d == nil || *d.Registration != "N123PD" || !reflect.DeepEqual(d.Tags, []string{"police"}) {
+		t.Fatalf("expected override for aircraft missing from the database, got %+v", d)
+	}
+	if d := all[2]; !reflect.DeepEqual(d, expectedDetails["38bb7b"]) {
+		t.Fatalf("expected details without override to be unchanged, got %+v", d)
+	}
+	// The cached copy must not have been modified by the merge.
+	if cached, _ := m.cache.get("a0002b"); *cached.Owner != "VAN BORTEL AIRCRAFT INC" || cached.Tags != nil {
+		t.Fatalf("cached details were modified %+v", cached)
+	}
+
+	if m.overrides.reloadIfChanged() {
+		t.Fatal("expected no reload for an unchanged file")
+	}
+	// An invalid file keeps the previous overrides.
+	writeOverrides(t, file, "aircraft:\n  nothex:\n    owner: x\n", time.Now().Add(-30*time.Second))
+	if m.overrides.reloadIfChanged() || m.Lookup("abcdef") == nil {
+		t.Fatal("expected invalid overrides file to be ignored")
+	}
+	// It isn't read or logged again until it changes.
+	if m.overrides.reloadIfChanged() {
+		t.Fatal("expected no reload for an unchanged invalid file")
+	}
+	if n := bytes.Count(logs.Bytes(), []byte("failed to load overrides file")); n != 1 {
+		t.Fatalf("expected the invalid file to be logged once, got %d", n)
+	}
+	writeOverrides(t, file, "aircraft:\n  38bb7b:\n    tags: [local]\n", time.Now())
+	if !m.overrides.reloadIfChanged() {
+		t.Fatal("expected changed file to be reloaded")
+	}
+	if m.Lookup("abcdef") != nil || !reflect.DeepEqual(m.Lookup("38bb7b").Tags, []string{"local"}) {
+		t.Fatal("expected overrides to be replaced by the new file")
+	}
+}
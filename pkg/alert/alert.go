@@ -0,0 +1,210 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+)
+
+var deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "adsb_loki_alert_deliveries_total",
+	Help: "Number of events handled by each alert webhook by result, one of success, failed, dropped or suppressed.",
+}, []string{"webhook", "result"})
+
+// Config for the alert dispatcher.
+//
+//  alerts:
+//    dead_letter_file: /var/lib/adsb-loki/alerts-dead.jsonl
+//    webhooks:
+//      - name: phone
+//        preset: ntfy
+//        url: https://ntfy.sh/my-adsb-alerts
+//        events: [emergency_start, watchlist]
+//        cooldown: 30m
+type Config struct {
+	// WebhookURL is shorthand for a single generic webhook receiving every event.
+	WebhookURL     string             `yaml:"webhook_url"`
+	Webhooks       []WebhookConfig    `yaml:"webhooks"`
+	Timeout        time.Duration      `yaml:"timeout"`
+	QueueSize      int                `yaml:"queue_size"`
+	DeadLetterFile string             `yaml:"dead_letter_file"`
+	Retry          util.BackoffConfig `yaml:"retry"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.WebhookURL, "alerts.webhook-url", "", "URL to POST every event to as JSON, more webhooks can be configured in the config file")
+	f.DurationVar(&c.Timeout, "alerts.timeout", 10*time.Second, "Timeout for each webhook request")
+	f.IntVar(&c.QueueSize, "alerts.queue-size", 100, "How many events can be waiting for delivery to each webhook before new ones are dropped")
+	f.StringVar(&c.DeadLetterFile, "alerts.dead-letter-file", "", "File to append events to as JSON lines when they could not be delivered, failed events are only logged if empty")
+	c.Retry.RegisterFlags("alerts.retry", f)
+}
+
+// Enabled is true if there are any webhooks configured.
+func (c *Config) Enabled() bool {
+	return c.WebhookURL != "" || len(c.Webhooks) > 0
+}
+
+// Validate checks every webhook can be built, without starting any of them.
+func (c *Config) Validate() error {
+	_, err := c.webhooks()
+	return err
+}
+
+func (c *Config) webhooks() ([]*webhook, error) {
+	configs := c.Webhooks
+	if c.WebhookURL != "" {
+		configs = append([]WebhookConfig{{Name: "default", URL: c.WebhookURL}}, configs...)
+	}
+	names := map[string]bool{}
+	var webhooks []*webhook
+	for i, wc := range configs {
+		if wc.Name == "" {
+			wc.Name = fmt.Sprintf("webhook-%d", i)
+		}
+		if names[wc.Name] {
+			return nil, fmt.Errorf("duplicate webhook name %s", wc.Name)
+		}
+		names[wc.Name] = true
+		w, err := newWebhook(wc, *c)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %s", wc.Name, err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, nil
+}
+
+// Dispatcher delivers events to the webhooks in the background so the pipeline is never held up by them.
+type Dispatcher struct {
+	logger   log.Logger
+	config   Config
+	webhooks []*webhook
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	dlMtx    sync.Mutex
+}
+
+func New(logger log.Logger, config Config) (*Dispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		logger: log.With(logger, "component", "alerts"),
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	webhooks, err := config.webhooks()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	d.webhooks = webhooks
+	for _, w := range d.webhooks {
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d, nil
+}
+
+// Send queues the event for every webhook which wants it, it is dropped for any webhook whose queue is full.
+func (d *Dispatcher) Send(e event.Event) {
+	for _, w := range d.webhooks {
+		if !w.wants(e) {
+			continue
+		}
+		if !w.allow(e) {
+			deliveries.WithLabelValues(w.config.Name, "suppressed").Inc()
+			continue
+		}
+		select {
+		case w.queue <- e:
+		default:
+			deliveries.WithLabelValues(w.config.Name, "dropped").Inc()
+			level.Warn(d.logger).Log("msg", "alert queue full, dropping event", "webhook", w.config.Name, "type", e.Type, "hex", e.Hex)
+		}
+	}
+}
+
+func (d *Dispatcher) run(w *webhook) {
+	defer d.wg.Done()
+	for e := range w.queue {
+		if err := d.deliver(w, e); err != nil {
+			deliveries.WithLabelValues(w.config.Name, "failed").Inc()
+			level.Error(d.logger).Log("msg", "failed to deliver event", "webhook", w.config.Name, "type", e.Type, "hex", e.Hex, "err", err)
+			d.deadLetter(w, e, err)
+			continue
+		}
+		deliveries.WithLabelValues(w.config.Name, "success").Inc()
+	}
+}
+
+// deliver retries failed requests with backoff, once Stop is called each queued event gets a single attempt.
+func (d *Dispatcher) deliver(w *webhook, e event.Event) error {
+	b := util.NewBackoff(d.ctx, d.config.Retry)
+	for {
+		err := w.post(e)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(permanentError); ok || !b.Ongoing() {
+			return err
+		}
+		level.Warn(d.logger).Log("msg", "webhook request failed, retrying", "webhook", w.config.Name, "attempt", b.NumRetries()+1, "err", err)
+		b.Wait()
+	}
+}
+
+type deadLetter struct {
+	Time    time.Time   `json:"time"`
+	Webhook string      `json:"webhook"`
+	Error   string      `json:"error"`
+	Event   event.Event `json:"event"`
+}
+
+// deadLetter appends an event which could not be delivered to the dead letter file so it can be replayed by hand.
+func (d *Dispatcher) deadLetter(w *webhook, e event.Event, deliveryErr error) {
+	if d.config.DeadLetterFile == "" {
+		return
+	}
+	bts, err := json.Marshal(deadLetter{Time: time.Now(), Webhook: w.config.Name, Error: deliveryErr.Error(), Event: e})
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to marshal dead letter", "err", err)
+		return
+	}
+	d.dlMtx.Lock()
+	defer d.dlMtx.Unlock()
+	f, err := os.OpenFile(d.config.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to open dead letter file", "err", err)
+		return
+	}
+	_, err = f.Write(append(bts, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		level.Error(d.logger).Log("msg", "failed to write dead letter file", "err", err)
+	}
+}
+
+// Stop stops retrying, makes one attempt at each queued event and waits for the workers to finish.
+// Send must not be called after Stop.
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	for _, w := range d.webhooks {
+		close(w.queue)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,177 @@
+package alert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+type request struct {
+	path    string
+	headers http.Header
+	body    string
+}
+
+// receiver records requests, the statuses for a path are returned in order before it starts succeeding.
+type receiver struct {
+	mtx      sync.Mutex
+	requests []request
+	statuses map[string][]int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	bts, _ := ioutil.ReadAll(req.Body)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.requests = append(r.requests, request{path: req.URL.Path, headers: req.Header, body: string(bts)})
+	if st := r.statuses[req.URL.Path]; len(st) > 0 {
+		r.statuses[req.URL.Path] = st[1:]
+		w.WriteHeader(st[0])
+	}
+}
+
+func (r *receiver) received(path string) []request {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var reqs []request
+	for _, req := range r.requests {
+		if req.path == path {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+func stringP(v string) *string {
+	return &v
+}
+
+func testEvent(typ, hex string, t time.Time) event.Event {
+	return event.Event{
+		Type:     typ,
+		Time:     t,
+		Hex:      hex,
+		Name:     "7700",
+		Message:  "squawking 7700 General emergency",
+		Aircraft: &model.Aircraft{Hex: hex, Flight: stringP("BAW1")},
+	}
+}
+
+func Test_Dispatcher(t *testing.T) {
+	rcv := &receiver{statuses: map[string][]int{
+		"/retry": {http.StatusServiceUnavailable, http.StatusTooManyRequests},
+		"/bad":   {http.StatusBadRequest},
+	}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	deadLetters := filepath.Join(t.TempDir(), "dead.jsonl")
+
+	d, err := New(log.NewNopLogger(), Config{
+		WebhookURL:     srv.URL + "/generic",
+		Timeout:        time.Second,
+		QueueSize:      10,
+		DeadLetterFile: deadLetters,
+		Retry:          util.BackoffConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 3},
+		Webhooks: []WebhookConfig{
+			{Name: "ntfy", Preset: "ntfy", URL: srv.URL + "/ntfy", Token: "secret", Events: []string{event.EmergencyStart}, Cooldown: time.Hour},
+			{Name: "slack", Preset: "slack", URL: srv.URL + "/slack", Cooldown: time.Hour, RuleCooldowns: map[string]time.Duration{"7700": 0}},
+			{Name: "custom", URL: srv.URL + "/custom", Template: `{"who": {{ with .Aircraft }}{{ json .Flight }}{{ else }}null{{ end }}, "what": {{ json .Type }}}`},
+			{Name: "retry", URL: srv.URL + "/retry"},
+			{Name: "bad", URL: srv.URL + "/bad"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1600000000, 0)
+	d.Send(testEvent(event.EmergencyStart, "a00001", now))
+	// Within the ntfy cooldown, the slack cooldown is disabled for this rule.
+	d.Send(testEvent(event.EmergencyStart, "a00001", now.Add(time.Minute)))
+	d.Send(testEvent(event.EmergencyEnd, "a00001", now.Add(2*time.Minute)))
+	// Stop cancels any retries so wait for them to finish first.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rcv.received("/retry")) < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.Stop()
+
+	generic := rcv.received("/generic")
+	if len(generic) != 3 {
+		t.Fatalf("expected 3 generic requests got %d", len(generic))
+	}
+	e := event.Event{}
+	if err := json.Unmarshal([]byte(generic[0].body), &e); err != nil || e.Hex != "a00001" || *e.Aircraft.Flight != "BAW1" {
+		t.Fatalf("unexpected generic body %s: %v", generic[0].body, err)
+	}
+
+	ntfy := rcv.received("/ntfy")
+	if len(ntfy) != 1 {
+		t.Fatalf("expected 1 ntfy request got %d", len(ntfy))
+	}
+	h := ntfy[0].headers
+	if ntfy[0].body != "squawking 7700 General emergency" || h.Get("Title") != "emergency start: BAW1" ||
+		h.Get("Priority") != "urgent" || h.Get("Authorization") != "Bearer secret" {
+		t.Fatalf("unexpected ntfy request %+v", ntfy[0])
+	}
+
+	slack := rcv.received("/slack")
+	if len(slack) != 3 || slack[0].body != `{"text": "*emergency start: BAW1*\nsquawking 7700 General emergency"}` {
+		t.Fatalf("unexpected slack requests %+v", slack)
+	}
+
+	if custom := rcv.received("/custom"); len(custom) != 3 || custom[2].body != `{"who": "BAW1", "what": "emergency_end"}` {
+		t.Fatalf("unexpected custom requests %+v", custom)
+	}
+
+	// Two failures then success for the first event.
+	if retry := rcv.received("/retry"); len(retry) != 5 {
+		t.Fatalf("expected 5 retry requests got %d", len(retry))
+	}
+
+	// A client error is not retried.
+	if bad := rcv.received("/bad"); len(bad) != 3 {
+		t.Fatalf("expected 3 bad requests got %d", len(bad))
+	}
+	bts, err := ioutil.ReadFile(deadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bts)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one dead letter got %s", bts)
+	}
+	dl := deadLetter{}
+	if err := json.Unmarshal([]byte(lines[0]), &dl); err != nil || dl.Webhook != "bad" || dl.Event.Type != event.EmergencyStart {
+		t.Fatalf("unexpected dead letter %s: %v", lines[0], err)
+	}
+}
+
+func Test_InvalidWebhooks(t *testing.T) {
+	for _, wc := range []WebhookConfig{
+		{URL: "http://localhost", Preset: "nope"},
+		{Preset: "ntfy"},
+		{URL: "http://localhost", Template: `{"broken": {{ .Message }}}`},
+		{URL: "http://localhost", Template: `{{ .Nope }}`},
+	} {
+		if _, err := New(log.NewNopLogger(), Config{Webhooks: []WebhookConfig{wc}}); err == nil {
+			t.Errorf("expected error for %+v", wc)
+		}
+	}
+	d, err := New(log.NewNopLogger(), Config{Webhooks: []WebhookConfig{{Preset: "pushover", Token: "t", User: "u"}}})
+	if err != nil {
+		t.Fatalf("expected pushover to default its url: %s", err)
+	}
+	d.Stop()
+}
@@ -0,0 +1,294 @@
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+)
+
+// WebhookConfig is a single destination for events. The body and header values are Go templates executed
+// with the event, see templateData, and default to those of the preset. Aircraft is nil for some events
+// so templates should guard it with {{ with .Aircraft }}.
+type WebhookConfig struct {
+	Name string `yaml:"name"`
+	// Preset is one of generic, ntfy, gotify, pushover or slack, generic POSTs the event as JSON.
+	Preset      string            `yaml:"preset"`
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"`
+	Headers     map[string]string `yaml:"headers"`
+	ContentType string            `yaml:"content_type"`
+	Template    string            `yaml:"template"`
+	// Token and User are the credentials used by the gotify, ntfy and pushover presets.
+	Token string `yaml:"token"`
+	User  string `yaml:"user"`
+	// Events is the list of event types to send, all events are sent if empty.
+	Events []string `yaml:"events"`
+	// Cooldown is how long to suppress repeats of an event with the same type, rule name and aircraft.
+	Cooldown time.Duration `yaml:"cooldown"`
+	// RuleCooldowns replace Cooldown for the events produced by the named rules.
+	RuleCooldowns map[string]time.Duration `yaml:"rule_cooldowns"`
+}
+
+type preset struct {
+	url         string
+	contentType string
+	body        string
+	headers     map[string]string
+}
+
+var presets = map[string]preset{
+	"generic": {
+		contentType: "application/json",
+		body:        `{{ json .Event }}`,
+	},
+	// ntfy publishes the message as the body of a POST to the topic URL.
+	"ntfy": {
+		contentType: "text/plain",
+		body:        `{{ .Message }}`,
+		headers: map[string]string{
+			"Title":         `{{ .Title }}`,
+			"Priority":      `{{ if .Urgent }}urgent{{ else }}default{{ end }}`,
+			"Tags":          `airplane`,
+			"Authorization": `{{ if .Token }}Bearer {{ .Token }}{{ end }}`,
+		},
+	},
+	// gotify expects the URL of the message endpoint, e.g. https://gotify.example.com/message.
+	"gotify": {
+		contentType: "application/json",
+		body:        `{"title": {{ json .Title }}, "message": {{ json .Message }}, "priority": {{ if .Urgent }}8{{ else }}4{{ end }}}`,
+		headers: map[string]string{
+			"X-Gotify-Key": `{{ .Token }}`,
+		},
+	},
+	"pushover": {
+		url:         "https://api.pushover.net/1/messages.json",
+		contentType: "application/json",
+		body:        `{"token": {{ json .Token }}, "user": {{ json .User }}, "title": {{ json .Title }}, "message": {{ json .Message }}, "priority": {{ if .Urgent }}1{{ else }}0{{ end }}}`,
+	},
+	// slack also works for the many chat services which accept Slack compatible incoming webhooks.
+	"slack": {
+		contentType: "application/json",
+		body:        `{"text": {{ json (printf "*%s*\n%s" .Title .Message) }}}`,
+	},
+}
+
+// templateData is what the body and header templates are executed with.
+type templateData struct {
+	event.Event
+	// Title is a one line summary, e.g. "emergency start: N123AB".
+	Title string
+	// Urgent is set for events which should interrupt someone, the presets map it to a high priority.
+	Urgent bool
+	Token  string
+	User   string
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		bts, err := json.Marshal(v)
+		return string(bts), err
+	},
+}
+
+// permanentError is returned for failures which retrying will not fix.
+type permanentError struct {
+	error
+}
+
+type webhook struct {
+	config      WebhookConfig
+	url         string
+	method      string
+	contentType string
+	body        *template.Template
+	headers     map[string]*template.Template
+	events      map[string]bool
+	client      *http.Client
+	queue       chan event.Event
+
+	cooldownMtx sync.Mutex
+	until       map[string]time.Time
+	pruneAt     int
+}
+
+func newWebhook(wc WebhookConfig, config Config) (*webhook, error) {
+	if wc.Preset == "" {
+		wc.Preset = "generic"
+	}
+	p, ok := presets[wc.Preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %s", wc.Preset)
+	}
+	w := &webhook{
+		config:      wc,
+		url:         wc.URL,
+		method:      wc.Method,
+		contentType: wc.ContentType,
+		headers:     map[string]*template.Template{},
+		client:      &http.Client{Timeout: config.Timeout},
+		queue:       make(chan event.Event, config.QueueSize),
+		until:       map[string]time.Time{},
+		pruneAt:     1000,
+	}
+	if w.url == "" {
+		w.url = p.url
+	}
+	if w.url == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if w.method == "" {
+		w.method = http.MethodPost
+	}
+	if w.contentType == "" {
+		w.contentType = p.contentType
+	}
+	body := p.body
+	if wc.Template != "" {
+		body = wc.Template
+	}
+	var err error
+	if w.body, err = template.New("body").Funcs(funcs).Parse(body); err != nil {
+		return nil, fmt.Errorf("invalid template: %s", err)
+	}
+	headers := map[string]string{}
+	for k, v := range p.headers {
+		headers[k] = v
+	}
+	for k, v := range wc.Headers {
+		headers[k] = v
+	}
+	for k, v := range headers {
+		if w.headers[k], err = template.New(k).Funcs(funcs).Parse(v); err != nil {
+			return nil, fmt.Errorf("invalid template for header %s: %s", k, err)
+		}
+	}
+	if len(wc.Events) > 0 {
+		w.events = map[string]bool{}
+		for _, t := range wc.Events {
+			w.events[t] = true
+		}
+	}
+	// Catch templates which fail or produce invalid JSON now rather than when the first alert fires.
+	if _, err := w.request(event.Event{Type: event.EmergencyStart, Time: time.Now(), Hex: "000000", Message: "test"}); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *webhook) wants(e event.Event) bool {
+	return w.events == nil || w.events[e.Type]
+}
+
+// allow applies the cooldown, it returns false if the same event was sent too recently.
+func (w *webhook) allow(e event.Event) bool {
+	cooldown := w.config.Cooldown
+	if c, ok := w.config.RuleCooldowns[e.Name]; ok {
+		cooldown = c
+	}
+	if cooldown <= 0 {
+		return true
+	}
+	key := e.Type + "/" + e.Name + "/" + e.Hex
+	w.cooldownMtx.Lock()
+	defer w.cooldownMtx.Unlock()
+	if until, ok := w.until[key]; ok && e.Time.Before(until) {
+		return false
+	}
+	w.until[key] = e.Time.Add(cooldown)
+	if len(w.until) > w.pruneAt {
+		for k, until := range w.until {
+			if !e.Time.Before(until) {
+				delete(w.until, k)
+			}
+		}
+		w.pruneAt = 2 * len(w.until)
+		if w.pruneAt < 1000 {
+			w.pruneAt = 1000
+		}
+	}
+	return true
+}
+
+func title(e event.Event) string {
+	ident := e.Hex
+	if ac := e.Aircraft; ac != nil {
+		if ac.Flight != nil && *ac.Flight != "" {
+			ident = *ac.Flight
+		} else if ac.Registration != nil {
+			ident = *ac.Registration
+		}
+	}
+	if ident == "" {
+		return strings.ReplaceAll(e.Type, "_", " ")
+	}
+	return fmt.Sprintf("%s: %s", strings.ReplaceAll(e.Type, "_", " "), ident)
+}
+
+func (w *webhook) request(e event.Event) (*http.Request, error) {
+	data := templateData{
+		Event:  e,
+		Title:  title(e),
+		Urgent: e.Type == event.EmergencyStart,
+		Token:  w.config.Token,
+		User:   w.config.User,
+	}
+	body := &bytes.Buffer{}
+	if err := w.body.Execute(body, data); err != nil {
+		return nil, permanentError{fmt.Errorf("executing template: %s", err)}
+	}
+	if strings.HasSuffix(w.contentType, "json") && !json.Valid(body.Bytes()) {
+		return nil, permanentError{fmt.Errorf("template produced invalid JSON: %s", body.String())}
+	}
+	req, err := http.NewRequest(w.method, w.url, body)
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	req.Header.Set("Content-Type", w.contentType)
+	keys := make([]string, 0, len(w.headers))
+	for k := range w.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := &strings.Builder{}
+		if err := w.headers[k].Execute(v, data); err != nil {
+			return nil, permanentError{fmt.Errorf("executing template for header %s: %s", k, err)}
+		}
+		// Headers which render empty are left out so presets can have optional headers.
+		if v.Len() > 0 {
+			req.Header.Set(k, v.String())
+		}
+	}
+	return req, nil
+}
+
+func (w *webhook) post(e event.Event) error {
+	req, err := w.request(e)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	default:
+		return permanentError{fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))}
+	}
+}
@@ -0,0 +1,288 @@
+package backfill
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	"github.com/grafana/loki/pkg/logproto"
+	promodel "github.com/prometheus/common/model"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Trace point flags from readsb's globe_history format.
+const (
+	flagStale        = 1
+	flagAltGeometric = 8
+)
+
+// Pipeline is the enrichment used for live reports, imported reports go through exactly the same steps.
+type Pipeline interface {
+	Process(rpt *model.Report)
+	Labels(ac model.Aircraft) promodel.LabelSet
+	// Flush returns reports of any aircraft which Process held back, that are due by until.
+	Flush(until time.Time) []*model.Report
+}
+
+// file is either a readsb history_*.json snapshot, which is the same as aircraft.json,
+// or a globe_history trace_full_*.json / trace_recent_*.json trace of a single aircraft.
+type file struct {
+	Now      *float64         `json:"now"`
+	Aircraft []model.Aircraft `json:"aircraft"`
+
+	ICAO         string              `json:"icao"`
+	Timestamp    float64             `json:"timestamp"`
+	Registration *string             `json:"r"`
+	TypeCode     *string             `json:"t"`
+	Description  *string             `json:"desc"`
+	Trace        [][]json.RawMessage `json:"trace"`
+}
+
+// Collector gathers the aircraft from history and trace files into reports keyed on their timestamp.
+type Collector struct {
+	// reports holds the aircraft seen at each timestamp keyed on hex, the first file to add an aircraft wins.
+	reports map[float64]map[string]model.Aircraft
+}
+
+func NewCollector() *Collector {
+	return &Collector{reports: map[float64]map[string]model.Aircraft{}}
+}
+
+// AddFile reads a history or trace file, which may be gzipped whatever its name.
+func (c *Collector) AddFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.Add(f); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// Add reads a history or trace file from r.
+func (c *Collector) Add(r io.Reader) error {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	f := file{}
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return fmt.Errorf("error decoding file: %s", err)
+	}
+	switch {
+	case f.Now != nil:
+		for _, ac := range f.Aircraft {
+			c.add(*f.Now, ac)
+		}
+	case f.ICAO != "":
+		for i, p := range f.Trace {
+			if err := c.addTracePoint(&f, p); err != nil {
+				return fmt.Errorf("trace point %d: %s", i, err)
+			}
+		}
+	default:
+		return fmt.Errorf("not a history or trace file")
+	}
+	return nil
+}
+
+func (c *Collector) add(now float64, ac model.Aircraft) {
+	if ac.Flight != nil {
+		trimmed := strings.TrimSpace(*ac.Flight)
+		ac.Flight = &trimmed
+	}
+	byHex, ok := c.reports[now]
+	if !ok {
+		byHex = map[string]model.Aircraft{}
+		c.reports[now] = byHex
+	}
+	if _, ok := byHex[ac.Hex]; !ok {
+		byHex[ac.Hex] = ac
+	}
+}
+
+// addTracePoint converts a point, [offset, lat, lon, altitude, ground speed, track, flags, vertical rate, details, ...],
+// into an aircraft. The details are only present when something other than the position changed.
+func (c *Collector) addTracePoint(f *file, p []json.RawMessage) error {
+	if len(p) < 7 {
+		return fmt.Errorf("expected at least 7 fields, got %d", len(p))
+	}
+	var offset float64
+	if err := json.Unmarshal(p[0], &offset); err != nil {
+		return err
+	}
+	var flags int
+	if err := json.Unmarshal(p[6], &flags); err != nil {
+		return err
+	}
+	if flags&flagStale != 0 {
+		return nil
+	}
+	ac := model.Aircraft{}
+	if len(p) > 8 && !isNull(p[8]) {
+		if err := json.Unmarshal(p[8], &ac); err != nil {
+			return err
+		}
+	}
+	// Non ICAO addresses keep their ~ prefix, the same as in aircraft.json.
+	ac.Hex = strings.ToLower(f.ICAO)
+	ac.Registration, ac.TypeCode, ac.Description = f.Registration, f.TypeCode, f.Description
+	var err error
+	if ac.Lat, err = optionalFloat(p[1]); err != nil {
+		return err
+	}
+	if ac.Lon, err = optionalFloat(p[2]); err != nil {
+		return err
+	}
+	if ac.GroundSpeed, err = optionalFloat(p[4]); err != nil {
+		return err
+	}
+	if ac.Track, err = optionalFloat(p[5]); err != nil {
+		return err
+	}
+	var alt interface{}
+	if err := json.Unmarshal(p[3], &alt); err != nil {
+		return err
+	}
+	switch v := alt.(type) {
+	case float64:
+		if flags&flagAltGeometric != 0 {
+			ac.GeometricAltitude = &v
+		} else {
+			ac.BarometerAltitude = v
+		}
+	case string:
+		ac.BarometerAltitude = v
+	}
+	// Round to the tenth of a second the offsets are written with so float noise doesn't split reports.
+	c.add(float64(int64((f.Timestamp+offset)*10+0.5))/10, ac)
+	return nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+func optionalFloat(raw json.RawMessage) (*float64, error) {
+	if isNull(raw) {
+		return nil, nil
+	}
+	var v float64
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Reports returns the collected reports between from and to, oldest first, with the aircraft in hex order.
+// A zero from or to leaves that end open.
+func (c *Collector) Reports(from, to time.Time) []*model.Report {
+	rpts := make([]*model.Report, 0, len(c.reports))
+	for now, byHex := range c.reports {
+		rpt := &model.Report{Now: now}
+		t := rpt.Time()
+		if !from.IsZero() && t.Before(from) || !to.IsZero() && t.After(to) {
+			continue
+		}
+		for _, ac := range byHex {
+			rpt.Aircraft = append(rpt.Aircraft, ac)
+		}
+		sort.Slice(rpt.Aircraft, func(i, j int) bool { return rpt.Aircraft[i].Hex < rpt.Aircraft[j].Hex })
+		rpts = append(rpts, rpt)
+	}
+	sort.Slice(rpts, func(i, j int) bool { return rpts[i].Now < rpts[j].Now })
+	return rpts
+}
+
+// Entries runs the reports through the pipeline in order and returns a line for every aircraft, timestamped
+// with the report it came from. Positions the pipeline is still holding back at the end are flushed, unless
+// they aren't due yet. The entries are sorted by time so every stream is in order.
+func Entries(p Pipeline, rpts []*model.Report) ([]api.Entry, error) {
+	var entries []api.Entry
+	var err error
+	for _, rpt := range rpts {
+		p.Process(rpt)
+		if entries, err = appendEntries(entries, p, rpt); err != nil {
+			return nil, err
+		}
+	}
+	for _, rpt := range p.Flush(time.Now()) {
+		if entries, err = appendEntries(entries, p, rpt); err != nil {
+			return nil, err
+		}
+	}
+	// The reports are processed oldest first so this rarely moves anything, but Loki rejects a stream which goes backwards.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp.Before(entries[j].Timestamp) })
+	return entries, nil
+}
+
+func appendEntries(entries []api.Entry, p Pipeline, rpt *model.Report) ([]api.Entry, error) {
+	for _, ac := range rpt.Aircraft {
+		bts, err := json.Marshal(ac)
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling aircraft %s: %s", ac.Hex, err)
+		}
+		entries = append(entries, api.Entry{
+			Labels: p.Labels(ac),
+			Entry: logproto.Entry{
+				Timestamp: rpt.Time(),
+				Line:      string(bts),
+			},
+		})
+	}
+	return entries, nil
+}
+
+// Push sends the entries to ch in chunks of chunkSize, waiting between chunks so no more than rate
+// entries a second are sent on average. A rate of 0 sends everything as fast as ch accepts it.
+func Push(ctx context.Context, ch chan<- api.Entry, entries []api.Entry, chunkSize int, rate float64) error {
+	if chunkSize <= 0 {
+		return fmt.Errorf("chunk size must be positive")
+	}
+	var tick <-chan time.Time
+	if rate > 0 {
+		t := time.NewTicker(time.Duration(float64(chunkSize) / rate * float64(time.Second)))
+		defer t.Stop()
+		tick = t.C
+	}
+	for start := 0; start < len(entries); start += chunkSize {
+		if start > 0 && tick != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-tick:
+			}
+		}
+		end := start + chunkSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		for _, e := range entries[start:end] {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case ch <- e:
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package backfill
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+	promodel "github.com/prometheus/common/model"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+const testHistory = `{"now":1600000010.0,"messages":10,"aircraft":[
+{"hex":"a00002","flight":"UAL1    ","lat":40.1,"lon":-105.1,"alt_baro":5000},
+{"hex":"a00001","alt_baro":"ground"}]}`
+
+const testTrace = `{"icao":"a00001","r":"N1","t":"C172","timestamp":1600000000,"trace":[
+[0,40.0,-105.0,"ground",5.1,90.0,0,null,{"flight":"N1      ","squawk":"1200"}],
+[10,40.01,-105.01,1000,80,90,0,500,null],
+[15.1,40.02,-105.02,1500,85,91,8,500,null],
+[20,40.03,-105.03,2000,90,92,1,500,null]]}`
+
+func gzipped(t *testing.T, s string) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func Test_Collector(t *testing.T) {
+	c := NewCollector()
+	if err := c.Add(strings.NewReader(testHistory)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(gzipped(t, testTrace)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(strings.NewReader(`{"something":"else"}`)); err == nil {
+		t.Fatal("expected an error for a file which is neither history nor trace")
+	}
+
+	rpts := c.Reports(time.Time{}, time.Time{})
+	// The stale trace point is skipped and the trace point at 10s is the same time as the history snapshot.
+	nows := []float64{1600000000, 1600000010, 1600000015.1}
+	if len(rpts) != len(nows) {
+		t.Fatalf("expected %d reports, got %d", len(nows), len(rpts))
+	}
+	for i, now := range nows {
+		if rpts[i].Now != now {
+			t.Errorf("report %d: expected now %v got %v", i, now, rpts[i].Now)
+		}
+	}
+
+	first := rpts[0].Aircraft[0]
+	if *first.Flight != "N1" || *first.Squawk != "1200" || *first.Registration != "N1" || first.BarometerAltitude != "ground" {
+		t.Errorf("unexpected aircraft from trace details %+v", first)
+	}
+	// The history file was added first so its aircraft wins where both have the same time.
+	snap := rpts[1].Aircraft
+	if len(snap) != 2 || snap[0].Hex != "a00001" || snap[0].Lat != nil || *snap[1].Flight != "UAL1" {
+		t.Errorf("unexpected snapshot %+v", snap)
+	}
+	if ac := rpts[2].Aircraft[0]; ac.BarometerAltitude != nil || *ac.GeometricAltitude != 1500 || *ac.Track != 91 {
+		t.Errorf("expected geometric altitude from the flags, got %+v", ac)
+	}
+
+	from, to := time.Unix(1600000005, 0), time.Unix(1600000012, 0)
+	if rpts := c.Reports(from, to); len(rpts) != 1 || rpts[0].Now != 1600000010 {
+		t.Errorf("expected only the report between from and to, got %d", len(rpts))
+	}
+}
+
+type testPipeline struct{}
+
+func (testPipeline) Process(rpt *model.Report) {
+	kept := rpt.Aircraft[:0]
+	for _, ac := range rpt.Aircraft {
+		if ac.Hex != "a00002" {
+			kept = append(kept, ac)
+		}
+	}
+	rpt.Aircraft = kept
+}
+
+func (testPipeline) Labels(ac model.Aircraft) promodel.LabelSet {
+	return promodel.LabelSet{"hex": promodel.LabelValue(ac.Hex)}
+}
+
+func (testPipeline) Flush(time.Time) []*model.Report {
+	return nil
+}
+
+// holdingPipeline holds back every aircraft until it's flushed.
+type holdingPipeline struct {
+	testPipeline
+	held []model.Aircraft
+}
+
+func (p *holdingPipeline) Process(rpt *model.Report) {
+	p.held = append(p.held, rpt.Aircraft...)
+	rpt.Aircraft = nil
+}
+
+func (p *holdingPipeline) Flush(until time.Time) []*model.Report {
+	rpt := &model.Report{Now: 200, Aircraft: p.held}
+	p.held = nil
+	return []*model.Report{rpt}
+}
+
+func Test_EntriesFlush(t *testing.T) {
+	p := &holdingPipeline{}
+	entries, err := Entries(p, []*model.Report{{Now: 100, Aircraft: []model.Aircraft{{Hex: "a00001"}, {Hex: "a00003"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[0].Timestamp.Equal(time.Unix(200, 0)) {
+		t.Fatalf("expected the held aircraft to be flushed, got %+v", entries)
+	}
+}
+
+func Test_EntriesAndPush(t *testing.T) {
+	rpts := []*model.Report{
+		{Now: 100, Aircraft: []model.Aircraft{{Hex: "a00001"}, {Hex: "a00002"}}},
+		{Now: 101.5, Aircraft: []model.Aircraft{{Hex: "a00001"}}},
+		{Now: 102, Aircraft: []model.Aircraft{{Hex: "a00003"}, {Hex: "a00001"}}},
+	}
+	entries, err := Entries(testPipeline{}, rpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Timestamp.Before(entries[i-1].Timestamp) {
+			t.Fatalf("entries out of order at %d", i)
+		}
+	}
+	if !entries[1].Timestamp.Equal(time.Unix(101, 5e8)) || entries[1].Line != `{"hex":"a00001"}` {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+
+	ch := make(chan api.Entry, len(entries))
+	start := time.Now()
+	// Two chunks of 2 at 20 lines a second means waiting 100ms between them.
+	if err := Push(context.Background(), ch, entries, 2, 20); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("expected the rate limit to wait between chunks, took %s", elapsed)
+	}
+	if len(ch) != len(entries) {
+		t.Errorf("expected all entries to be pushed, got %d", len(ch))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Push(ctx, make(chan api.Entry), entries, 2, 0); err != context.Canceled {
+		t.Errorf("expected push to stop when cancelled, got %v", err)
+	}
+}
@@ -2,28 +2,139 @@ package cfg
 
 import (
 	"flag"
+	"reflect"
+	"sort"
+	"strings"
 
+	"github.com/cortexproject/cortex/pkg/util/flagext"
 	"github.com/slim-bean/adsb-loki/pkg/aircraft"
 
 	"github.com/grafana/loki/clients/pkg/promtail/client"
+	"github.com/prometheus/common/model"
+
+	"github.com/slim-bean/adsb-loki/pkg/alert"
+	"github.com/slim-bean/adsb-loki/pkg/geofence"
+	"github.com/slim-bean/adsb-loki/pkg/icaotype"
+	"github.com/slim-bean/adsb-loki/pkg/operator"
+	"github.com/slim-bean/adsb-loki/pkg/overflight"
+	"github.com/slim-bean/adsb-loki/pkg/piaware"
+	"github.com/slim-bean/adsb-loki/pkg/privacy"
+	"github.com/slim-bean/adsb-loki/pkg/recording"
+	"github.com/slim-bean/adsb-loki/pkg/route"
+	"github.com/slim-bean/adsb-loki/pkg/server"
+	"github.com/slim-bean/adsb-loki/pkg/squawk"
+	"github.com/slim-bean/adsb-loki/pkg/track"
+	"github.com/slim-bean/adsb-loki/pkg/watchlist"
 
 	"github.com/slim-bean/adsb-loki/pkg/registration"
 )
 
 type Config struct {
+	ServerConfig          server.Config                 `yaml:"server,omitempty"`
 	ClientConfigs         []client.Config               `yaml:"clients,omitempty"`
 	ADSBURL               string                        `yaml:"adsb_url"`
+	SourceConfig          piaware.Config                `yaml:"source,omitempty"`
+	RecordingConfig       recording.Config              `yaml:"recording,omitempty"`
 	RegManagerConfig      registration.RegManagerConfig `yaml:"reg_manager,omitempty"`
 	AircraftManagerConfig aircraft.Config               `yaml:"aircraft_manager,omitempty"`
+	OperatorConfig        operator.Config               `yaml:"operators,omitempty"`
+	RouteConfig           route.Config                  `yaml:"routes,omitempty"`
+	AircraftTypeConfig    icaotype.Config               `yaml:"aircraft_types,omitempty"`
+	SquawkConfig          squawk.Config                 `yaml:"squawks,omitempty"`
+	PrivacyConfig         privacy.Config                `yaml:"privacy,omitempty"`
+	WatchlistConfig       watchlist.Config              `yaml:"watchlist,omitempty"`
+	GeofenceConfig        geofence.Config               `yaml:"geofences,omitempty"`
+	OverflightConfig      overflight.Config             `yaml:"overflights,omitempty"`
+	TrackConfig           track.Config                  `yaml:"tracks,omitempty"`
+	AlertConfig           alert.Config                  `yaml:"alerts,omitempty"`
+	Labels                LabelsConfig                  `yaml:"labels,omitempty"`
+}
+
+// LabelsConfig controls which optional labels are attached to each aircraft's Loki stream.
+type LabelsConfig struct {
+	// Tags from the overrides file which become a tag_<name>="true" label when present on an aircraft.
+	Tags flagext.StringSliceCSV `yaml:"tags"`
+	// Operator adds the operator's ICAO designator as an operator label.
+	Operator bool `yaml:"operator"`
+}
+
+// TagLabelName converts an override tag into a valid label name.
+func TagLabelName(tag string) model.LabelName {
+	return model.LabelName("tag_" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, tag))
+}
+
+func (c *LabelsConfig) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&c.Tags, "labels.tags", "Comma separated list of override tags to add as tag_<name> labels")
+	f.BoolVar(&c.Operator, "labels.operator", false, "Add the ICAO designator of the operator as an operator label")
 }
 
 // RegisterFlags with prefix registers flags where every name is prefixed by
 // prefix. If prefix is a non-empty string, prefix should end with a period.
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	c.ServerConfig.RegisterFlags(f)
 	for i := range c.ClientConfigs {
 		c.ClientConfigs[i].RegisterFlags(f)
 	}
 	f.StringVar(&c.ADSBURL, "adsb-url", "http://localhost:8080/data/aircraft.json", "Where to find the aircraft.json file")
+	c.SourceConfig.RegisterFlags(f)
+	c.RecordingConfig.RegisterFlags(f)
 	c.RegManagerConfig.RegisterFlags(f)
 	c.AircraftManagerConfig.RegisterFlags(f)
+	c.OperatorConfig.RegisterFlags(f)
+	c.RouteConfig.RegisterFlags(f)
+	c.AircraftTypeConfig.RegisterFlags(f)
+	c.SquawkConfig.RegisterFlags(f)
+	c.PrivacyConfig.RegisterFlags(f)
+	c.WatchlistConfig.RegisterFlags(f)
+	c.GeofenceConfig.RegisterFlags(f)
+	c.OverflightConfig.RegisterFlags(f)
+	c.TrackConfig.RegisterFlags(f)
+	c.AlertConfig.RegisterFlags(f)
+	c.Labels.RegisterFlags(f)
+}
+
+// Diff returns the dotted YAML keys of every value which differs between two configs, such as labels.tags.
+// Lists are compared as a whole so a change to any client is reported as clients.
+func Diff(old, new *Config) []string {
+	var keys []string
+	diff("", reflect.ValueOf(*old), reflect.ValueOf(*new), &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+func diff(prefix string, o, n reflect.Value, keys *[]string) {
+	// Only this module's config sections are walked, anything else like a URL is compared as a whole.
+	if o.Kind() != reflect.Struct || !strings.HasPrefix(o.Type().PkgPath(), "github.com/slim-bean/adsb-loki/") {
+		if !reflect.DeepEqual(o.Interface(), n.Interface()) {
+			*keys = append(*keys, prefix)
+		}
+		return
+	}
+	for i := 0; i < o.NumField(); i++ {
+		f := o.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("yaml"), ",")
+		name := tag[0]
+		switch {
+		case name == "-":
+			continue
+		case len(tag) > 1 && tag[1] == "inline":
+			name = prefix
+		case name == "":
+			name = strings.ToLower(f.Name)
+			fallthrough
+		default:
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+		}
+		diff(name, o.Field(i), n.Field(i), keys)
+	}
 }
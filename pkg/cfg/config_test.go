@@ -0,0 +1,41 @@
+package cfg
+
+import (
+	"flag"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/grafana/loki/clients/pkg/promtail/client"
+
+	"github.com/slim-bean/adsb-loki/pkg/watchlist"
+)
+
+func defaults(t *testing.T) *Config {
+	c := &Config{}
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	c.RegisterFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_Diff(t *testing.T) {
+	old, new := defaults(t), defaults(t)
+	if keys := Diff(old, new); len(keys) != 0 {
+		t.Fatalf("expected no differences, got %v", keys)
+	}
+
+	u, _ := url.Parse("http://loki:3100/loki/api/v1/push")
+	new.ClientConfigs = []client.Config{{URL: flagext.URLValue{URL: u}}}
+	new.Labels.Tags = flagext.StringSliceCSV{"police"}
+	new.WatchlistConfig.Rules = []watchlist.Rule{{Name: "police", Owner: "sheriff"}}
+	new.ADSBURL = "http://receiver/data/aircraft.json"
+	keys := Diff(old, new)
+	expected := []string{"adsb_url", "clients", "labels.tags", "watchlist.rules"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+}
@@ -0,0 +1,308 @@
+package cfg
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/grafana/loki/clients/pkg/promtail/client"
+	"github.com/prometheus/common/model"
+
+	"github.com/slim-bean/adsb-loki/pkg/download"
+	"github.com/slim-bean/adsb-loki/pkg/geofence"
+	"github.com/slim-bean/adsb-loki/pkg/privacy"
+	"github.com/slim-bean/adsb-loki/pkg/recording"
+	"github.com/slim-bean/adsb-loki/pkg/squawk"
+	"github.com/slim-bean/adsb-loki/pkg/watchlist"
+)
+
+// problems collects every problem found so they can all be fixed at once.
+type problems []error
+
+func (p *problems) add(key string, format string, args ...interface{}) {
+	*p = append(*p, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+}
+
+func (p *problems) check(key string, err error) {
+	if err != nil {
+		p.add(key, "%s", err)
+	}
+}
+
+// Validate checks the config without starting anything, every problem is returned rather than only the first.
+// Files which are read must exist and directories which are written to must be writable.
+func (c *Config) Validate() []error {
+	p := &problems{}
+
+	if c.RecordingConfig.Replay == "" || c.ADSBURL != "" {
+		p.check("adsb_url", checkURL(c.ADSBURL))
+	}
+	sc := c.SourceConfig
+	if sc.Interval <= 0 {
+		p.add("source.interval", "must be positive")
+	}
+	if sc.MaxBackoff < sc.Interval {
+		p.add("source.max_backoff", "must be at least the interval")
+	}
+	if sc.StaleAfter < 0 {
+		p.add("source.stale_after", "must not be negative")
+	}
+	if sc.Timeout <= 0 {
+		p.add("source.timeout", "must be positive")
+	}
+	p.check("source", sc.Client.Validate())
+	if a := c.ServerConfig.HTTPListenAddress; a != "" {
+		p.check("server.http_listen_address", checkListenAddress(a))
+	}
+	if len(c.ClientConfigs) == 0 {
+		p.add("clients", "at least one Loki client is required")
+	}
+	for i, cc := range c.ClientConfigs {
+		validateClient(p, fmt.Sprintf("clients[%d]", i), cc)
+	}
+
+	rc := c.RecordingConfig
+	if rc.Dir != "" {
+		p.check("recording.dir", checkWritableDir(rc.Dir, true))
+		if rc.Rotate <= 0 {
+			p.add("recording.rotate", "must be positive")
+		}
+	}
+	if rc.Replay != "" {
+		if files, err := recording.ReplayFiles(rc.Replay); err != nil {
+			p.add("recording.replay", "%s", err)
+		} else if len(files) == 0 {
+			p.add("recording.replay", "no recordings match %s", rc.Replay)
+		}
+		if rc.ReplaySpeed < 0 {
+			p.add("recording.replay_speed", "must not be negative")
+		}
+	}
+
+	am := c.AircraftManagerConfig
+	p.check("aircraft_manager.directory", checkWritableDir(am.Directory, false))
+	p.check("aircraft_manager.db_file", checkWritableDir(filepath.Dir(am.BoltDbFile), false))
+	p.check("aircraft_manager.url", checkURL(am.URL))
+	if am.CacheSize < 0 {
+		p.add("aircraft_manager.cache_size", "must not be negative")
+	}
+	if am.OverridesFile != "" {
+		p.check("aircraft_manager.overrides_file", checkReadable(am.OverridesFile))
+		if am.OverridesCheckInterval <= 0 {
+			p.add("aircraft_manager.overrides_check_interval", "must be positive")
+		}
+	}
+	validateDownload(p, "aircraft_manager.download", am.Download)
+
+	if f := c.OperatorConfig.File; f != "" {
+		p.check("operators.file", checkReadable(f))
+	}
+	if f := c.AircraftTypeConfig.File; f != "" {
+		p.check("aircraft_types.file", checkReadable(f))
+	}
+	if rt := c.RouteConfig; rt.RoutesFile != "" {
+		p.check("routes.routes_file", checkReadable(rt.RoutesFile))
+		if rt.AirportsFile != "" {
+			p.check("routes.airports_file", checkReadable(rt.AirportsFile))
+		}
+		p.check("routes.db_file", checkWritableDir(filepath.Dir(rt.BoltDbFile), false))
+	}
+
+	_, err := squawk.New(c.SquawkConfig)
+	p.check("squawks", err)
+	_, err = privacy.New(c.PrivacyConfig)
+	p.check("privacy", err)
+	_, err = watchlist.New(c.WatchlistConfig)
+	p.check("watchlist", err)
+	_, err = geofence.New(c.GeofenceConfig)
+	p.check("geofences", err)
+	for i, z := range c.GeofenceConfig.Zones {
+		key := fmt.Sprintf("geofences.zones[%d]", i)
+		if z.Circle != nil {
+			p.check(key+".circle", checkLatLon(z.Circle.Lat, z.Circle.Lon))
+		}
+		for j, pt := range z.Polygon {
+			if len(pt) == 2 {
+				p.check(fmt.Sprintf("%s.polygon[%d]", key, j), checkLatLon(pt[0], pt[1]))
+			}
+		}
+	}
+
+	oc := c.OverflightConfig
+	if len(oc.Points) > 0 {
+		names := map[string]bool{}
+		for i, pt := range oc.Points {
+			key := fmt.Sprintf("overflights.points[%d]", i)
+			if pt.Name == "" {
+				p.add(key, "must have a name")
+			} else if names[pt.Name] {
+				p.add(key, "duplicate point %s", pt.Name)
+			}
+			names[pt.Name] = true
+			p.check(key, checkLatLon(pt.Lat, pt.Lon))
+		}
+		p.check("overflights.db_file", checkWritableDir(filepath.Dir(oc.BoltDbFile), false))
+		if oc.MaxDistanceKm <= 0 {
+			p.add("overflights.max_distance_km", "must be positive")
+		}
+	}
+
+	if c.TrackConfig.Retention < 0 {
+		p.add("tracks.retention", "must not be negative")
+	}
+
+	p.check("alerts", c.AlertConfig.Validate())
+	if u := c.AlertConfig.WebhookURL; u != "" {
+		p.check("alerts.webhook_url", checkURL(u))
+	}
+	for i, w := range c.AlertConfig.Webhooks {
+		p.check(fmt.Sprintf("alerts.webhooks[%d].url", i), checkURL(w.URL))
+	}
+	if f := c.AlertConfig.DeadLetterFile; f != "" {
+		p.check("alerts.dead_letter_file", checkWritableDir(filepath.Dir(f), false))
+	}
+
+	tags := map[model.LabelName]string{}
+	for _, t := range c.Labels.Tags {
+		if t == "" {
+			p.add("labels.tags", "tags must not be empty")
+			continue
+		}
+		ln := TagLabelName(t)
+		if other, ok := tags[ln]; ok {
+			p.add("labels.tags", "%s and %s are both the label %s", other, t, ln)
+		}
+		tags[ln] = t
+	}
+
+	return *p
+}
+
+func validateClient(p *problems, key string, cc client.Config) {
+	if cc.URL.URL == nil {
+		p.add(key+".url", "is required")
+	} else {
+		p.check(key+".url", checkURL(cc.URL.String()))
+	}
+	if cc.BatchWait <= 0 {
+		p.add(key+".batchwait", "must be positive")
+	}
+	if cc.BatchSize <= 0 {
+		p.add(key+".batchsize", "must be positive")
+	}
+	if cc.Timeout <= 0 {
+		p.add(key+".timeout", "must be positive")
+	}
+	b := cc.BackoffConfig
+	if b.MinBackoff <= 0 || b.MaxBackoff < b.MinBackoff {
+		p.add(key+".backoff_config", "min_period must be positive and no more than max_period")
+	}
+	if b.MaxRetries < 0 {
+		p.add(key+".backoff_config.max_retries", "must not be negative")
+	}
+	for n, v := range cc.ExternalLabels.LabelSet {
+		if !n.IsValid() {
+			p.add(key+".external_labels", "invalid label name %q", n)
+		}
+		if !v.IsValid() {
+			p.add(key+".external_labels", "invalid value for label %s", n)
+		}
+	}
+	p.check(key, cc.Client.Validate())
+}
+
+func validateDownload(p *problems, key string, d download.Config) {
+	if d.RefreshInterval <= 0 {
+		p.add(key+".refresh_interval", "must be positive")
+	}
+	if d.Timeout <= 0 {
+		p.add(key+".timeout", "must be positive")
+	}
+	if d.MaxSize < 0 {
+		p.add(key+".max_size", "must not be negative")
+	}
+	if d.SHA256 != "" {
+		if b, err := hex.DecodeString(d.SHA256); err != nil || len(b) != 32 {
+			p.add(key+".sha256", "must be 64 hex characters")
+		}
+	}
+}
+
+func checkURL(s string) error {
+	if s == "" {
+		return fmt.Errorf("is required")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s must be an http or https URL", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s has no host", s)
+	}
+	return nil
+}
+
+func checkListenAddress(a string) error {
+	_, port, err := net.SplitHostPort(a)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %s", port)
+	}
+	return nil
+}
+
+func checkLatLon(lat, lon float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %v must be between -90 and 90", lat)
+	}
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude %v must be between -180 and 180", lon)
+	}
+	return nil
+}
+
+func checkReadable(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// checkWritableDir creates and removes a file in dir. If create is set the directory is created when it's needed,
+// so it only has to be possible to create it.
+func checkWritableDir(dir string, create bool) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if !create || parent == dir {
+			return fmt.Errorf("directory %s does not exist", dir)
+		}
+		dir = parent
+	}
+	f, err := ioutil.TempFile(dir, ".adsb-loki-check-")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %s", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,114 @@
+package cfg
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+	"github.com/grafana/loki/clients/pkg/promtail/client"
+
+	"github.com/slim-bean/adsb-loki/pkg/alert"
+	"github.com/slim-bean/adsb-loki/pkg/geofence"
+	"github.com/slim-bean/adsb-loki/pkg/overflight"
+	"github.com/slim-bean/adsb-loki/pkg/watchlist"
+)
+
+// valid is the defaults with a Loki client and every file in a temporary directory.
+func valid(t *testing.T) *Config {
+	c := defaults(t)
+	dir := t.TempDir()
+	u, _ := url.Parse("http://loki:3100/loki/api/v1/push")
+	c.ClientConfigs = []client.Config{{
+		URL:           flagext.URLValue{URL: u},
+		BatchWait:     time.Second,
+		BatchSize:     client.BatchSize,
+		Timeout:       10 * time.Second,
+		BackoffConfig: util.BackoffConfig{MinBackoff: 500 * time.Millisecond, MaxBackoff: 5 * time.Minute, MaxRetries: 10},
+	}}
+	c.AircraftManagerConfig.Directory = dir
+	c.AircraftManagerConfig.BoltDbFile = filepath.Join(dir, "aircraft.db")
+	c.RouteConfig.BoltDbFile = filepath.Join(dir, "routes.db")
+	c.OverflightConfig.BoltDbFile = filepath.Join(dir, "overflights.db")
+	return c
+}
+
+func Test_Validate(t *testing.T) {
+	if errs := valid(t).Validate(); len(errs) != 0 {
+		t.Fatalf("expected no problems, got %v", errs)
+	}
+
+	for name, tc := range map[string]struct {
+		modify   func(c *Config)
+		expected []string
+	}{
+		"no clients": {
+			modify:   func(c *Config) { c.ClientConfigs = nil },
+			expected: []string{"clients: at least one Loki client is required"},
+		},
+		"bad urls": {
+			modify: func(c *Config) {
+				c.ADSBURL = "localhost:8080/data/aircraft.json"
+				c.AircraftManagerConfig.URL = "https://"
+			},
+			expected: []string{"adsb_url: ", "aircraft_manager.url: "},
+		},
+		"client": {
+			modify: func(c *Config) {
+				c.ClientConfigs[0].BatchSize = 0
+				c.ClientConfigs[0].BackoffConfig.MaxBackoff = time.Millisecond
+			},
+			expected: []string{"clients[0].batchsize: ", "clients[0].backoff_config: "},
+		},
+		"missing files": {
+			modify: func(c *Config) {
+				c.AircraftManagerConfig.Directory = filepath.Join(c.AircraftManagerConfig.Directory, "missing")
+				c.OperatorConfig.File = filepath.Join(t.TempDir(), "operators.csv")
+				c.RecordingConfig.Replay = filepath.Join(t.TempDir(), "*.jsonl")
+			},
+			expected: []string{"recording.replay: ", "aircraft_manager.directory: ", "operators.file: "},
+		},
+		"empty replay directory": {
+			modify: func(c *Config) {
+				c.RecordingConfig.Replay = t.TempDir()
+			},
+			expected: []string{"recording.replay: no recordings match "},
+		},
+		"rules": {
+			modify: func(c *Config) {
+				c.WatchlistConfig.Rules = []watchlist.Rule{{Name: "empty"}}
+				c.GeofenceConfig.Zones = []geofence.Zone{{Name: "north", Circle: &geofence.Circle{Lat: 91, Lon: 0, RadiusKm: 1}}}
+				c.OverflightConfig.Points = []overflight.Point{{Name: "home", Lat: 0, Lon: 181}}
+				c.AlertConfig.Webhooks = []alert.WebhookConfig{{URL: "ftp://alerts"}}
+			},
+			expected: []string{"watchlist: ", "geofences.zones[0].circle: latitude 91", "overflights.points[0]: longitude 181", "alerts.webhooks[0].url: "},
+		},
+		"tags": {
+			modify:   func(c *Config) { c.Labels.Tags = flagext.StringSliceCSV{"air-ambulance", "air_ambulance"} },
+			expected: []string{"labels.tags: air-ambulance and air_ambulance are both the label tag_air_ambulance"},
+		},
+		"recording": {
+			modify: func(c *Config) {
+				c.RecordingConfig.Dir = filepath.Join(t.TempDir(), "created", "later")
+				c.RecordingConfig.Rotate = 0
+			},
+			expected: []string{"recording.rotate: must be positive"},
+		},
+	} {
+		c := valid(t)
+		tc.modify(c)
+		errs := c.Validate()
+		if len(errs) != len(tc.expected) {
+			t.Errorf("%s: expected %d problems, got %v", name, len(tc.expected), errs)
+			continue
+		}
+		for i, err := range errs {
+			if !strings.HasPrefix(err.Error(), tc.expected[i]) {
+				t.Errorf("%s: expected a problem starting %q, got %q", name, tc.expected[i], err)
+			}
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package download
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	tempSuffix = ".tmp"
+	metaSuffix = ".meta"
+)
+
+// Config controls how often and how carefully a remote file is refreshed.
+type Config struct {
+	RefreshInterval time.Duration      `yaml:"refresh_interval"`
+	Timeout         time.Duration      `yaml:"timeout"`
+	MaxSize         int64              `yaml:"max_size"`
+	SHA256          string             `yaml:"sha256"`
+	Backoff         util.BackoffConfig `yaml:"backoff"`
+}
+
+// RegisterFlagsWithPrefix registers flags where every name is prefixed by prefix, prefix should not end with a period.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.DurationVar(&c.RefreshInterval, prefix+".refresh-interval", 24*time.Hour, "How old the downloaded file must be before checking the remote for a newer version")
+	f.DurationVar(&c.Timeout, prefix+".download-timeout", 5*time.Minute, "Timeout for a single download attempt")
+	f.Int64Var(&c.MaxSize, prefix+".max-download-size", 200<<20, "Maximum size in bytes of a downloaded file, 0 disables the limit")
+	f.StringVar(&c.SHA256, prefix+".sha256", "", "If set, the hex encoded SHA-256 the downloaded file must match")
+	c.Backoff.RegisterFlags(prefix+".download", f)
+}
+
+// Validator checks a downloaded file before it replaces the existing one.
+type Validator func(file string) error
+
+// metadata is persisted next to the downloaded file so conditional requests survive restarts.
+type metadata struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256,omitempty"`
+}
+
+// permanentError is returned for failures which retrying will not fix.
+type permanentError struct {
+	err error
+}
+
+func (p permanentError) Error() string {
+	return p.err.Error()
+}
+
+// Downloader keeps a local copy of a remote file up to date.
+type Downloader struct {
+	logger    log.Logger
+	config    Config
+	url       string
+	file      string
+	validate  Validator
+	client    *http.Client
+	userAgent string
+}
+
+// New creates a Downloader which keeps file in sync with url, every downloaded file is checked with validate before being used.
+func New(logger log.Logger, config Config, url, file string, validate Validator) *Downloader {
+	return &Downloader{
+		logger:    log.With(logger, "file", file),
+		config:    config,
+		url:       url,
+		file:      file,
+		validate:  validate,
+		client:    &http.Client{Timeout: config.Timeout},
+		userAgent: "adsb-loki",
+	}
+}
+
+// Update downloads a new copy of the file if the local copy is missing or older than the refresh interval
+// and the remote has changed. It returns true if the local file was replaced.
+func (d *Downloader) Update(ctx context.Context) (bool, error) {
+	fi, err := os.Stat(d.file)
+	if err == nil {
+		if time.Since(fi.ModTime()) < d.config.RefreshInterval {
+			return false, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to stat file, cannot update: %w", err)
+	}
+	exists := err == nil
+
+	meta := metadata{}
+	if exists {
+		meta = d.readMetadata()
+	}
+
+	level.Info(d.logger).Log("msg", "checking for new version of file", "url", d.url)
+
+	var (
+		updated bool
+		lastErr error
+	)
+	b := util.NewBackoff(ctx, d.config.Backoff)
+	for b.Ongoing() {
+		updated, lastErr = d.fetch(ctx, meta, exists)
+		if lastErr == nil {
+			return updated, nil
+		}
+		var perm permanentError
+		if errors.As(lastErr, &perm) {
+			return false, lastErr
+		}
+		level.Warn(d.logger).Log("msg", "download attempt failed, retrying", "attempt", b.NumRetries()+1, "err", lastErr)
+		b.Wait()
+	}
+	if lastErr == nil {
+		lastErr = b.Err()
+	}
+	return false, lastErr
+}
+
+func (d *Downloader) fetch(ctx context.Context, meta metadata, exists bool) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.url, nil)
+	if err != nil {
+		return false, permanentError{err}
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+	if exists {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && exists:
+		level.Info(d.logger).Log("msg", "remote file not modified")
+		// Bump the mtime so we don't ask again until the next refresh interval.
+		now := time.Now()
+		if err := os.Chtimes(d.file, now, now); err != nil {
+			level.Warn(d.logger).Log("msg", "failed to update modification time of file", "err", err)
+		}
+		return false, nil
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return false, fmt.Errorf("unexpected status downloading %s: %s", d.url, resp.Status)
+	default:
+		return false, permanentError{fmt.Errorf("unexpected status downloading %s: %s", d.url, resp.Status)}
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err == nil && (strings.HasPrefix(mt, "text/") || mt == "application/json") {
+			return false, permanentError{fmt.Errorf("refusing to save response with content type %s", mt)}
+		}
+	}
+
+	if d.config.MaxSize > 0 && resp.ContentLength > d.config.MaxSize {
+		return false, permanentError{fmt.Errorf("remote file is %d bytes which exceeds the limit of %d bytes", resp.ContentLength, d.config.MaxSize)}
+	}
+
+	tmp := d.file + tempSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return false, permanentError{fmt.Errorf("failed to create temp file: %w", err)}
+	}
+	defer os.Remove(tmp)
+
+	body := io.Reader(resp.Body)
+	if d.config.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, d.config.MaxSize+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), body)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to copy download to temp file: %w", err)
+	}
+	if d.config.MaxSize > 0 && n > d.config.MaxSize {
+		return false, permanentError{fmt.Errorf("download exceeded the limit of %d bytes", d.config.MaxSize)}
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	if d.config.SHA256 != "" && !strings.EqualFold(sum, d.config.SHA256) {
+		return false, permanentError{fmt.Errorf("checksum mismatch, expected %s got %s", d.config.SHA256, sum)}
+	}
+
+	if d.validate != nil {
+		if err := d.validate(tmp); err != nil {
+			// A truncated download is worth retrying.
+			return false, fmt.Errorf("downloaded file failed validation: %w", err)
+		}
+	}
+
+	if err := os.Rename(tmp, d.file); err != nil {
+		return false, permanentError{fmt.Errorf("failed to rename temp file to file: %w", err)}
+	}
+
+	d.writeMetadata(metadata{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		SHA256:       sum,
+	})
+	level.Info(d.logger).Log("msg", "new file downloaded and replaced existing file", "bytes", n, "sha256", sum)
+	return true, nil
+}
+
+func (d *Downloader) readMetadata() metadata {
+	meta := metadata{}
+	bts, err := ioutil.ReadFile(d.file + metaSuffix)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			level.Warn(d.logger).Log("msg", "failed to read download metadata", "err", err)
+		}
+		return meta
+	}
+	if err := json.Unmarshal(bts, &meta); err != nil {
+		level.Warn(d.logger).Log("msg", "failed to parse download metadata", "err", err)
+	}
+	return meta
+}
+
+func (d *Downloader) writeMetadata(meta metadata) {
+	bts, err := json.Marshal(meta)
+	if err != nil {
+		level.Warn(d.logger).Log("msg", "failed to marshal download metadata", "err", err)
+		return
+	}
+	if err := ioutil.WriteFile(d.file+metaSuffix, bts, 0644); err != nil {
+		level.Warn(d.logger).Log("msg", "failed to write download metadata", "err", err)
+	}
+}
+
+// ValidateGzip reads the whole file through a gzip reader which verifies the trailing checksum.
+func ValidateGzip(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
+
+// ValidateZip returns a Validator which checks every member of the zip file and that the required members are present.
+func ValidateZip(required ...string) Validator {
+	return func(file string) error {
+		r, err := zip.OpenReader(file)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		found := map[string]bool{}
+		for _, zf := range r.File {
+			rc, err := zf.Open()
+			if err != nil {
+				return err
+			}
+			// Reading to the end checks the CRC32 of the member.
+			_, err = io.Copy(ioutil.Discard, rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %w", zf.Name, err)
+			}
+			found[zf.Name] = true
+		}
+		for _, name := range required {
+			if !found[name] {
+				return fmt.Errorf("zip file is missing %s", name)
+			}
+		}
+		return nil
+	}
+}
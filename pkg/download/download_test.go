@@ -0,0 +1,184 @@
+package download
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util"
+	"github.com/go-kit/kit/log"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testConfig() Config {
+	return Config{
+		RefreshInterval: time.Hour,
+		Timeout:         5 * time.Second,
+		MaxSize:         1 << 20,
+		Backoff: util.BackoffConfig{
+			MinBackoff: time.Millisecond,
+			MaxBackoff: 2 * time.Millisecond,
+			MaxRetries: 3,
+		},
+	}
+}
+
+func Test_UpdateConditional(t *testing.T) {
+	payload := gzipBytes(t, "a;b;c\n")
+	var requests, notModified int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(payload)
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "aircraft.csv.gz")
+	cfg := testConfig()
+	d := New(log.NewNopLogger(), cfg, srv.URL, file, ValidateGzip)
+
+	updated, err := d.Update(context.Background())
+	if err != nil || !updated {
+		t.Fatalf("expected initial download, updated=%v err=%v", updated, err)
+	}
+	bts, err := ioutil.ReadFile(file)
+	if err != nil || !bytes.Equal(bts, payload) {
+		t.Fatalf("downloaded file does not match payload, err=%v", err)
+	}
+
+	// File is fresh, no request should be made.
+	if updated, err = d.Update(context.Background()); err != nil || updated {
+		t.Fatalf("expected no update for fresh file, updated=%v err=%v", updated, err)
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Fatalf("expected 1 request, got %d", requests)
+	}
+
+	// Age the file, the remote should be asked with If-None-Match and answer 304.
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if updated, err = d.Update(context.Background()); err != nil || updated {
+		t.Fatalf("expected not modified, updated=%v err=%v", updated, err)
+	}
+	if atomic.LoadInt32(&notModified) != 1 {
+		t.Fatalf("expected a conditional request")
+	}
+	fi, err := os.Stat(file)
+	if err != nil || time.Since(fi.ModTime()) > time.Minute {
+		t.Fatalf("expected modification time to be refreshed after 304")
+	}
+}
+
+func Test_UpdateRejectsBadDownloads(t *testing.T) {
+	payload := gzipBytes(t, "a;b;c\n")
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		cfg      func(*Config)
+		attempts int32
+	}{
+		{
+			name: "html error page",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.Write([]byte("<html>rate limited</html>"))
+			},
+			attempts: 1,
+		},
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+			attempts: 1,
+		},
+		{
+			name: "server error is retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			attempts: 3,
+		},
+		{
+			name: "truncated gzip is retried",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(payload[:len(payload)-4])
+			},
+			attempts: 3,
+		},
+		{
+			name: "checksum mismatch",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(payload)
+			},
+			cfg: func(c *Config) {
+				c.SHA256 = "0000000000000000000000000000000000000000000000000000000000000000"
+			},
+			attempts: 1,
+		},
+		{
+			name: "too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write(payload)
+			},
+			cfg: func(c *Config) {
+				c.MaxSize = 4
+			},
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				tt.handler(w, r)
+			}))
+			defer srv.Close()
+
+			cfg := testConfig()
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			file := filepath.Join(t.TempDir(), "aircraft.csv.gz")
+			d := New(log.NewNopLogger(), cfg, srv.URL, file, ValidateGzip)
+			updated, err := d.Update(context.Background())
+			if err == nil || updated {
+				t.Fatalf("expected failure, updated=%v err=%v", updated, err)
+			}
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Fatalf("bad download should not have been saved")
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, got)
+			}
+		})
+	}
+}
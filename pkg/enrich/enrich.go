@@ -0,0 +1,19 @@
+package enrich
+
+import (
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Enricher adds information to the aircraft in a report, it must not remove aircraft from the report.
+type Enricher interface {
+	Enrich(rpt *model.Report)
+}
+
+// Chain runs each Enricher in order so later ones can build on what earlier ones added.
+type Chain []Enricher
+
+func (c Chain) Enrich(rpt *model.Report) {
+	for _, e := range c {
+		e.Enrich(rpt)
+	}
+}
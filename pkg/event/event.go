@@ -0,0 +1,53 @@
+package event
+
+import (
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Types of event produced by the pipeline.
+const (
+	EmergencyStart = "emergency_start"
+	EmergencyEnd   = "emergency_end"
+	Watchlist      = "watchlist"
+	GeofenceEnter  = "geofence_enter"
+	GeofenceDwell  = "geofence_dwell"
+	GeofenceExit   = "geofence_exit"
+	Overflight     = "overflight"
+	// FeedStale and FeedResumed are about the receiver rather than an aircraft so have no hex.
+	FeedStale   = "feed_stale"
+	FeedResumed = "feed_resumed"
+)
+
+// Event is something notable which happened to an aircraft, it is logged to Loki as its own stream and
+// passed on to any alert webhooks.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Hex  string    `json:"hex"`
+	// Name of the rule, zone or code which produced the event.
+	Name     string            `json:"name,omitempty"`
+	Message  string            `json:"message"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Aircraft *model.Aircraft   `json:"aircraft,omitempty"`
+}
+
+// Sink receives events, Send must not block the caller for long.
+type Sink interface {
+	Send(e Event)
+}
+
+// Sinks sends every event to each Sink in turn.
+type Sinks []Sink
+
+func (s Sinks) Send(e Event) {
+	for _, sink := range s {
+		sink.Send(e)
+	}
+}
+
+// Detector looks for events in each report after it has been enriched.
+type Detector interface {
+	Process(rpt *model.Report) []Event
+}
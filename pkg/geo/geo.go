@@ -0,0 +1,80 @@
+package geo
+
+import (
+	"math"
+)
+
+const (
+	// EarthRadiusKm is the mean radius of the earth.
+	EarthRadiusKm = 6371.0088
+
+	KmPerNauticalMile = 1.852
+	MetersPerFoot     = 0.3048
+)
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func degrees(r float64) float64 {
+	return r * 180 / math.Pi
+}
+
+// Distance returns the great circle distance in km between two points.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	return EarthRadiusKm * angularDistance(lat1, lon1, lat2, lon2)
+}
+
+func angularDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	φ1, φ2 := radians(lat1), radians(lat2)
+	dφ := φ2 - φ1
+	dλ := radians(lon2 - lon1)
+	a := math.Sin(dφ/2)*math.Sin(dφ/2) + math.Cos(φ1)*math.Cos(φ2)*math.Sin(dλ/2)*math.Sin(dλ/2)
+	return 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Bearing returns the initial great circle bearing in degrees from the first point to the second.
+func Bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	φ1, φ2 := radians(lat1), radians(lat2)
+	dλ := radians(lon2 - lon1)
+	y := math.Sin(dλ) * math.Cos(φ2)
+	x := math.Cos(φ1)*math.Sin(φ2) - math.Sin(φ1)*math.Cos(φ2)*math.Cos(dλ)
+	return math.Mod(degrees(math.Atan2(y, x))+360, 360)
+}
+
+// Destination returns the point reached by travelling km along the great circle starting on bearing.
+func Destination(lat, lon, bearing, km float64) (float64, float64) {
+	φ1, λ1, θ := radians(lat), radians(lon), radians(bearing)
+	δ := km / EarthRadiusKm
+	φ2 := math.Asin(math.Sin(φ1)*math.Cos(δ) + math.Cos(φ1)*math.Sin(δ)*math.Cos(θ))
+	λ2 := λ1 + math.Atan2(math.Sin(θ)*math.Sin(δ)*math.Cos(φ1), math.Cos(δ)-math.Sin(φ1)*math.Sin(φ2))
+	return degrees(φ2), math.Mod(degrees(λ2)+540, 360) - 180
+}
+
+// CrossTrack returns how far in km the point is from the great circle path between start and end,
+// and how far along that path from start the closest point on the path is.
+// The cross track distance is negative when the point is left of the path.
+func CrossTrack(lat, lon, startLat, startLon, endLat, endLon float64) (crossKm, alongKm float64) {
+	δ13 := angularDistance(startLat, startLon, lat, lon)
+	θ13 := radians(Bearing(startLat, startLon, lat, lon))
+	θ12 := radians(Bearing(startLat, startLon, endLat, endLon))
+	δxt := math.Asin(math.Sin(δ13) * math.Sin(θ13-θ12))
+	δat := math.Acos(clamp(math.Cos(δ13)/math.Cos(δxt), -1, 1))
+	if math.Cos(θ13-θ12) < 0 {
+		δat = -δat
+	}
+	return δxt * EarthRadiusKm, δat * EarthRadiusKm
+}
+
+// AngleDiff returns the smallest difference in degrees between two headings, between 0 and 180.
+func AngleDiff(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
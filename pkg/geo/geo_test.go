@@ -0,0 +1,33 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func Test_GreatCircle(t *testing.T) {
+	// London Heathrow to New York JFK
+	lhrLat, lhrLon := 51.4706, -0.461941
+	jfkLat, jfkLon := 40.6398, -73.7789
+	if d := Distance(lhrLat, lhrLon, jfkLat, jfkLon); !near(d, 5540, 10) {
+		t.Errorf("unexpected distance %f", d)
+	}
+	if b := Bearing(lhrLat, lhrLon, jfkLat, jfkLon); !near(b, 288.1, 0.5) {
+		t.Errorf("unexpected bearing %f", b)
+	}
+	if lat, lon := Destination(lhrLat, lhrLon, 288.1, 5540); !near(lat, jfkLat, 0.5) || !near(lon, jfkLon, 0.5) {
+		t.Errorf("unexpected destination %f %f", lat, lon)
+	}
+	// A point on the equator 1 degree north of a path along the equator.
+	xt, at := CrossTrack(1, 5, 0, 0, 0, 10)
+	if !near(xt, -111.2, 0.5) || !near(at, 556, 1) {
+		t.Errorf("unexpected cross track %f along track %f", xt, at)
+	}
+	if d := AngleDiff(350, 10); d != 20 {
+		t.Errorf("unexpected angle difference %f", d)
+	}
+}
@@ -0,0 +1,286 @@
+package geofence
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/geo"
+)
+
+// Circle is a zone of RadiusKm around a point.
+type Circle struct {
+	Lat      float64 `yaml:"lat"`
+	Lon      float64 `yaml:"lon"`
+	RadiusKm float64 `yaml:"radius_km"`
+}
+
+// Zone is a polygon or a circle, optionally limited to the altitudes between FloorFt and CeilingFt.
+type Zone struct {
+	Name string `yaml:"name"`
+	// Polygon is a list of [lat, lon] points, the polygon is closed automatically.
+	Polygon   [][]float64 `yaml:"polygon"`
+	Circle    *Circle     `yaml:"circle"`
+	FloorFt   *float64    `yaml:"floor_ft"`
+	CeilingFt *float64    `yaml:"ceiling_ft"`
+	// Dwell is how long an aircraft must stay in the zone before a dwell event, it overrides the default.
+	Dwell *time.Duration `yaml:"dwell"`
+}
+
+// Config for the geofences, zones can be listed here or loaded from a GeoJSON file.
+//
+//  geofences:
+//    zones:
+//      - name: village
+//        circle: {lat: 51.5, lon: -0.5, radius_km: 2}
+//        ceiling_ft: 3000
+type Config struct {
+	Zones       []Zone        `yaml:"zones"`
+	GeoJSONFile string        `yaml:"geojson_file"`
+	Dwell       time.Duration `yaml:"dwell"`
+	ExitAfter   time.Duration `yaml:"exit_after"`
+	Annotate    bool          `yaml:"annotate"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.GeoJSONFile, "geofences.geojson-file", "", "GeoJSON file of Polygon, MultiPolygon or Point (with a radius_km property) features to use as zones, the name, floor_ft, ceiling_ft and dwell properties are used if present")
+	f.DurationVar(&c.Dwell, "geofences.dwell", 5*time.Minute, "How long an aircraft must stay in a zone before a dwell event, 0 disables dwell events")
+	f.DurationVar(&c.ExitAfter, "geofences.exit-after", time.Minute, "How long an aircraft in a zone can go without a position before it is considered to have left")
+	f.BoolVar(&c.Annotate, "geofences.annotate", false, "Add the names of the zones each aircraft is in to its log line")
+}
+
+type point struct {
+	lat, lon float64
+}
+
+// ring is a closed polygon ring, the last point is not repeated.
+type ring []point
+
+type zone struct {
+	name string
+	// polygons are each an outer ring followed by any holes.
+	polygons       [][]ring
+	circle         *Circle
+	floor, ceiling *float64
+	dwell          time.Duration
+}
+
+func newZone(z Zone, defaultDwell time.Duration) (*zone, error) {
+	if z.Name == "" {
+		return nil, fmt.Errorf("zone must have a name")
+	}
+	cz := &zone{name: z.Name, circle: z.Circle, floor: z.FloorFt, ceiling: z.CeilingFt, dwell: defaultDwell}
+	if z.Dwell != nil {
+		cz.dwell = *z.Dwell
+	}
+	switch {
+	case z.Circle != nil && z.Polygon != nil:
+		return nil, fmt.Errorf("zone %s must be a circle or a polygon, not both", z.Name)
+	case z.Circle != nil:
+		if z.Circle.RadiusKm <= 0 {
+			return nil, fmt.Errorf("zone %s must have a positive radius", z.Name)
+		}
+	case z.Polygon != nil:
+		r := ring{}
+		for _, p := range z.Polygon {
+			if len(p) != 2 {
+				return nil, fmt.Errorf("zone %s polygon points must be [lat, lon]", z.Name)
+			}
+			r = append(r, point{lat: p[0], lon: p[1]})
+		}
+		r = r.trimmed()
+		if len(r) < 3 {
+			return nil, fmt.Errorf("zone %s polygon must have at least 3 points", z.Name)
+		}
+		cz.polygons = [][]ring{{r}}
+	default:
+		return nil, fmt.Errorf("zone %s must have a circle or a polygon", z.Name)
+	}
+	if err := cz.checkAltitudes(); err != nil {
+		return nil, fmt.Errorf("zone %s %s", z.Name, err)
+	}
+	return cz, nil
+}
+
+// checkAltitudes rejects a floor at or above the ceiling, the zone could never contain anything.
+func (z *zone) checkAltitudes() error {
+	if z.floor != nil && z.ceiling != nil && *z.floor >= *z.ceiling {
+		return fmt.Errorf("floor must be below its ceiling")
+	}
+	return nil
+}
+
+// checkPosition rejects positions outside the range of latitudes and longitudes.
+func checkPosition(lat, lon float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %v must be between -90 and 90", lat)
+	}
+	if lon < -180 || lon > 180 {
+		return fmt.Errorf("longitude %v must be between -180 and 180", lon)
+	}
+	return nil
+}
+
+// trimmed removes the closing point if it repeats the first.
+func (r ring) trimmed() ring {
+	if len(r) > 1 && r[0] == r[len(r)-1] {
+		return r[:len(r)-1]
+	}
+	return r
+}
+
+// contains uses ray casting on lat/lon treated as a plane, which is accurate enough for zones of a few hundred km.
+func (r ring) contains(lat, lon float64) bool {
+	in := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		a, b := r[i], r[j]
+		if (a.lat > lat) != (b.lat > lat) && lon < (b.lon-a.lon)*(lat-a.lat)/(b.lat-a.lat)+a.lon {
+			in = !in
+		}
+	}
+	return in
+}
+
+// contains reports whether the position is inside the zone, if the zone has altitude limits an unknown altitude is outside.
+func (z *zone) contains(lat, lon, alt float64, altOK bool) bool {
+	if z.floor != nil || z.ceiling != nil {
+		if !altOK || z.floor != nil && alt < *z.floor || z.ceiling != nil && alt > *z.ceiling {
+			return false
+		}
+	}
+	if z.circle != nil {
+		return geo.Distance(lat, lon, z.circle.Lat, z.circle.Lon) <= z.circle.RadiusKm
+	}
+	for _, p := range z.polygons {
+		if !p[0].contains(lat, lon) {
+			continue
+		}
+		inHole := false
+		for _, h := range p[1:] {
+			if h.contains(lat, lon) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+type featureCollection struct {
+	Features []struct {
+		Properties struct {
+			Name      string   `json:"name"`
+			FloorFt   *float64 `json:"floor_ft"`
+			CeilingFt *float64 `json:"ceiling_ft"`
+			RadiusKm  float64  `json:"radius_km"`
+			Dwell     string   `json:"dwell"`
+		} `json:"properties"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	} `json:"features"`
+}
+
+// toRing converts GeoJSON [lon, lat] positions.
+func toRing(coords [][]float64) (ring, error) {
+	r := make(ring, 0, len(coords))
+	for _, c := range coords {
+		if len(c) < 2 {
+			return nil, fmt.Errorf("invalid position %v", c)
+		}
+		if err := checkPosition(c[1], c[0]); err != nil {
+			return nil, err
+		}
+		r = append(r, point{lat: c[1], lon: c[0]})
+	}
+	r = r.trimmed()
+	if len(r) < 3 {
+		return nil, fmt.Errorf("polygon ring must have at least 3 points")
+	}
+	return r, nil
+}
+
+func toPolygon(rings [][][]float64) ([]ring, error) {
+	if len(rings) == 0 {
+		return nil, fmt.Errorf("polygon has no rings")
+	}
+	p := make([]ring, 0, len(rings))
+	for _, c := range rings {
+		r, err := toRing(c)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, r)
+	}
+	return p, nil
+}
+
+func loadGeoJSON(file string, defaultDwell time.Duration) ([]*zone, error) {
+	bts, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	fc := featureCollection{}
+	if err := json.Unmarshal(bts, &fc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", file, err)
+	}
+	var zones []*zone
+	for i, f := range fc.Features {
+		props := f.Properties
+		z := &zone{name: props.Name, floor: props.FloorFt, ceiling: props.CeilingFt, dwell: defaultDwell}
+		if z.name == "" {
+			z.name = fmt.Sprintf("zone-%d", i)
+		}
+		if props.Dwell != "" {
+			if z.dwell, err = time.ParseDuration(props.Dwell); err != nil {
+				return nil, fmt.Errorf("zone %s has an invalid dwell: %s", z.name, err)
+			}
+		}
+		switch f.Geometry.Type {
+		case "Polygon":
+			var coords [][][]float64
+			if err = json.Unmarshal(f.Geometry.Coordinates, &coords); err == nil {
+				var p []ring
+				if p, err = toPolygon(coords); err == nil {
+					z.polygons = [][]ring{p}
+				}
+			}
+		case "MultiPolygon":
+			var coords [][][][]float64
+			if err = json.Unmarshal(f.Geometry.Coordinates, &coords); err == nil {
+				for _, c := range coords {
+					var p []ring
+					if p, err = toPolygon(c); err != nil {
+						break
+					}
+					z.polygons = append(z.polygons, p)
+				}
+			}
+		case "Point":
+			var c []float64
+			if err = json.Unmarshal(f.Geometry.Coordinates, &c); err == nil {
+				if len(c) < 2 || props.RadiusKm <= 0 {
+					err = fmt.Errorf("point zones need a position and a positive radius_km property")
+				} else if err = checkPosition(c[1], c[0]); err == nil {
+					z.circle = &Circle{Lat: c[1], Lon: c[0], RadiusKm: props.RadiusKm}
+				}
+			}
+		default:
+			err = fmt.Errorf("unsupported geometry type %q", f.Geometry.Type)
+		}
+		if err == nil {
+			err = z.checkAltitudes()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("zone %s in %s: %s", z.name, file, err)
+		}
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
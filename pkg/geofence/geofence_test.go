@@ -0,0 +1,204 @@
+package geofence
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+const testGeoJSON = `{
+  "type": "FeatureCollection",
+  "features": [
+    {
+      "type": "Feature",
+      "properties": {"name": "square-with-hole", "ceiling_ft": 3000},
+      "geometry": {"type": "Polygon", "coordinates": [
+        [[0, 50], [1, 50], [1, 51], [0, 51], [0, 50]],
+        [[0.4, 50.4], [0.6, 50.4], [0.6, 50.6], [0.4, 50.6], [0.4, 50.4]]
+      ]}
+    },
+    {
+      "type": "Feature",
+      "properties": {"name": "airfield", "radius_km": 5, "dwell": "1m"},
+      "geometry": {"type": "Point", "coordinates": [2, 50]}
+    }
+  ]
+}`
+
+func float64P(v float64) *float64 {
+	return &v
+}
+
+func at(hex string, lat, lon, alt float64) model.Aircraft {
+	return model.Aircraft{Hex: hex, Lat: float64P(lat), Lon: float64P(lon), BarometerAltitude: alt}
+}
+
+func Test_Contains(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "zones.geojson")
+	if err := ioutil.WriteFile(file, []byte(testGeoJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := New(Config{
+		GeoJSONFile: file,
+		Zones: []Zone{
+			{Name: "triangle", Polygon: [][]float64{{10, 10}, {10, 12}, {12, 11}}, FloorFt: float64P(1000)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zones := map[string]*zone{}
+	for _, z := range tr.zones {
+		zones[z.name] = z
+	}
+	tests := []struct {
+		zone          string
+		lat, lon, alt float64
+		altOK         bool
+		expected      bool
+	}{
+		{"square-with-hole", 50.1, 0.1, 2000, true, true},
+		{"square-with-hole", 50.1, 0.1, 3500, true, false},
+		{"square-with-hole", 50.1, 0.1, 0, false, false},
+		{"square-with-hole", 50.5, 0.5, 2000, true, false},
+		{"square-with-hole", 51.1, 0.5, 2000, true, false},
+		{"airfield", 50.01, 2.01, 0, false, true},
+		{"airfield", 50.1, 2, 0, false, false},
+		{"triangle", 10.5, 11, 5000, true, true},
+		{"triangle", 10.5, 11, 500, true, false},
+		{"triangle", 11.9, 10.1, 5000, true, false},
+	}
+	for _, tt := range tests {
+		if got := zones[tt.zone].contains(tt.lat, tt.lon, tt.alt, tt.altOK); got != tt.expected {
+			t.Errorf("%s %v,%v at %v: expected %v", tt.zone, tt.lat, tt.lon, tt.alt, tt.expected)
+		}
+	}
+
+	for _, z := range []Zone{
+		{Name: "none"},
+		{Polygon: [][]float64{{0, 0}, {0, 1}, {1, 1}}},
+		{Name: "line", Polygon: [][]float64{{0, 0}, {0, 1}, {0, 0}}},
+		{Name: "upside-down", Circle: &Circle{RadiusKm: 1}, FloorFt: float64P(1000), CeilingFt: float64P(500)},
+	} {
+		if _, err := New(Config{Zones: []Zone{z}}); err == nil {
+			t.Errorf("expected invalid zone %+v to be rejected", z)
+		}
+	}
+}
+
+func Test_InvalidGeoJSON(t *testing.T) {
+	for name, feature := range map[string]string{
+		"upside-down": `{"properties": {"name": "upside-down", "radius_km": 5, "floor_ft": 5000, "ceiling_ft": 1000}, "geometry": {"type": "Point", "coordinates": [2, 50]}}`,
+		"point":       `{"properties": {"name": "point", "radius_km": 5}, "geometry": {"type": "Point", "coordinates": [2, 95]}}`,
+		"polygon":     `{"properties": {"name": "polygon"}, "geometry": {"type": "Polygon", "coordinates": [[[0, 50], [181, 50], [1, 51]]]}}`,
+	} {
+		file := filepath.Join(t.TempDir(), "zones.geojson")
+		if err := ioutil.WriteFile(file, []byte(`{"type": "FeatureCollection", "features": [`+feature+`]}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(Config{GeoJSONFile: file}); err == nil {
+			t.Errorf("%s: expected the zone to be rejected", name)
+		}
+	}
+}
+
+func Test_Tracker(t *testing.T) {
+	tr, err := New(Config{
+		Dwell:     time.Minute,
+		ExitAfter: time.Minute,
+		Annotate:  true,
+		Zones: []Zone{
+			{Name: "village", Circle: &Circle{Lat: 51, Lon: 0, RadiusKm: 10}, CeilingFt: float64P(3000)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := 1600000000.0
+	process := func(aircraft ...model.Aircraft) []event.Event {
+		rpt := &model.Report{Now: now, Aircraft: aircraft}
+		now += 30
+		return tr.Process(rpt)
+	}
+	types := func(events []event.Event) []string {
+		var l []string
+		for _, e := range events {
+			l = append(l, e.Type+" "+e.Hex)
+		}
+		return l
+	}
+	expect := func(events []event.Event, expected ...string) {
+		t.Helper()
+		if got := types(events); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected %v got %v", expected, got)
+		}
+	}
+
+	// a descends through the ceiling inside the zone, b passes over it too high.
+	expect(process(at("a", 51, -0.5, 4000), at("b", 51, -0.5, 5000)))
+	inside := at("a", 51, -0.1, 2500)
+	events := process(inside, at("b", 51, 0, 5000))
+	expect(events, "geofence_enter a")
+	if events[0].Fields["entry_lat"] != "51" || events[0].Fields["entry_lon"] != "-0.1" {
+		t.Fatalf("unexpected entry position %+v", events[0].Fields)
+	}
+	rpt := &model.Report{Now: now, Aircraft: []model.Aircraft{at("a", 51, 0, 1800)}}
+	now += 30
+	expect(tr.Process(rpt))
+	if !reflect.DeepEqual(rpt.Aircraft[0].Zones, []string{"village"}) {
+		t.Fatalf("expected aircraft to be annotated with its zone, got %v", rpt.Aircraft[0].Zones)
+	}
+	expect(process(at("a", 51, 0.05, 1900)), "geofence_dwell a")
+	events = process(at("a", 51, 0.5, 2000))
+	expect(events, "geofence_exit a")
+	f := events[0].Fields
+	if f["exit_lon"] != "0.5" || f["min_altitude_ft"] != "1800" || f["duration"] != "1m30s" || f["reason"] != "left" {
+		t.Fatalf("unexpected exit fields %+v", f)
+	}
+
+	// Losing an aircraft in the zone ends its visit.
+	expect(process(at("c", 51, 0, 1000)), "geofence_enter c")
+	expect(process(model.Aircraft{Hex: "c"}))
+	expect(process())
+	events = process()
+	expect(events, "geofence_exit c")
+	if events[0].Fields["reason"] != "lost" || events[0].Aircraft == nil || events[0].Aircraft.Hex != "c" {
+		t.Fatalf("unexpected lost exit %+v", events[0])
+	}
+}
+
+func Test_TrackerUpdate(t *testing.T) {
+	village := Zone{Name: "village", Circle: &Circle{Lat: 51, Lon: 0, RadiusKm: 10}}
+	town := Zone{Name: "town", Circle: &Circle{Lat: 51, Lon: 0, RadiusKm: 20}}
+	tr, err := New(Config{ExitAfter: time.Minute, Zones: []Zone{village, town}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := tr.Process(&model.Report{Now: 1600000000, Aircraft: []model.Aircraft{at("a", 51, 0, 1000)}}); len(events) != 2 {
+		t.Fatalf("expected to enter both zones, got %+v", events)
+	}
+
+	// village is kept so there's no new enter event, town is dropped without an exit and city is entered.
+	city := Zone{Name: "city", Circle: &Circle{Lat: 51, Lon: 0, RadiusKm: 30}}
+	next, err := New(Config{ExitAfter: time.Minute, Annotate: true, Zones: []Zone{village, city}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Update(next)
+	rpt := &model.Report{Now: 1600000030, Aircraft: []model.Aircraft{at("a", 51, 0, 1000)}}
+	events := tr.Process(rpt)
+	if len(events) != 1 || events[0].Type != event.GeofenceEnter || events[0].Name != "city" {
+		t.Fatalf("expected only an enter event for the new zone, got %+v", events)
+	}
+	if !reflect.DeepEqual(rpt.Aircraft[0].Zones, []string{"village", "city"}) {
+		t.Errorf("expected the updated annotate setting, got %v", rpt.Aircraft[0].Zones)
+	}
+	if _, ok := tr.visits["a"]["town"]; ok {
+		t.Error("expected the visit to the removed zone to be dropped")
+	}
+}
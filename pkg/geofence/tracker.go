@@ -0,0 +1,182 @@
+package geofence
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// visit is an aircraft's time inside one zone.
+type visit struct {
+	entered            time.Time
+	entryLat, entryLon float64
+	lastInside         time.Time
+	lastLat, lastLon   float64
+	minAlt             *float64
+	dwelled            bool
+	aircraft           model.Aircraft
+}
+
+// Tracker follows aircraft in and out of the zones.
+type Tracker struct {
+	zones     []*zone
+	exitAfter time.Duration
+	annotate  bool
+	// visits is keyed on hex then zone name.
+	visits map[string]map[string]*visit
+}
+
+func New(config Config) (*Tracker, error) {
+	t := &Tracker{
+		exitAfter: config.ExitAfter,
+		annotate:  config.Annotate,
+		visits:    map[string]map[string]*visit{},
+	}
+	for _, z := range config.Zones {
+		cz, err := newZone(z, config.Dwell)
+		if err != nil {
+			return nil, err
+		}
+		t.zones = append(t.zones, cz)
+	}
+	if config.GeoJSONFile != "" {
+		zones, err := loadGeoJSON(config.GeoJSONFile, config.Dwell)
+		if err != nil {
+			return nil, err
+		}
+		t.zones = append(t.zones, zones...)
+	}
+	names := map[string]bool{}
+	for _, z := range t.zones {
+		if names[z.name] {
+			return nil, fmt.Errorf("duplicate zone name %s", z.name)
+		}
+		names[z.name] = true
+	}
+	return t, nil
+}
+
+// Zones returns the number of zones being tracked.
+func (t *Tracker) Zones() int {
+	return len(t.zones)
+}
+
+// Update replaces the zones and settings with those of next, which must not be used afterwards.
+// Aircraft stay in zones which are kept without a new enter event, visits to zones which were removed are dropped without an exit event.
+func (t *Tracker) Update(next *Tracker) {
+	names := map[string]bool{}
+	for _, z := range next.zones {
+		names[z.name] = true
+	}
+	for hex, visits := range t.visits {
+		for z := range visits {
+			if !names[z] {
+				delete(visits, z)
+			}
+		}
+		if len(visits) == 0 {
+			delete(t.visits, hex)
+		}
+	}
+	t.zones = next.zones
+	t.exitAfter = next.exitAfter
+	t.annotate = next.annotate
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func (t *Tracker) event(typ string, now time.Time, hex string, z string, v *visit, ac *model.Aircraft) event.Event {
+	fields := map[string]string{
+		"zone":       z,
+		"entry_time": v.entered.UTC().Format(time.RFC3339),
+		"entry_lat":  formatFloat(v.entryLat),
+		"entry_lon":  formatFloat(v.entryLon),
+	}
+	if v.minAlt != nil {
+		fields["min_altitude_ft"] = formatFloat(*v.minAlt)
+	}
+	return event.Event{
+		Type:     typ,
+		Time:     now,
+		Hex:      hex,
+		Name:     z,
+		Fields:   fields,
+		Aircraft: ac,
+	}
+}
+
+func (t *Tracker) exit(now time.Time, hex string, z string, v *visit, lat, lon float64, reason string) event.Event {
+	e := t.event(event.GeofenceExit, now, hex, z, v, &v.aircraft)
+	e.Message = fmt.Sprintf("left %s", z)
+	e.Fields["exit_lat"] = formatFloat(lat)
+	e.Fields["exit_lon"] = formatFloat(lon)
+	e.Fields["duration"] = now.Sub(v.entered).Truncate(time.Second).String()
+	e.Fields["reason"] = reason
+	delete(t.visits[hex], z)
+	if len(t.visits[hex]) == 0 {
+		delete(t.visits, hex)
+	}
+	return e
+}
+
+// Process returns enter, dwell and exit events, and adds the zone names to the aircraft when annotating.
+// Aircraft without a position keep their current zones until they have been gone for the exit after period.
+func (t *Tracker) Process(rpt *model.Report) []event.Event {
+	now := rpt.Time()
+	var events []event.Event
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		if ac.Lat == nil || ac.Lon == nil {
+			continue
+		}
+		lat, lon := *ac.Lat, *ac.Lon
+		alt, altOK := ac.Altitude()
+		for _, z := range t.zones {
+			v := t.visits[ac.Hex][z.name]
+			if !z.contains(lat, lon, alt, altOK) {
+				if v != nil {
+					v.aircraft = *ac
+					events = append(events, t.exit(now, ac.Hex, z.name, v, lat, lon, "left"))
+				}
+				continue
+			}
+			if t.annotate {
+				ac.Zones = append(ac.Zones, z.name)
+			}
+			if v == nil {
+				v = &visit{entered: now, entryLat: lat, entryLon: lon}
+				if t.visits[ac.Hex] == nil {
+					t.visits[ac.Hex] = map[string]*visit{}
+				}
+				t.visits[ac.Hex][z.name] = v
+				e := t.event(event.GeofenceEnter, now, ac.Hex, z.name, v, ac)
+				e.Message = fmt.Sprintf("entered %s", z.name)
+				events = append(events, e)
+			}
+			v.lastInside, v.lastLat, v.lastLon, v.aircraft = now, lat, lon, *ac
+			if altOK && (v.minAlt == nil || alt < *v.minAlt) {
+				a := alt
+				v.minAlt = &a
+			}
+			if !v.dwelled && z.dwell > 0 && now.Sub(v.entered) >= z.dwell {
+				v.dwelled = true
+				e := t.event(event.GeofenceDwell, now, ac.Hex, z.name, v, ac)
+				e.Message = fmt.Sprintf("in %s for %s", z.name, now.Sub(v.entered).Truncate(time.Second))
+				events = append(events, e)
+			}
+		}
+	}
+	for hex, visits := range t.visits {
+		for z, v := range visits {
+			if now.Sub(v.lastInside) > t.exitAfter {
+				events = append(events, t.exit(now, hex, z, v, v.lastLat, v.lastLon, "lost"))
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,173 @@
+package history
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/lokiquery"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+	"github.com/slim-bean/adsb-loki/pkg/track"
+)
+
+// Query selects aircraft by exactly one of hex, registration or callsign.
+type Query struct {
+	Hex          string
+	Registration string
+	Callsign     string
+}
+
+// LogQL returns the Loki query for the aircraft's lines, callsigns aren't labels so they are found with a
+// line filter and checked again once the lines are decoded.
+func (q Query) LogQL() (string, error) {
+	set := 0
+	for _, v := range []string{q.Hex, q.Registration, q.Callsign} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", fmt.Errorf("exactly one of hex, registration or callsign must be set")
+	}
+	switch {
+	case q.Hex != "":
+		return fmt.Sprintf(`{job="adsb", hex=%q}`, strings.ToLower(q.Hex)), nil
+	case q.Registration != "":
+		return fmt.Sprintf(`{job="adsb", registration=%q}`, strings.ToUpper(q.Registration)), nil
+	default:
+		return fmt.Sprintf(`{job="adsb"} |= %q`, fmt.Sprintf(`"flight":%q`, strings.ToUpper(q.Callsign))), nil
+	}
+}
+
+func (q Query) matches(ac *model.Aircraft) bool {
+	if q.Callsign == "" {
+		return true
+	}
+	return ac.Flight != nil && strings.EqualFold(strings.TrimSpace(*ac.Flight), q.Callsign)
+}
+
+// Fetch queries Loki for the aircraft's lines in [start, end) and splits them into sessions, sorted by start time.
+func Fetch(ctx context.Context, c *lokiquery.Client, q Query, start, end time.Time, gap time.Duration) ([]track.Track, error) {
+	logql, err := q.LogQL()
+	if err != nil {
+		return nil, err
+	}
+	builders := map[string]*track.Builder{}
+	err = c.QueryRange(ctx, logql, start, end, func(e lokiquery.Entry) error {
+		ac := model.Aircraft{}
+		if err := json.Unmarshal([]byte(e.Line), &ac); err != nil {
+			return fmt.Errorf("invalid line at %s: %s", e.Time, err)
+		}
+		if !q.matches(&ac) {
+			return nil
+		}
+		b := builders[ac.Hex]
+		if b == nil {
+			b = track.NewBuilder(ac.Hex)
+			builders[ac.Hex] = b
+		}
+		b.Add(e.Time, &ac)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var tracks []track.Track
+	for _, b := range builders {
+		tracks = append(tracks, b.Sessions(gap)...)
+	}
+	sort.Slice(tracks, func(i, j int) bool {
+		if !tracks[i].Start().Equal(tracks[j].Start()) {
+			return tracks[i].Start().Before(tracks[j].Start())
+		}
+		return tracks[i].Hex < tracks[j].Hex
+	})
+	return tracks, nil
+}
+
+type summary struct {
+	minAlt, maxAlt, maxSpeed *float64
+}
+
+func summarise(t track.Track) summary {
+	s := summary{}
+	for _, p := range t.Points {
+		if a := p.AltitudeFt; a != nil {
+			if s.minAlt == nil || *a < *s.minAlt {
+				s.minAlt = a
+			}
+			if s.maxAlt == nil || *a > *s.maxAlt {
+				s.maxAlt = a
+			}
+		}
+		if v := p.SpeedKt; v != nil && (s.maxSpeed == nil || *v > *s.maxSpeed) {
+			s.maxSpeed = v
+		}
+	}
+	return s
+}
+
+func formatOptional(v *float64) string {
+	if v == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(math.Round(*v), 'f', -1, 64)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// WriteTable prints a line for each session.
+func WriteTable(w io.Writer, tracks []track.Track) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SESSION\tHEX\tFLIGHT\tREGISTRATION\tSTART\tDURATION\tPOSITIONS\tMIN ALT FT\tMAX ALT FT\tMAX SPEED KT")
+	for i, t := range tracks {
+		s := summarise(t)
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			i+1, t.Hex, orDash(t.Flight), orDash(t.Registration),
+			t.Start().Local().Format("2006-01-02 15:04:05"), t.End().Sub(t.Start()).Truncate(time.Second),
+			len(t.Points), formatOptional(s.minAlt), formatOptional(s.maxAlt), formatOptional(s.maxSpeed))
+	}
+	return tw.Flush()
+}
+
+func formatFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
+
+// WriteCSV writes a row for every position, numbering the sessions like WriteTable.
+func WriteCSV(w io.Writer, tracks []track.Track) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"session", "hex", "flight", "registration", "time", "lat", "lon", "altitude_ft", "speed_kt", "track"}); err != nil {
+		return err
+	}
+	for i, t := range tracks {
+		for _, p := range t.Points {
+			lat, lon := p.Lat, p.Lon
+			err := cw.Write([]string{
+				strconv.Itoa(i + 1), t.Hex, t.Flight, t.Registration, p.Time.UTC().Format(time.RFC3339Nano),
+				formatFloat(&lat), formatFloat(&lon), formatFloat(p.AltitudeFt), formatFloat(p.SpeedKt), formatFloat(p.Track),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
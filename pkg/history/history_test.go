@@ -0,0 +1,170 @@
+package history
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/lokiquery"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+type stream struct {
+	labels map[string]string
+	values [][2]string
+}
+
+var (
+	matcherRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+	filterRe  = regexp.MustCompile(`\|= "((?:[^"\\]|\\.)*)"`)
+)
+
+// fakeLoki understands just enough LogQL for the queries made by Query: equality matchers and one line filter.
+func fakeLoki(t *testing.T, streams []stream) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		query := q.Get("query")
+		selector := query
+		filter := ""
+		if m := filterRe.FindStringSubmatch(query); m != nil {
+			selector = query[:strings.Index(query, "|=")]
+			f, err := strconv.Unquote(`"` + m[1] + `"`)
+			if err != nil {
+				t.Errorf("invalid line filter in %s", query)
+			}
+			filter = f
+		}
+		start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+		result := []map[string]interface{}{}
+		for _, s := range streams {
+			match := true
+			for _, m := range matcherRe.FindAllStringSubmatch(selector, -1) {
+				if s.labels[m[1]] != m[2] {
+					match = false
+				}
+			}
+			if !match {
+				continue
+			}
+			values := [][2]string{}
+			for _, v := range s.values {
+				ts, _ := strconv.ParseInt(v[0], 10, 64)
+				if ts >= start && ts < end && strings.Contains(v[1], filter) {
+					values = append(values, v)
+				}
+			}
+			if len(values) > 0 {
+				result = append(result, map[string]interface{}{"stream": s.labels, "values": values})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]interface{}{"resultType": "streams", "result": result},
+		})
+	}))
+}
+
+func float64P(v float64) *float64 {
+	return &v
+}
+
+func stringP(v string) *string {
+	return &v
+}
+
+// flight returns a stream of positions every 10 seconds starting at start.
+func flight(t *testing.T, hex, reg, callsign string, start time.Time, n int) stream {
+	s := stream{labels: map[string]string{"job": "adsb", "hex": hex, "registration": reg}}
+	for i := 0; i < n; i++ {
+		ac := model.Aircraft{
+			Hex:               hex,
+			Flight:            stringP(callsign),
+			Lat:               float64P(51 + float64(i)*0.01),
+			Lon:               float64P(0),
+			GroundSpeed:       float64P(100 + float64(i)),
+			BarometerAltitude: 1000 + float64(i)*500,
+			Details:           model.Details{Registration: stringP(reg)},
+		}
+		bts, err := json.Marshal(ac)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := start.Add(time.Duration(i) * 10 * time.Second).UnixNano()
+		s.values = append(s.values, [2]string{strconv.FormatInt(ts, 10), string(bts)})
+	}
+	return s
+}
+
+func Test_Fetch(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	morning := flight(t, "a00001", "N1", "ABC123", start, 5)
+	evening := flight(t, "a00001", "N1", "ABC456", start.Add(8*time.Hour), 3)
+	// Same stream labels, both flights are in one stream.
+	morning.values = append(morning.values, evening.values...)
+	other := flight(t, "a00002", "N2", "ABC123", start.Add(2*time.Hour), 4)
+	srv := fakeLoki(t, []stream{morning, other})
+	defer srv.Close()
+	c := lokiquery.New(lokiquery.Config{URL: srv.URL, Timeout: time.Second})
+
+	fetch := func(q Query) int {
+		t.Helper()
+		tracks, err := Fetch(context.Background(), c, q, start.Add(-time.Hour), start.Add(24*time.Hour), 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(tracks)
+	}
+	if n := fetch(Query{Hex: "A00001"}); n != 2 {
+		t.Fatalf("expected 2 sessions by hex got %d", n)
+	}
+	if n := fetch(Query{Registration: "n2"}); n != 1 {
+		t.Fatalf("expected 1 session by registration got %d", n)
+	}
+	tracks, err := Fetch(context.Background(), c, Query{Callsign: "abc123"}, start.Add(-time.Hour), start.Add(24*time.Hour), 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2 || tracks[0].Hex != "a00001" || tracks[1].Hex != "a00002" || len(tracks[0].Points) != 5 {
+		t.Fatalf("expected both aircraft flying ABC123 in time order, got %+v", tracks)
+	}
+	// A callsign which is a prefix of another mustn't match it.
+	if n := fetch(Query{Callsign: "ABC12"}); n != 0 {
+		t.Fatalf("expected no sessions for a partial callsign got %d", n)
+	}
+	if _, err := Fetch(context.Background(), c, Query{Hex: "a00001", Callsign: "ABC123"}, start, start, time.Minute); err == nil {
+		t.Fatal("expected a query with more than one selector to be rejected")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteTable(buf, tracks); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "SESSION") {
+		t.Fatalf("unexpected table\n%s", buf)
+	}
+	if f := strings.Fields(lines[1]); f[1] != "a00001" || f[2] != "ABC123" || f[6] != "40s" || f[7] != "5" || f[8] != "1000" || f[9] != "3000" || f[10] != "104" {
+		t.Fatalf("unexpected table row %q", lines[1])
+	}
+
+	buf.Reset()
+	if err := WriteCSV(buf, tracks); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 10 || rows[1][0] != "1" || rows[1][4] != "2020-09-13T12:26:40Z" || rows[1][7] != "1000" || rows[9][0] != "2" {
+		t.Fatalf("unexpected csv %v", rows)
+	}
+}
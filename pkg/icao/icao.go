@@ -0,0 +1,175 @@
+package icao
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+const (
+	// US N-numbers are assigned algorithmically from A00001 to ADF7C7.
+	nNumberStart = 0xA00001
+	nNumberCount = 915399
+
+	// Letters used in N-number suffixes, I and O are never used.
+	nLetters = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	// Sizes of the ranges of addresses below each digit of an N-number.
+	nSuffixSize  = 1 + len(nLetters)*(1+len(nLetters))
+	nBucket4Size = 1 + len(nLetters) + 10
+	nBucket3Size = 10*nBucket4Size + nSuffixSize
+	nBucket2Size = 10*nBucket3Size + nSuffixSize
+	nBucket1Size = 10*nBucket2Size + nSuffixSize
+
+	alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// strideMapping describes a state which assigns a range of addresses to a range of registrations
+// made of a prefix and three letters in alphabetical order.
+type strideMapping struct {
+	start  uint32
+	prefix string
+}
+
+var strideMappings = []strideMapping{
+	{0xC00001, "C-F"},
+	{0xC044A9, "C-G"},
+}
+
+// Info is what can be derived from the address alone.
+type Info struct {
+	Country       string
+	CountryCode   string
+	MilitaryBlock bool
+	// Registration is empty unless it can be computed from the address.
+	Registration string
+}
+
+// Parse converts a hex string into a 24-bit address, addresses which are not ICAO addresses (e.g. ~ prefixed TIS-B) return false.
+func Parse(hex string) (uint32, bool) {
+	if len(hex) != 6 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(v), true
+}
+
+// Lookup returns everything known about an address from its allocation.
+func Lookup(addr uint32) Info {
+	info := Info{}
+	if b := find(allocations, addr); b != nil {
+		info.Country, info.CountryCode = b.country, b.code
+	}
+	info.MilitaryBlock = find(militaryBlocks, addr) != nil
+	info.Registration = Registration(addr)
+	return info
+}
+
+func find(blocks []block, addr uint32) *block {
+	i := sort.Search(len(blocks), func(i int) bool {
+		return blocks[i].end >= addr
+	})
+	if i < len(blocks) && blocks[i].start <= addr {
+		return &blocks[i]
+	}
+	return nil
+}
+
+// Registration returns the registration for addresses in ranges which are assigned algorithmically, or an empty string.
+func Registration(addr uint32) string {
+	if r := nNumber(addr); r != "" {
+		return r
+	}
+	for _, m := range strideMappings {
+		offset := int(addr) - int(m.start)
+		if offset < 0 || offset >= 26*26*26 {
+			continue
+		}
+		return m.prefix + string([]byte{alphabet[offset/(26*26)], alphabet[offset/26%26], alphabet[offset%26]})
+	}
+	return ""
+}
+
+// nNumber converts an address to a US N-number, the numbers are allocated in order
+// N1, N1A, N1AA, N1AB ... N1Z, N1ZZ, N10, N10A ... N99999.
+func nNumber(addr uint32) string {
+	offset := int(addr) - nNumberStart
+	if offset < 0 || offset >= nNumberCount {
+		return ""
+	}
+	sb := strings.Builder{}
+	sb.WriteByte('N')
+	sb.WriteByte(byte('1' + offset/nBucket1Size))
+	offset %= nBucket1Size
+	for _, size := range []int{nBucket2Size, nBucket3Size} {
+		if offset < nSuffixSize {
+			sb.WriteString(nSuffix(offset))
+			return sb.String()
+		}
+		offset -= nSuffixSize
+		sb.WriteByte(byte('0' + offset/size))
+		offset %= size
+	}
+	if offset < nSuffixSize {
+		sb.WriteString(nSuffix(offset))
+		return sb.String()
+	}
+	offset -= nSuffixSize
+	sb.WriteByte(byte('0' + offset/nBucket4Size))
+	offset %= nBucket4Size
+	// The last position is a single letter or digit.
+	switch {
+	case offset == 0:
+	case offset <= len(nLetters):
+		sb.WriteByte(nLetters[offset-1])
+	default:
+		sb.WriteByte(byte('0' + offset - len(nLetters) - 1))
+	}
+	return sb.String()
+}
+
+// nSuffix returns the zero, one or two letter suffix for an offset within a suffix range.
+func nSuffix(offset int) string {
+	if offset == 0 {
+		return ""
+	}
+	offset--
+	s := []byte{nLetters[offset/(len(nLetters)+1)]}
+	if rem := offset % (len(nLetters) + 1); rem > 0 {
+		s = append(s, nLetters[rem-1])
+	}
+	return string(s)
+}
+
+// Enricher adds country information from the address to every aircraft and fills in the
+// registration when the details did not have one and it can be computed from the address.
+// PIA aircraft use addresses which aren't assigned to them so their registration is never computed.
+type Enricher struct{}
+
+func (Enricher) Enrich(rpt *model.Report) {
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		addr, ok := Parse(ac.Hex)
+		if !ok {
+			continue
+		}
+		info := Lookup(addr)
+		if info.Country != "" {
+			country, code := info.Country, info.CountryCode
+			ac.Country, ac.CountryCode = &country, &code
+		}
+		if info.MilitaryBlock {
+			mil := true
+			ac.MilitaryBlock = &mil
+		}
+		pia := ac.PIA != nil && *ac.PIA
+		if ac.Registration == nil && info.Registration != "" && !pia {
+			reg := info.Registration
+			ac.Registration = &reg
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package icao
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func Test_Registration(t *testing.T) {
+	tests := map[uint32]string{
+		0xA00001: "N1",
+		0xA00002: "N1A",
+		0xA00003: "N1AA",
+		0xA0002B: "N1BR",
+		0xA08AE3: "N134JP",
+		0xADF7C7: "N99999",
+		0xADF7C8: "",
+		0xC00001: "C-FAAA",
+		0xC044A8: "C-FZZZ",
+		0xC044A9: "C-GAAA",
+		0x3C6444: "",
+	}
+	for addr, expected := range tests {
+		if r := Registration(addr); r != expected {
+			t.Errorf("%06X: expected %q got %q", addr, expected, r)
+		}
+	}
+}
+
+func Test_TablesSorted(t *testing.T) {
+	for name, blocks := range map[string][]block{"allocations": allocations, "military": militaryBlocks} {
+		if !sort.SliceIsSorted(blocks, func(i, j int) bool { return blocks[i].start < blocks[j].start }) {
+			t.Errorf("%s is not sorted", name)
+		}
+		for i := range blocks {
+			if blocks[i].end < blocks[i].start || i > 0 && blocks[i].start <= blocks[i-1].end {
+				t.Errorf("%s: block %06X-%06X overlaps or is inverted", name, blocks[i].start, blocks[i].end)
+			}
+		}
+	}
+}
+
+func Test_Enrich(t *testing.T) {
+	reg := "14-5791"
+	pia := true
+	rpt := &model.Report{Aircraft: []model.Aircraft{
+		{Hex: "3c6444"},
+		{Hex: "ae595d", Details: model.Details{Registration: &reg}},
+		{Hex: "a08ae3"},
+		{Hex: "~12345"},
+		{Hex: "f00000"},
+		{Hex: "a08ae3", Details: model.Details{PIA: &pia}},
+	}}
+	Enricher{}.Enrich(rpt)
+
+	if ac := rpt.Aircraft[0]; *ac.Country != "Germany" || *ac.CountryCode != "DE" || ac.MilitaryBlock != nil || ac.Registration != nil {
+		t.Errorf("unexpected enrichment %+v", ac)
+	}
+	if ac := rpt.Aircraft[1]; *ac.CountryCode != "US" || ac.MilitaryBlock == nil || *ac.Registration != reg {
+		t.Errorf("unexpected enrichment %+v", ac)
+	}
+	if ac := rpt.Aircraft[2]; *ac.Registration != "N134JP" {
+		t.Errorf("expected registration to be computed, got %+v", ac.Registration)
+	}
+	if ac := rpt.Aircraft[5]; ac.Registration != nil || *ac.CountryCode != "US" {
+		t.Errorf("expected no registration to be computed for a PIA address, got %+v", ac.Registration)
+	}
+	for _, ac := range rpt.Aircraft[3:5] {
+		if ac.Country != nil || ac.Registration != nil {
+			t.Errorf("expected %s to be left alone", ac.Hex)
+		}
+	}
+}
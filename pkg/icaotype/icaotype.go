@@ -0,0 +1,173 @@
+package icaotype
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+var (
+	aircraftTypes = map[byte]string{
+		'L': "landplane",
+		'S': "seaplane",
+		'A': "amphibian",
+		'H': "helicopter",
+		'G': "gyrocopter",
+		'T': "tiltrotor",
+	}
+	engineTypes = map[byte]string{
+		'J': "jet",
+		'T': "turboprop",
+		'P': "piston",
+		'E': "electric",
+		'R': "rocket",
+	}
+)
+
+type Config struct {
+	File string `yaml:"file"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.File, "aircraft-types.file", "", "ICAO Doc 8643 style table of aircraft type designators, either a CSV with designator, manufacturer, model, description and wtc columns or a tar1090-db icao_aircraft_types.json")
+}
+
+// Table resolves ICAO type designators such as B738 to a description of the aircraft type.
+type Table struct {
+	logger log.Logger
+	types  map[string]*model.TypeInfo
+}
+
+func New(logger log.Logger, config Config) (*Table, error) {
+	t := &Table{
+		logger: log.With(logger, "component", "aircraft_types"),
+	}
+	f, err := os.Open(config.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening aircraft types file: %s", err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(strings.ToLower(config.File), ".json") {
+		t.types, err = parseJSON(f)
+	} else {
+		t.types, err = parseCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing aircraft types file %s: %s", config.File, err)
+	}
+	level.Info(t.logger).Log("msg", "loaded aircraft types", "types", len(t.types))
+	return t, nil
+}
+
+func parseCSV(r io.Reader) (map[string]*model.TypeInfo, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %s", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	get := func(rec []string, names ...string) string {
+		for _, n := range names {
+			if i, ok := cols[n]; ok && i < len(rec) {
+				return strings.TrimSpace(rec[i])
+			}
+		}
+		return ""
+	}
+	types := map[string]*model.TypeInfo{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return types, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		designator := strings.ToUpper(get(rec, "designator", "type_code", "icao"))
+		if designator == "" {
+			continue
+		}
+		ti := newTypeInfo(designator, get(rec, "description", "desc"), get(rec, "wtc", "wake_turbulence_category"))
+		ti.Manufacturer = get(rec, "manufacturer", "manufacturer_code")
+		ti.Model = get(rec, "model", "model_full_name")
+		// Doc 8643 lists a designator once per manufacturer and model, keep the first.
+		if _, ok := types[designator]; !ok {
+			types[designator] = ti
+		}
+	}
+}
+
+// parseJSON reads the format of icao_aircraft_types.json from tar1090-db, e.g. {"B738": {"desc": "L2J", "wtc": "M"}}
+func parseJSON(r io.Reader) (map[string]*model.TypeInfo, error) {
+	bts, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]struct {
+		Desc         string `json:"desc"`
+		WTC          string `json:"wtc"`
+		Manufacturer string `json:"manufacturer"`
+		Model        string `json:"model"`
+	}{}
+	if err := json.Unmarshal(bts, &raw); err != nil {
+		return nil, err
+	}
+	types := make(map[string]*model.TypeInfo, len(raw))
+	for designator, v := range raw {
+		d := strings.ToUpper(designator)
+		ti := newTypeInfo(d, v.Desc, v.WTC)
+		ti.Manufacturer = v.Manufacturer
+		ti.Model = v.Model
+		types[d] = ti
+	}
+	return types, nil
+}
+
+// newTypeInfo decodes an ICAO aircraft description like L2J (landplane, 2 engines, jet).
+func newTypeInfo(designator, desc, wtc string) *model.TypeInfo {
+	ti := &model.TypeInfo{
+		Designator:  designator,
+		Description: strings.ToUpper(desc),
+		WTC:         strings.ToUpper(wtc),
+	}
+	if len(ti.Description) == 3 {
+		ti.AircraftType = aircraftTypes[ti.Description[0]]
+		if c := ti.Description[1]; c >= '1' && c <= '9' {
+			n := int(c - '0')
+			ti.EngineCount = &n
+		}
+		ti.EngineType = engineTypes[ti.Description[2]]
+	}
+	return ti
+}
+
+// Lookup returns the type info for an ICAO designator or nil, the result is shared and must not be modified.
+func (t *Table) Lookup(designator string) *model.TypeInfo {
+	return t.types[strings.ToUpper(designator)]
+}
+
+// Enrich joins every aircraft's type code to its type info.
+func (t *Table) Enrich(rpt *model.Report) {
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		if ac.TypeCode == nil {
+			continue
+		}
+		ac.TypeInfo = t.Lookup(*ac.TypeCode)
+	}
+}
@@ -0,0 +1,57 @@
+package icaotype
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func stringP(v string) *string {
+	return &v
+}
+
+func Test_Parse(t *testing.T) {
+	csvTypes, err := parseCSV(strings.NewReader(`Designator,Manufacturer,Model,Description,WTC
+B738,BOEING,737-800,L2J,M
+C30J,LOCKHEED MARTIN,C-130J-30 Hercules,L4T,M
+EC35,AIRBUS HELICOPTERS,EC135,H2T,L
+A388,AIRBUS,A380-800,L4J,J
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonTypes, err := parseJSON(strings.NewReader(`{"B738": {"desc": "L2J", "wtc": "M"}, "ZZZZ": {"desc": "", "wtc": ""}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b738 := csvTypes["B738"]
+	if b738.Manufacturer != "BOEING" || b738.AircraftType != "landplane" || *b738.EngineCount != 2 || b738.EngineType != "jet" || b738.WTC != "M" {
+		t.Fatalf("unexpected type info %+v", b738)
+	}
+	if j := jsonTypes["B738"]; j.AircraftType != b738.AircraftType || *j.EngineCount != 2 || j.WTC != "M" {
+		t.Fatalf("unexpected type info from json %+v", j)
+	}
+	if z := jsonTypes["ZZZZ"]; z.EngineCount != nil || z.AircraftType != "" {
+		t.Fatalf("expected empty description to leave fields unset %+v", z)
+	}
+
+	table := &Table{types: csvTypes}
+	rpt := &model.Report{Aircraft: []model.Aircraft{
+		{Hex: "ae595d", Details: model.Details{TypeCode: stringP("c30j")}},
+		{Hex: "3c6444", Details: model.Details{TypeCode: stringP("EC35")}},
+		{Hex: "a00001", Details: model.Details{TypeCode: stringP("XXXX")}},
+		{Hex: "a00002"},
+	}}
+	table.Enrich(rpt)
+	if ti := rpt.Aircraft[0].TypeInfo; ti == nil || ti.EngineType != "turboprop" || *ti.EngineCount != 4 {
+		t.Fatalf("unexpected type info %+v", ti)
+	}
+	if ti := rpt.Aircraft[1].TypeInfo; ti == nil || ti.AircraftType != "helicopter" || ti.WTC != "L" {
+		t.Fatalf("unexpected type info %+v", ti)
+	}
+	if rpt.Aircraft[2].TypeInfo != nil || rpt.Aircraft[3].TypeInfo != nil {
+		t.Fatal("expected unknown type codes to be left alone")
+	}
+}
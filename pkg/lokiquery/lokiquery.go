@@ -0,0 +1,171 @@
+package lokiquery
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	URL       string
+	OrgID     string
+	Username  string
+	Password  string
+	BatchSize int
+	Timeout   time.Duration
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.URL, "loki.url", "", "Base URL of Loki, e.g. http://localhost:3100")
+	f.StringVar(&c.OrgID, "loki.org-id", "", "Tenant ID sent as X-Scope-OrgID, if Loki is multi tenant")
+	f.StringVar(&c.Username, "loki.username", "", "Username for basic auth")
+	f.StringVar(&c.Password, "loki.password", "", "Password for basic auth")
+	f.IntVar(&c.BatchSize, "loki.batch-size", 5000, "Number of entries to request from Loki at a time")
+	f.DurationVar(&c.Timeout, "loki.timeout", time.Minute, "Timeout for each request to Loki")
+}
+
+// Entry is a single log line returned by a query.
+type Entry struct {
+	Labels map[string]string
+	Time   time.Time
+	Line   string
+}
+
+// Client runs log queries against the Loki HTTP API.
+type Client struct {
+	config Config
+	client *http.Client
+}
+
+func New(config Config) *Client {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 5000
+	}
+	return &Client{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+type response struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// QueryRange calls fn with every entry matching the log query in [start, end) in time order, fetching
+// them in batches. Entries from different streams with the same timestamp may be in any order.
+func (c *Client) QueryRange(ctx context.Context, query string, start, end time.Time, fn func(Entry) error) error {
+	// Entries at the timestamp the next batch starts from were already passed to fn.
+	seen := map[string]bool{}
+	for {
+		entries, err := c.queryBatch(ctx, query, start, end)
+		if err != nil {
+			return err
+		}
+		last := start
+		boundary := map[string]bool{}
+		for _, e := range entries {
+			key := labelsKey(e.Labels) + "\x00" + e.Line
+			if e.Time.Equal(start) && seen[key] {
+				continue
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+			if e.Time.After(last) {
+				last = e.Time
+				boundary = map[string]bool{}
+			}
+			if e.Time.Equal(last) {
+				boundary[key] = true
+			}
+		}
+		if len(entries) < c.config.BatchSize {
+			return nil
+		}
+		if last.Equal(start) {
+			// The whole batch had one timestamp, skip past it rather than asking for the same batch again.
+			last = last.Add(time.Nanosecond)
+			boundary = map[string]bool{}
+		}
+		start, seen = last, boundary
+	}
+}
+
+func labelsKey(lbls map[string]string) string {
+	keys := make([]string, 0, len(lbls))
+	for k := range lbls {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := &strings.Builder{}
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(lbls[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+func (c *Client) queryBatch(ctx context.Context, query string, start, end time.Time) ([]Entry, error) {
+	params := url.Values{}
+	params.Set("query", query)
+	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	params.Set("limit", strconv.Itoa(c.config.BatchSize))
+	params.Set("direction", "forward")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.config.URL, "/")+"/loki/api/v1/query_range?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.config.OrgID != "" {
+		req.Header.Set("X-Scope-OrgID", c.config.OrgID)
+	}
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("loki query failed with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	r := response{}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("error decoding loki response: %s", err)
+	}
+	if r.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("expected a streams result from loki, got %q", r.Data.ResultType)
+	}
+	var entries []Entry
+	for _, s := range r.Data.Result {
+		for _, v := range s.Values {
+			ns, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q in loki response", v[0])
+			}
+			entries = append(entries, Entry{Labels: s.Stream, Time: time.Unix(0, ns), Line: v[1]})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
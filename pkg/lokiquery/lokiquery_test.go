@@ -0,0 +1,94 @@
+package lokiquery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testEntry struct {
+	stream string
+	ts     int64
+	line   string
+}
+
+// fakeLoki serves query_range from entries, honouring start, end and limit like Loki does in forward direction.
+func fakeLoki(t *testing.T, entries []testEntry, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.URL.Path != "/loki/api/v1/query_range" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		var matched []testEntry
+		for _, e := range entries {
+			if e.ts >= start && e.ts < end {
+				matched = append(matched, e)
+			}
+		}
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].ts < matched[j].ts })
+		if len(matched) > limit {
+			matched = matched[:limit]
+		}
+		streams := map[string][][2]string{}
+		for _, e := range matched {
+			streams[e.stream] = append(streams[e.stream], [2]string{strconv.FormatInt(e.ts, 10), e.line})
+		}
+		resp := map[string]interface{}{"status": "success"}
+		result := []interface{}{}
+		for s, values := range streams {
+			result = append(result, map[string]interface{}{"stream": map[string]string{"hex": s}, "values": values})
+		}
+		resp["data"] = map[string]interface{}{"resultType": "streams", "result": result}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func Test_QueryRange(t *testing.T) {
+	entries := []testEntry{
+		{"a", 1, "a1"}, {"b", 1, "b1"}, {"a", 2, "a2"}, {"b", 3, "b3"},
+		// Three entries at the same time span a batch boundary.
+		{"a", 4, "a4"}, {"b", 4, "b4"}, {"c", 4, "c4"},
+		{"a", 5, "a5"}, {"a", 10, "a10"},
+	}
+	requests := 0
+	srv := fakeLoki(t, entries, &requests)
+	defer srv.Close()
+
+	c := New(Config{URL: srv.URL, OrgID: "tenant", BatchSize: 3, Timeout: time.Second})
+	var got []string
+	err := c.QueryRange(context.Background(), `{job="adsb"}`, time.Unix(0, 0), time.Unix(0, 10), func(e Entry) error {
+		got = append(got, e.Line)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 8 {
+		t.Fatalf("expected 8 entries got %v", got)
+	}
+	seen := map[string]bool{}
+	for _, l := range got {
+		if seen[l] {
+			t.Fatalf("duplicate entry %s in %v", l, got)
+		}
+		seen[l] = true
+	}
+	if got[len(got)-1] != "a5" || requests < 3 {
+		t.Fatalf("unexpected entries %v after %d requests", got, requests)
+	}
+
+	c = New(Config{URL: srv.URL, OrgID: "other"})
+	if err := c.QueryRange(context.Background(), `{job="adsb"}`, time.Unix(0, 0), time.Unix(0, 10), func(Entry) error { return nil }); err == nil {
+		t.Fatal("expected an error response to be returned")
+	}
+}
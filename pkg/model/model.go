@@ -2,6 +2,8 @@ package model
 
 import (
 	"encoding/json"
+	"math"
+	"time"
 )
 
 type Details struct {
@@ -14,6 +16,53 @@ type Details struct {
 	Description  *string `json:"description,omitempty"`
 	Manufactured *string `json:"manufactured,omitempty"`
 	Owner        *string `json:"owner,omitempty"`
+
+	// Tags come from the local overrides file and are never stored in the aircraft database.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Operator is the airline or other organisation flying an aircraft, resolved from its callsign.
+type Operator struct {
+	ICAO         string `json:"icao,omitempty"`
+	IATA         string `json:"iata,omitempty"`
+	Name         string `json:"name,omitempty"`
+	Callsign     string `json:"callsign,omitempty"`
+	Country      string `json:"country,omitempty"`
+	FlightNumber string `json:"flight_number,omitempty"`
+}
+
+type Airport struct {
+	ICAO    string  `json:"icao,omitempty"`
+	IATA    string  `json:"iata,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	Country string  `json:"country,omitempty"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+}
+
+// Route is the scheduled route for a flight callsign.
+type Route struct {
+	// Airports are the codes of every airport on the route in order, the origin first.
+	Airports    []string `json:"airports"`
+	Origin      *Airport `json:"origin,omitempty"`
+	Destination *Airport `json:"destination,omitempty"`
+	// Plausible is whether the aircraft's position and track are consistent with flying the route.
+	Plausible  *bool    `json:"plausible,omitempty"`
+	OffTrackKm *float64 `json:"off_track_km,omitempty"`
+}
+
+// TypeInfo describes an ICAO aircraft type designator.
+type TypeInfo struct {
+	Designator   string `json:"designator"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Model        string `json:"model,omitempty"`
+	// Description is the ICAO aircraft description, e.g. L2J for a landplane with 2 jet engines.
+	Description  string `json:"description,omitempty"`
+	AircraftType string `json:"aircraft_type,omitempty"`
+	EngineCount  *int   `json:"engine_count,omitempty"`
+	EngineType   string `json:"engine_type,omitempty"`
+	// WTC is the wake turbulence category, L, M, H or J.
+	WTC string `json:"wtc,omitempty"`
 }
 
 type Report struct {
@@ -22,21 +71,74 @@ type Report struct {
 	Aircraft []Aircraft `json:"aircraft"`
 }
 
+// Time converts the receiver's now timestamp, seconds since the epoch, into a time.
+func (r *Report) Time() time.Time {
+	sec, frac := math.Modf(r.Now)
+	return time.Unix(int64(sec), int64(frac*1e9))
+}
+
+// SquawkInfo is the interpretation of an aircraft's squawk code.
+type SquawkInfo struct {
+	Description string `json:"description,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Emergency   bool   `json:"emergency,omitempty"`
+}
+
 type Aircraft struct {
-	Hex               string   `json:"hex"`
-	Squawk            *string  `json:"squawk,omitempty"`
-	Lat               *float64 `json:"lat,omitempty"`
-	Lon               *float64 `json:"lon,omitempty"`
-	Flight            *string  `json:"flight,omitempty"`
-	GroundSpeed       *float64 `json:"gs,omitempty"`
-	Track             *float64 `json:"track,omitempty"`
-	Emergency         *string  `json:"emergency,omitempty"`
-	Category          *string  `json:"category,omitempty"`
-	Rssi              *float32 `json:"rssi,omitempty"`
-	GeometricAltitude *float64 `json:"alt_geom,omitempty"`
+	Hex               string      `json:"hex"`
+	Squawk            *string     `json:"squawk,omitempty"`
+	SquawkInfo        *SquawkInfo `json:"squawk_info,omitempty"`
+	Lat               *float64    `json:"lat,omitempty"`
+	Lon               *float64    `json:"lon,omitempty"`
+	Flight            *string     `json:"flight,omitempty"`
+	GroundSpeed       *float64    `json:"gs,omitempty"`
+	Track             *float64    `json:"track,omitempty"`
+	Emergency         *string     `json:"emergency,omitempty"`
+	Category          *string     `json:"category,omitempty"`
+	Rssi              *float32    `json:"rssi,omitempty"`
+	GeometricAltitude *float64    `json:"alt_geom,omitempty"`
 
 	// This field might be a number, a string (usually "ground"), or nil
 	BarometerAltitude json.Token `json:"alt_baro,omitempty"`
 
 	Details
+
+	// CallsignType is how the flight callsign was interpreted, e.g. airline, registration or military.
+	CallsignType *string   `json:"callsign_type,omitempty"`
+	Operator     *Operator `json:"operator,omitempty"`
+	Route        *Route    `json:"route,omitempty"`
+	TypeInfo     *TypeInfo `json:"type_info,omitempty"`
+
+	// Country of registration from the ICAO address allocation.
+	Country     *string `json:"country,omitempty"`
+	CountryCode *string `json:"country_code,omitempty"`
+	// MilitaryBlock is set when the address is in a block known to be used by military aircraft.
+	MilitaryBlock *bool `json:"military_block,omitempty"`
+
+	// Watchlist is the names of the watchlist rules the aircraft matches.
+	Watchlist []string `json:"watchlist,omitempty"`
+	// Zones is the names of the geofences the aircraft is inside.
+	Zones []string `json:"zones,omitempty"`
+	// DelayedSeconds is how old the position is when it has been held back for privacy.
+	DelayedSeconds *float64 `json:"delayed_seconds,omitempty"`
+}
+
+// Altitude returns the barometric altitude in feet, or the geometric altitude if there is no barometric altitude.
+// An aircraft on the ground has an altitude of 0, ok is false if the altitude is unknown.
+func (a *Aircraft) Altitude() (ft float64, ok bool) {
+	switch v := a.BarometerAltitude.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		if v == "ground" {
+			return 0, true
+		}
+	}
+	if a.GeometricAltitude != nil {
+		return *a.GeometricAltitude, true
+	}
+	return 0, false
 }
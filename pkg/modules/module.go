@@ -0,0 +1,90 @@
+package modules
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// module wraps a service so it starts after the modules it depends on are running
+// and stops after the modules which depend on it have stopped.
+//
+// Unlike util.NewModuleService a service which is still starting when the module is stopped is stopped too,
+// so a slow start doesn't hold up shutdown.
+type module struct {
+	services.Service
+
+	logger     log.Logger
+	name       string
+	service    services.Service
+	deps       []*module
+	dependents []*module
+	// finished receives the name of the module if its service terminates by itself.
+	finished chan<- string
+}
+
+func newModule(logger log.Logger, name string, service services.Service, finished chan<- string) *module {
+	w := &module{
+		logger:   log.With(logger, "module", name),
+		name:     name,
+		service:  service,
+		finished: finished,
+	}
+	w.Service = services.NewBasicService(w.starting, w.running, w.stopping).WithName(name)
+	return w
+}
+
+// starting returns without an error if the module is stopped before it's running, stopping then cleans up.
+func (w *module) starting(ctx context.Context) error {
+	for _, d := range w.deps {
+		level.Debug(w.logger).Log("msg", "module waiting for dependency", "waiting_for", d.name)
+		if err := d.AwaitRunning(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("module %s depends on module %s which failed: %s", w.name, d.name, err)
+		}
+	}
+	level.Info(w.logger).Log("msg", "starting module")
+	// The service gets its own context, it's stopped once everything depending on it has stopped.
+	if err := w.service.StartAsync(context.Background()); err != nil {
+		return fmt.Errorf("error starting module %s: %s", w.name, err)
+	}
+	// A service which has already finished by itself is handled by running.
+	if err := w.service.AwaitRunning(ctx); err != nil && ctx.Err() == nil && w.service.State() != services.Terminated {
+		return err
+	}
+	return nil
+}
+
+func (w *module) running(ctx context.Context) error {
+	// Either the service finished by itself or the module is being stopped.
+	_ = w.service.AwaitTerminated(ctx)
+	if err := w.service.FailureCase(); err != nil {
+		return err
+	}
+	if ctx.Err() == nil {
+		level.Info(w.logger).Log("msg", "module finished")
+		w.finished <- w.name
+	}
+	return nil
+}
+
+func (w *module) stopping(_ error) error {
+	for _, d := range w.dependents {
+		level.Debug(w.logger).Log("msg", "module waiting for dependent to stop", "waiting_for", d.name)
+		_ = d.AwaitTerminated(context.Background())
+	}
+	if w.service.State() == services.New {
+		return nil
+	}
+	level.Info(w.logger).Log("msg", "stopping module")
+	if err := services.StopAndAwaitTerminated(context.Background(), w.service); err != nil {
+		return err
+	}
+	level.Info(w.logger).Log("msg", "module stopped")
+	return nil
+}
@@ -0,0 +1,169 @@
+// Package modules runs the components of adsb-loki as services.
+// A module starts once everything it depends on is running and stops once everything which depends on it has stopped.
+package modules
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// Module is a named service and the names of the modules it needs to be running first.
+type Module struct {
+	Name    string
+	Deps    []string
+	Service services.Service
+}
+
+type Modules struct {
+	logger   log.Logger
+	names    []string
+	services map[string]services.Service
+	modules  map[string]*module
+	manager  *services.Manager
+	failures *services.FailureWatcher
+	finished chan string
+}
+
+func New(logger log.Logger, mods ...Module) (*Modules, error) {
+	m := &Modules{
+		logger:   log.With(logger, "component", "modules"),
+		services: map[string]services.Service{},
+		modules:  map[string]*module{},
+		failures: services.NewFailureWatcher(),
+		// Each module finishes at most once so sending never blocks.
+		finished: make(chan string, len(mods)),
+	}
+	deps := map[string][]string{}
+	for _, mod := range mods {
+		if _, ok := deps[mod.Name]; ok {
+			return nil, fmt.Errorf("module %s is defined more than once", mod.Name)
+		}
+		deps[mod.Name] = mod.Deps
+		m.names = append(m.names, mod.Name)
+		m.services[mod.Name] = mod.Service
+	}
+	wrapped := make([]services.Service, 0, len(mods))
+	for _, mod := range mods {
+		for _, d := range mod.Deps {
+			if _, ok := deps[d]; !ok {
+				return nil, fmt.Errorf("module %s depends on unknown module %s", mod.Name, d)
+			}
+		}
+		if err := checkCycle(deps, mod.Name, nil); err != nil {
+			return nil, err
+		}
+		w := newModule(m.logger, mod.Name, mod.Service, m.finished)
+		m.modules[mod.Name] = w
+		wrapped = append(wrapped, w)
+	}
+	for _, mod := range mods {
+		for _, d := range mod.Deps {
+			m.modules[mod.Name].deps = append(m.modules[mod.Name].deps, m.modules[d])
+			m.modules[d].dependents = append(m.modules[d].dependents, m.modules[mod.Name])
+		}
+	}
+	var err error
+	m.manager, err = services.NewManager(wrapped...)
+	if err != nil {
+		return nil, err
+	}
+	m.failures.WatchManager(m.manager)
+	return m, nil
+}
+
+// checkCycle follows the dependencies of name and returns an error if they lead back to a module already in path.
+func checkCycle(deps map[string][]string, name string, path []string) error {
+	for _, p := range path {
+		if p == name {
+			return fmt.Errorf("modules have a dependency cycle: %s", strings.Join(append(path, name), " -> "))
+		}
+	}
+	for _, d := range deps[name] {
+		if err := checkCycle(deps, d, append(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start starts every module in the background, each one as soon as its dependencies are running.
+func (m *Modules) Start() error {
+	return m.manager.StartAsync(context.Background())
+}
+
+// Stop stops every module, dependents first, and waits for all of them to finish.
+func (m *Modules) Stop() {
+	level.Info(m.logger).Log("msg", "stopping modules")
+	m.manager.StopAsync()
+	if err := m.manager.AwaitStopped(context.Background()); err != nil {
+		level.Error(m.logger).Log("msg", "error waiting for modules to stop", "err", err)
+	}
+	for _, name := range m.names {
+		if err := m.services[name].FailureCase(); err != nil {
+			level.Error(m.logger).Log("msg", "module failed", "module", name, "err", err)
+		}
+	}
+}
+
+// Failed receives an error when any module fails.
+func (m *Modules) Failed() <-chan error {
+	return m.failures.Chan()
+}
+
+// Finished receives the name of any module whose service terminates by itself without failing,
+// e.g. once a replay has no more reports. The module is no longer running, so the rest should be stopped.
+func (m *Modules) Finished() <-chan string {
+	return m.finished
+}
+
+// States returns the state of every module's service.
+func (m *Modules) States() map[string]services.State {
+	states := make(map[string]services.State, len(m.names))
+	for _, name := range m.names {
+		states[name] = m.services[name].State()
+	}
+	return states
+}
+
+func (m *Modules) writeStates(w http.ResponseWriter, status int) {
+	states := m.States()
+	names := make([]string, 0, len(states))
+	for n := range states {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	for _, n := range names {
+		fmt.Fprintf(w, "%s: %s\n", n, states[n])
+	}
+}
+
+// Ready responds 200 once every module is running and 503 otherwise, listing the state of each module.
+func (m *Modules) Ready(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	for _, s := range m.States() {
+		if s != services.Running {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	m.writeStates(w, status)
+}
+
+// Health responds 200 unless a module has failed, modules still starting or stopping are healthy.
+func (m *Modules) Health(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	for _, s := range m.States() {
+		if s == services.Failed {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	m.writeStates(w, status)
+}
@@ -0,0 +1,170 @@
+package modules
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+)
+
+// recorder keeps the order services start and stop in.
+type recorder struct {
+	mtx    sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(e string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) service(name string, release <-chan struct{}) services.Service {
+	return services.NewIdleService(func(ctx context.Context) error {
+		if release != nil {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		r.add("start " + name)
+		return nil
+	}, func(error) error {
+		r.add("stop " + name)
+		return nil
+	})
+}
+
+func get(t *testing.T, h http.HandlerFunc) (int, string) {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	return w.Code, string(body)
+}
+
+func Test_Modules(t *testing.T) {
+	rec := &recorder{}
+	release := make(chan struct{})
+	m, err := New(log.NewNopLogger(),
+		Module{Name: "c", Deps: []string{"b"}, Service: rec.service("c", nil)},
+		Module{Name: "b", Deps: []string{"a"}, Service: rec.service("b", release)},
+		Module{Name: "a", Service: rec.service("a", nil)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// b is still starting so c waits and the modules are healthy but not ready.
+	deadline := time.Now().Add(5 * time.Second)
+	for m.States()["a"] != services.Running {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a to start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if code, body := get(t, m.Ready); code != http.StatusServiceUnavailable || !strings.Contains(body, "b: Starting\n") || !strings.Contains(body, "c: New\n") {
+		t.Errorf("unexpected ready response %d %q", code, body)
+	}
+	if code, _ := get(t, m.Health); code != http.StatusOK {
+		t.Errorf("expected healthy while starting, got %d", code)
+	}
+
+	close(release)
+	if err := m.manager.AwaitHealthy(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := get(t, m.Ready); code != http.StatusOK || body != "a: Running\nb: Running\nc: Running\n" {
+		t.Errorf("unexpected ready response %d %q", code, body)
+	}
+
+	m.Stop()
+	expected := []string{"start a", "start b", "start c", "stop c", "stop b", "stop a"}
+	if strings.Join(rec.events, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, rec.events)
+	}
+	if code, _ := get(t, m.Ready); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready once stopped, got %d", code)
+	}
+}
+
+func Test_ModulesFailure(t *testing.T) {
+	m, err := New(log.NewNopLogger(),
+		Module{Name: "a", Service: services.NewIdleService(nil, nil)},
+		Module{Name: "b", Deps: []string{"a"}, Service: services.NewBasicService(nil, func(context.Context) error {
+			return context.DeadlineExceeded
+		}, nil)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-m.Failed():
+		if !strings.Contains(err.Error(), "deadline exceeded") {
+			t.Errorf("unexpected failure %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the failure")
+	}
+	if code, body := get(t, m.Health); code != http.StatusServiceUnavailable || !strings.Contains(body, "b: Failed\n") {
+		t.Errorf("unexpected health response %d %q", code, body)
+	}
+	m.Stop()
+}
+
+func Test_ModulesFinished(t *testing.T) {
+	m, err := New(log.NewNopLogger(),
+		Module{Name: "a", Service: services.NewIdleService(nil, nil)},
+		Module{Name: "b", Deps: []string{"a"}, Service: services.NewBasicService(nil, func(context.Context) error {
+			return nil
+		}, nil)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-m.Finished():
+		if name != "b" {
+			t.Errorf("expected b to finish, got %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for b to finish")
+	}
+	m.Stop()
+	select {
+	case name := <-m.Finished():
+		t.Errorf("expected only b to finish, got %s", name)
+	default:
+	}
+}
+
+func Test_ModulesInvalid(t *testing.T) {
+	for name, mods := range map[string][]Module{
+		"unknown dependency": {{Name: "a", Deps: []string{"b"}, Service: services.NewIdleService(nil, nil)}},
+		"duplicate":          {{Name: "a", Service: services.NewIdleService(nil, nil)}, {Name: "a", Service: services.NewIdleService(nil, nil)}},
+		"cycle": {
+			{Name: "a", Deps: []string{"b"}, Service: services.NewIdleService(nil, nil)},
+			{Name: "b", Deps: []string{"a"}, Service: services.NewIdleService(nil, nil)},
+		},
+	} {
+		if _, err := New(log.NewNopLogger(), mods...); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
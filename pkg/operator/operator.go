@@ -0,0 +1,168 @@
+package operator
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Callsign types assigned to model.Aircraft.CallsignType
+const (
+	CallsignAirline      = "airline"
+	CallsignRegistration = "registration"
+	CallsignMilitary     = "military"
+	CallsignUnknown      = "unknown"
+)
+
+var (
+	// ICAO airline designator followed by a flight number which may end in letters, e.g. UAL123 or BAW12AB
+	airlineRe = regexp.MustCompile(`^([A-Z]{3})([0-9][0-9A-Z]{0,3})$`)
+	// US registrations are often used as the callsign, e.g. N123AB
+	nNumberRe = regexp.MustCompile(`^N[1-9][0-9]{0,4}[A-Z]{0,2}$`)
+	// Military tactical callsigns are typically a word followed by a number, e.g. REACH123 or DUKE21
+	tacticalRe = regexp.MustCompile(`^[A-Z]{4,6}[0-9]{1,3}$`)
+)
+
+type Config struct {
+	File string `yaml:"file"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.File, "operators.file", "", "CSV file of airlines with a header containing icao and optionally iata, name, callsign, country and military columns")
+}
+
+type entry struct {
+	operator model.Operator
+	military bool
+}
+
+// Table resolves flight callsigns to operators.
+type Table struct {
+	logger    log.Logger
+	operators map[string]entry
+}
+
+// New creates a Table, if no file is configured callsigns are still classified but never resolved to an operator.
+func New(logger log.Logger, config Config) (*Table, error) {
+	t := &Table{
+		logger:    log.With(logger, "component", "operators"),
+		operators: map[string]entry{},
+	}
+	if config.File == "" {
+		return t, nil
+	}
+	f, err := os.Open(config.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening operators file: %s", err)
+	}
+	defer f.Close()
+	t.operators, err = parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing operators file %s: %s", config.File, err)
+	}
+	level.Info(t.logger).Log("msg", "loaded operators", "operators", len(t.operators))
+	return t, nil
+}
+
+func parse(r io.Reader) (map[string]entry, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %s", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := cols["icao"]; !ok {
+		return nil, fmt.Errorf("header must contain an icao column")
+	}
+	operators := map[string]entry{}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(name string) string {
+			i, ok := cols[name]
+			if !ok || i >= len(rec) {
+				return ""
+			}
+			return strings.TrimSpace(rec[i])
+		}
+		icao := strings.ToUpper(get("icao"))
+		if len(icao) != 3 {
+			continue
+		}
+		military := strings.ToLower(get("military"))
+		operators[icao] = entry{
+			operator: model.Operator{
+				ICAO:     icao,
+				IATA:     strings.ToUpper(get("iata")),
+				Name:     get("name"),
+				Callsign: get("callsign"),
+				Country:  get("country"),
+			},
+			military: military == "1" || military == "true" || military == "y" || military == "yes",
+		}
+	}
+	return operators, nil
+}
+
+// Enrich sets the callsign type and operator of every aircraft with a flight callsign.
+func (t *Table) Enrich(rpt *model.Report) {
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		if ac.Flight == nil || *ac.Flight == "" {
+			continue
+		}
+		typ, op := t.Classify(*ac.Flight, ac.Registration, ac.Military != nil && *ac.Military)
+		ac.CallsignType = &typ
+		ac.Operator = op
+	}
+}
+
+// Classify works out what kind of callsign this is and who the operator is, if known.
+func (t *Table) Classify(callsign string, registration *string, military bool) (string, *model.Operator) {
+	cs := strings.ToUpper(strings.TrimSpace(callsign))
+	if registration != nil && cs == strings.ToUpper(strings.ReplaceAll(*registration, "-", "")) {
+		return CallsignRegistration, nil
+	}
+	if nNumberRe.MatchString(cs) {
+		return CallsignRegistration, nil
+	}
+	if m := airlineRe.FindStringSubmatch(cs); m != nil {
+		if e, ok := t.operators[m[1]]; ok {
+			op := e.operator
+			if op.IATA != "" {
+				num := strings.TrimLeft(m[2], "0")
+				if num == "" {
+					num = "0"
+				}
+				op.FlightNumber = op.IATA + num
+			}
+			if e.military {
+				return CallsignMilitary, &op
+			}
+			return CallsignAirline, &op
+		}
+	}
+	if military || tacticalRe.MatchString(cs) {
+		return CallsignMilitary, nil
+	}
+	return CallsignUnknown, nil
+}
@@ -0,0 +1,72 @@
+package operator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+var testOperators = `icao,iata,name,callsign,country,military
+UAL,UA,United Airlines,UNITED,United States,
+BAW,BA,British Airways,SPEEDBIRD,United Kingdom,
+RCH,,Air Mobility Command,REACH,United States,1
+BA,,Too short,,,
+`
+
+func stringP(v string) *string {
+	return &v
+}
+
+func Test_Classify(t *testing.T) {
+	ops, err := parse(strings.NewReader(testOperators))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 3 {
+		t.Fatalf("expected 3 operators, got %d", len(ops))
+	}
+	table := &Table{logger: log.NewNopLogger(), operators: ops}
+
+	tests := []struct {
+		callsign     string
+		registration *string
+		military     bool
+		expectedType string
+		expectedICAO string
+		flightNumber string
+	}{
+		{"UAL123", nil, false, CallsignAirline, "UAL", "UA123"},
+		{"BAW07A", nil, false, CallsignAirline, "BAW", "BA7A"},
+		{"RCH842", nil, false, CallsignMilitary, "RCH", ""},
+		{"N134JP", nil, false, CallsignRegistration, "", ""},
+		{"GABCD", stringP("G-ABCD"), false, CallsignRegistration, "", ""},
+		{"DUKE21", nil, false, CallsignMilitary, "", ""},
+		{"XYZ123", nil, false, CallsignUnknown, "", ""},
+		{"ABC", nil, true, CallsignMilitary, "", ""},
+	}
+	for _, tt := range tests {
+		typ, op := table.Classify(tt.callsign, tt.registration, tt.military)
+		if typ != tt.expectedType {
+			t.Errorf("%s: expected type %s got %s", tt.callsign, tt.expectedType, typ)
+		}
+		icao, fn := "", ""
+		if op != nil {
+			icao, fn = op.ICAO, op.FlightNumber
+		}
+		if icao != tt.expectedICAO || fn != tt.flightNumber {
+			t.Errorf("%s: expected operator %s %s got %s %s", tt.callsign, tt.expectedICAO, tt.flightNumber, icao, fn)
+		}
+	}
+
+	rpt := &model.Report{Aircraft: []model.Aircraft{{Hex: "a00001", Flight: stringP("UAL1")}, {Hex: "a00002"}}}
+	table.Enrich(rpt)
+	if rpt.Aircraft[0].Operator == nil || rpt.Aircraft[0].Operator.Name != "United Airlines" {
+		t.Fatalf("expected aircraft to be enriched with operator, got %+v", rpt.Aircraft[0].Operator)
+	}
+	if rpt.Aircraft[1].CallsignType != nil {
+		t.Fatal("expected aircraft without a callsign to be left alone")
+	}
+}
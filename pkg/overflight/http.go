@@ -0,0 +1,57 @@
+package overflight
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+)
+
+const dateFormat = "2006-01-02"
+
+// parseTime accepts RFC3339 or a date, a date used as the end of a range includes the whole day.
+func parseTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(dateFormat, s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, must be RFC3339 or YYYY-MM-DD", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// ServeHTTP lists the passes as JSON, the from and to query parameters default to the last 24 hours and
+// point optionally limits the passes to one point, e.g. /overflights?from=2021-06-01&to=2021-06-30&point=home
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to, from := time.Now(), time.Now().Add(-24*time.Hour)
+	var err error
+	if v := q.Get("from"); v != "" {
+		if from, err = parseTime(v, false); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = parseTime(v, true); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	passes, err := t.Passes(from, to, q.Get("point"))
+	if err != nil {
+		level.Error(t.logger).Log("msg", "failed to query overflights", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(passes); err != nil {
+		level.Warn(t.logger).Log("msg", "failed to write overflights response", "err", err)
+	}
+}
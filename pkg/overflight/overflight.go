@@ -0,0 +1,275 @@
+package overflight
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/geo"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Point is a reference point, such as a house, to record the closest approach of each passing aircraft to.
+type Point struct {
+	Name        string  `yaml:"name"`
+	Lat         float64 `yaml:"lat"`
+	Lon         float64 `yaml:"lon"`
+	ElevationFt float64 `yaml:"elevation_ft"`
+}
+
+// Config for overflight reports.
+//
+//  overflights:
+//    points:
+//      - name: home
+//        lat: 51.47
+//        lon: -0.3
+//        elevation_ft: 50
+type Config struct {
+	Points        []Point       `yaml:"points"`
+	BoltDbFile    string        `yaml:"db_file"`
+	MaxDistanceKm float64       `yaml:"max_distance_km"`
+	PassTimeout   time.Duration `yaml:"pass_timeout"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	path, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	f.StringVar(&c.BoltDbFile, "overflights.db-file", filepath.Join(path, "overflights.db"), "Where to save the overflights db, defaults to the current working directory ./overflights.db")
+	f.Float64Var(&c.MaxDistanceKm, "overflights.max-distance-km", 10, "Passes are only recorded if the aircraft comes within this horizontal distance of a point, the pass ends when it leaves this distance")
+	f.DurationVar(&c.PassTimeout, "overflights.pass-timeout", 2*time.Minute, "How long an aircraft can go without a position before its pass is ended")
+}
+
+// Pass is the closest point of approach of one aircraft to a point.
+type Pass struct {
+	Point        string    `json:"point"`
+	Hex          string    `json:"hex"`
+	Time         time.Time `json:"time"`
+	DistanceKm   float64   `json:"distance_km"`
+	SlantRangeKm *float64  `json:"slant_range_km,omitempty"`
+	AltitudeFt   *float64  `json:"altitude_ft,omitempty"`
+	SpeedKt      *float64  `json:"speed_kt,omitempty"`
+	Flight       *string   `json:"flight,omitempty"`
+	Registration *string   `json:"registration,omitempty"`
+	TypeCode     *string   `json:"type_code,omitempty"`
+	Owner        *string   `json:"owner,omitempty"`
+}
+
+type sample struct {
+	t        time.Time
+	lat, lon float64
+	alt, gs  *float64
+}
+
+type pass struct {
+	best     Pass
+	aircraft model.Aircraft
+}
+
+type track struct {
+	last   sample
+	passes map[string]*pass
+}
+
+// Tracker follows every aircraft with a position and records a Pass each time one goes by a point.
+type Tracker struct {
+	logger log.Logger
+	config Config
+	db     *bolt.DB
+	tracks map[string]*track
+}
+
+func New(logger log.Logger, config Config) (*Tracker, error) {
+	names := map[string]bool{}
+	for _, p := range config.Points {
+		if p.Name == "" {
+			return nil, fmt.Errorf("overflight point must have a name")
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate overflight point %s", p.Name)
+		}
+		names[p.Name] = true
+	}
+	db, err := bolt.Open(config.BoltDbFile, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening overflights boltdb file: %s", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(passesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating overflights bucket: %s", err)
+	}
+	return &Tracker{
+		logger: log.With(logger, "component", "overflights"),
+		config: config,
+		db:     db,
+		tracks: map[string]*track{},
+	}, nil
+}
+
+func (t *Tracker) Stop() {
+	t.db.Close()
+}
+
+// kmPerDegree converts degrees of latitude to km for the local projection used by approach.
+var kmPerDegree = geo.EarthRadiusKm * math.Pi / 180
+
+// approach finds the closest point to p on the straight line between two samples, returning the fraction
+// of the way along the line and the horizontal distance in km. Positions are projected onto a plane centred
+// on p which is accurate over the distances overflights are interested in.
+func approach(p Point, from, to sample) (float64, float64) {
+	scale := math.Cos(p.Lat * math.Pi / 180)
+	x0, y0 := (from.lon-p.Lon)*scale*kmPerDegree, (from.lat-p.Lat)*kmPerDegree
+	x1, y1 := (to.lon-p.Lon)*scale*kmPerDegree, (to.lat-p.Lat)*kmPerDegree
+	dx, dy := x1-x0, y1-y0
+	f := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		f = math.Max(0, math.Min(1, -(x0*dx+y0*dy)/l))
+	}
+	return f, math.Hypot(x0+f*dx, y0+f*dy)
+}
+
+func interpolate(a, b *float64, f float64) *float64 {
+	switch {
+	case a != nil && b != nil:
+		v := *a + f*(*b-*a)
+		return &v
+	case f < 0.5 && a != nil || b == nil:
+		return a
+	default:
+		return b
+	}
+}
+
+func round(v float64, places float64) float64 {
+	m := math.Pow(10, places)
+	return math.Round(v*m) / m
+}
+
+// Process updates the passes, it returns an overflight event for each pass which has ended.
+func (t *Tracker) Process(rpt *model.Report) []event.Event {
+	now := rpt.Time()
+	var events []event.Event
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		if ac.Lat == nil || ac.Lon == nil {
+			continue
+		}
+		s := sample{t: now, lat: *ac.Lat, lon: *ac.Lon, gs: ac.GroundSpeed}
+		if alt, ok := ac.Altitude(); ok {
+			s.alt = &alt
+		}
+		tr := t.tracks[ac.Hex]
+		if tr == nil {
+			tr = &track{last: s, passes: map[string]*pass{}}
+			t.tracks[ac.Hex] = tr
+		}
+		// After a gap in the track there's no knowing where the aircraft went so don't interpolate across it.
+		from := tr.last
+		if s.t.Sub(from.t) > t.config.PassTimeout {
+			from = s
+		}
+		for _, p := range t.config.Points {
+			f, dist := approach(p, from, s)
+			ps := tr.passes[p.Name]
+			if dist <= t.config.MaxDistanceKm && (ps == nil || dist < ps.best.DistanceKm) {
+				if ps == nil {
+					ps = &pass{}
+					tr.passes[p.Name] = ps
+				}
+				ps.best = t.newPass(p, ac, from, s, f, dist)
+				ps.aircraft = *ac
+			}
+			if ps == nil {
+				continue
+			}
+			if _, current := approach(p, s, s); current > t.config.MaxDistanceKm {
+				events = append(events, t.end(ps, now))
+				delete(tr.passes, p.Name)
+			}
+		}
+		tr.last = s
+	}
+	for hex, tr := range t.tracks {
+		if now.Sub(tr.last.t) <= t.config.PassTimeout {
+			continue
+		}
+		for _, ps := range tr.passes {
+			events = append(events, t.end(ps, now))
+		}
+		delete(t.tracks, hex)
+	}
+	return events
+}
+
+func (t *Tracker) newPass(p Point, ac *model.Aircraft, from, to sample, f, dist float64) Pass {
+	ps := Pass{
+		Point:        p.Name,
+		Hex:          ac.Hex,
+		Time:         from.t.Add(time.Duration(f * float64(to.t.Sub(from.t)))),
+		DistanceKm:   round(dist, 3),
+		AltitudeFt:   interpolate(from.alt, to.alt, f),
+		SpeedKt:      interpolate(from.gs, to.gs, f),
+		Flight:       ac.Flight,
+		Registration: ac.Registration,
+		TypeCode:     ac.TypeCode,
+		Owner:        ac.Owner,
+	}
+	if ps.AltitudeFt != nil {
+		alt := math.Round(*ps.AltitudeFt)
+		ps.AltitudeFt = &alt
+		heightKm := (alt - p.ElevationFt) * geo.MetersPerFoot / 1000
+		slant := round(math.Hypot(dist, math.Max(0, heightKm)), 3)
+		ps.SlantRangeKm = &slant
+	}
+	if ps.SpeedKt != nil {
+		gs := round(*ps.SpeedKt, 1)
+		ps.SpeedKt = &gs
+	}
+	return ps
+}
+
+// end records the pass and returns its summary event. The event is timestamped when the pass ends so
+// events stay in order in Loki, the closest approach time is in the cpa_time field.
+func (t *Tracker) end(ps *pass, now time.Time) event.Event {
+	if err := t.store(ps.best); err != nil {
+		level.Error(t.logger).Log("msg", "failed to store overflight", "hex", ps.best.Hex, "point", ps.best.Point, "err", err)
+	}
+	b := ps.best
+	fields := map[string]string{
+		"point":       b.Point,
+		"cpa_time":    b.Time.UTC().Format(time.RFC3339Nano),
+		"distance_km": fmt.Sprint(b.DistanceKm),
+	}
+	msg := fmt.Sprintf("passed %s at %.2f km", b.Point, b.DistanceKm)
+	if b.SlantRangeKm != nil {
+		fields["slant_range_km"] = fmt.Sprint(*b.SlantRangeKm)
+		fields["altitude_ft"] = fmt.Sprint(*b.AltitudeFt)
+		msg += fmt.Sprintf(" and %.0f ft", *b.AltitudeFt)
+	}
+	if b.SpeedKt != nil {
+		fields["speed_kt"] = fmt.Sprint(*b.SpeedKt)
+	}
+	return event.Event{
+		Type:     event.Overflight,
+		Time:     now,
+		Hex:      b.Hex,
+		Name:     b.Point,
+		Message:  msg,
+		Fields:   fields,
+		Aircraft: &ps.aircraft,
+	}
+}
@@ -0,0 +1,122 @@
+package overflight
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func float64P(v float64) *float64 {
+	return &v
+}
+
+func stringP(v string) *string {
+	return &v
+}
+
+func Test_Tracker(t *testing.T) {
+	tr, err := New(log.NewNopLogger(), Config{
+		Points:        []Point{{Name: "home", Lat: 51, Lon: 0, ElevationFt: 100}},
+		BoltDbFile:    filepath.Join(t.TempDir(), "overflights.db"),
+		MaxDistanceKm: 5,
+		PassTimeout:   time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Stop()
+
+	// Flying east along a line 1 km north of the point, reporting every 40 seconds so the closest
+	// approach falls between two reports.
+	north := 51 + 1/kmPerDegree
+	start := 1600000000.0
+	var events []event.Event
+	for i := 0; i < 8; i++ {
+		lon := -0.1 + float64(i)*0.03
+		rpt := &model.Report{Now: start + float64(i)*40, Aircraft: []model.Aircraft{{
+			Hex:               "a00001",
+			Flight:            stringP("BAW1"),
+			Lat:               float64P(north),
+			Lon:               float64P(lon),
+			GroundSpeed:       float64P(200 + float64(i)),
+			BarometerAltitude: 3000 - float64(i)*100,
+			Details:           model.Details{Registration: stringP("G-ABCD")},
+		}}}
+		events = append(events, tr.Process(rpt)...)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected one overflight event, got %+v", events)
+	}
+	// The point is crossed a third of the way from the 4th to the 5th report.
+	passes, err := tr.Passes(time.Unix(int64(start), 0), time.Unix(int64(start)+3600, 0), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passes) != 1 {
+		t.Fatalf("expected one stored pass got %+v", passes)
+	}
+	p := passes[0]
+	expectedTime := time.Unix(int64(start)+120, 0).Add(40 * time.Second / 3)
+	if d := p.Time.Sub(expectedTime); d > time.Second || d < -time.Second {
+		t.Errorf("expected closest approach at %s got %s", expectedTime, p.Time)
+	}
+	if p.DistanceKm < 0.99 || p.DistanceKm > 1.01 {
+		t.Errorf("expected distance of 1 km got %v", p.DistanceKm)
+	}
+	if *p.AltitudeFt != 2667 || *p.SpeedKt != 203.3 || *p.Registration != "G-ABCD" || *p.Flight != "BAW1" {
+		t.Errorf("unexpected pass %+v", p)
+	}
+	if *p.SlantRangeKm != 1.27 {
+		t.Errorf("unexpected slant range %v", *p.SlantRangeKm)
+	}
+	if events[0].Type != event.Overflight || events[0].Name != "home" || events[0].Fields["cpa_time"] != p.Time.UTC().Format(time.RFC3339Nano) {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+
+	// An aircraft which never comes close enough has no pass, one which is lost ends its pass.
+	events = tr.Process(&model.Report{Now: start + 400, Aircraft: []model.Aircraft{
+		{Hex: "a00002", Lat: float64P(51.2), Lon: float64P(0)},
+		{Hex: "a00003", Lat: float64P(51.01), Lon: float64P(0.01)},
+	}})
+	events = append(events, tr.Process(&model.Report{Now: start + 500})...)
+	if len(events) != 1 || events[0].Hex != "a00003" {
+		t.Fatalf("expected the lost aircraft's pass to end, got %+v", events)
+	}
+
+	srv := httptest.NewServer(tr)
+	defer srv.Close()
+	day := time.Unix(int64(start), 0).Format(dateFormat)
+	for query, expected := range map[string]int{
+		"?from=" + day + "&to=" + day:                   2,
+		"?from=" + day + "&to=" + day + "&point=home":   2,
+		"?from=" + day + "&to=" + day + "&point=office": 0,
+		"?from=2000-01-01T00:00:00Z&to=2000-01-02":      0,
+	} {
+		resp, err := http.Get(srv.URL + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []Pass
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil || len(got) != expected {
+			t.Errorf("%s: expected %d passes got %d: %v", query, expected, len(got), err)
+		}
+	}
+	resp, err := http.Get(srv.URL + "?from=yesterday")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request for an invalid time, got %s", resp.Status)
+	}
+}
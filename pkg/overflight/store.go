@@ -0,0 +1,54 @@
+package overflight
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var passesBucket = []byte("passes")
+
+// passKey sorts passes by time, the point and hex keep passes at the same instant apart.
+func passKey(p Pass) []byte {
+	k := make([]byte, 8, 8+len(p.Point)+1+len(p.Hex))
+	binary.BigEndian.PutUint64(k, uint64(p.Time.UnixNano()))
+	k = append(k, p.Point...)
+	k = append(k, '/')
+	return append(k, p.Hex...)
+}
+
+func (t *Tracker) store(p Pass) error {
+	bts, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return t.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(passesBucket).Put(passKey(p), bts)
+	})
+}
+
+// Passes returns the passes with a closest approach in [from, to), all points are included if point is empty.
+func (t *Tracker) Passes(from, to time.Time, point string) ([]Pass, error) {
+	start := make([]byte, 8)
+	binary.BigEndian.PutUint64(start, uint64(from.UnixNano()))
+	end := make([]byte, 8)
+	binary.BigEndian.PutUint64(end, uint64(to.UnixNano()))
+	passes := []Pass{}
+	err := t.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(passesBucket).Cursor()
+		for k, v := c.Seek(start); k != nil && bytes.Compare(k[:8], end) < 0; k, v = c.Next() {
+			p := Pass{}
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if point == "" || p.Point == point {
+				passes = append(passes, p)
+			}
+		}
+		return nil
+	})
+	return passes, err
+}
@@ -1,49 +1,112 @@
 package piaware
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
-	"github.com/slim-bean/adsb-loki/pkg/aircraft"
-	"github.com/slim-bean/adsb-loki/pkg/model"
+	"flag"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/prometheus/common/config"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+	"github.com/slim-bean/adsb-loki/pkg/source"
 )
 
+// maxBodySize limits how much of a response is read, aircraft.json is rarely more than a few hundred KB.
+const maxBodySize = 32 << 20
+
+// Config is how often and how the receiver's aircraft.json is fetched. Basic auth, bearer tokens, TLS and
+// proxy options are the same as for the Loki clients.
+type Config struct {
+	source.Config `yaml:",inline"`
+	Timeout       time.Duration           `yaml:"timeout"`
+	Client        config.HTTPClientConfig `yaml:",inline"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	c.Config.RegisterFlagsWithPrefix("source", f)
+	f.DurationVar(&c.Timeout, "source.timeout", 5*time.Second, "Timeout for fetching a single report from the receiver")
+	c.Client = config.DefaultHTTPClientConfig
+}
+
 type Piaware struct {
-	url string
-	am  *aircraft.Manager
+	url     string
+	timeout time.Duration
+	client  *http.Client
+
+	// etag and lastModified are from the last response, so the next request is conditional.
+	etag         string
+	lastModified string
 }
 
-func New(am *aircraft.Manager, url string) *Piaware {
-	return &Piaware{
-		url: url,
-		am:  am,
+func New(url string, cfg Config) (*Piaware, error) {
+	client, err := config.NewClientFromConfig(cfg.Client, "adsb-source")
+	if err != nil {
+		return nil, fmt.Errorf("error creating http client: %s", err)
 	}
+	return &Piaware{
+		url:     url,
+		timeout: cfg.Timeout,
+		client:  client,
+	}, nil
 }
 
-func (p *Piaware) GetReport() (*model.Report, error) {
-	rpt, err := p.getReport()
+// GetReport fetches the current aircraft.json, enrichment is left to the caller.
+// source.ErrNotModified is returned if the receiver says it hasn't changed since the last call.
+func (p *Piaware) GetReport(ctx context.Context) (*model.Report, error) {
+	return p.getReport(ctx)
+}
+
+func (p *Piaware) getReport(ctx context.Context) (*model.Report, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
 	if err != nil {
 		return nil, err
 	}
-
-	for i, ac := range rpt.Aircraft {
-		details := p.am.Lookup(strings.ToLower(ac.Hex))
-		if details != nil {
-			rpt.Aircraft[i].Details = *details
-		}
+	// The transport doesn't decompress responses itself so gzip is asked for and handled here.
+	req.Header.Set("Accept-Encoding", "gzip")
+	if p.etag != "" {
+		req.Header.Set("If-None-Match", p.etag)
 	}
-
-	return rpt, nil
-}
-
-func (p *Piaware) getReport() (*model.Report, error) {
-	resp, err := http.Get(p.url)
+	if p.lastModified != "" {
+		req.Header.Set("If-Modified-Since", p.lastModified)
+	}
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, source.ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s fetching %s", resp.Status, p.url)
+	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	var r io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error decompressing report: %s", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxBodySize))
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +116,9 @@ func (p *Piaware) getReport() (*model.Report, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Only a complete report is used for the next conditional request.
+	p.etag = resp.Header.Get("ETag")
+	p.lastModified = resp.Header.Get("Last-Modified")
 
 	/*
 	 * Clean up the flight ID by removing leading and trailing spaces
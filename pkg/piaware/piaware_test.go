@@ -0,0 +1,100 @@
+package piaware
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/config"
+
+	"github.com/slim-bean/adsb-loki/pkg/source"
+)
+
+const aircraftJSON = `{"now":1700000000.5,"messages":10,"aircraft":[{"hex":"abc123","flight":"UAL1    "}]}`
+
+func defaults(t *testing.T) Config {
+	c := Config{}
+	fs := flag.NewFlagSet("test", flag.PanicOnError)
+	c.RegisterFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func Test_GetReport(t *testing.T) {
+	var requests []*http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if user, pass, ok := r.BasicAuth(); !ok || user != "adsb" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			gz.Write([]byte(aircraftJSON))
+			gz.Close()
+			return
+		}
+		w.Write([]byte(aircraftJSON))
+	}))
+	defer srv.Close()
+
+	c := defaults(t)
+	c.Client.BasicAuth = &config.BasicAuth{Username: "adsb", Password: "secret"}
+	p, err := New(srv.URL, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpt, err := p.GetReport(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rpt.Now != 1700000000.5 || len(rpt.Aircraft) != 1 || *rpt.Aircraft[0].Flight != "UAL1" {
+		t.Errorf("unexpected report %+v", rpt)
+	}
+	if _, err := p.GetReport(context.Background()); err != source.ErrNotModified {
+		t.Errorf("expected the second request to be not modified, got %v", err)
+	}
+	if len(requests) != 2 || requests[0].Header.Get("If-None-Match") != "" {
+		t.Errorf("expected only the second request to be conditional")
+	}
+}
+
+func Test_GetReportErrors(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status == 0 {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	c := defaults(t)
+	c.Timeout = 50 * time.Millisecond
+	p, err := New(srv.URL, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.GetReport(context.Background()); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected the status in the error, got %v", err)
+	}
+	status = 0
+	start := time.Now()
+	if _, err := p.GetReport(context.Background()); err == nil || time.Since(start) > 150*time.Millisecond {
+		t.Errorf("expected the request to time out, got %v after %s", err, time.Since(start))
+	}
+}
@@ -0,0 +1,211 @@
+package privacy
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/flagext"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Actions which can be taken for aircraft in a privacy program.
+const (
+	// Drop removes the aircraft entirely.
+	Drop = "drop"
+	// Redact removes the registration, owner, callsign and everything derived from the callsign.
+	Redact = "redact"
+	// Coarsen rounds the position to the grid.
+	Coarsen = "coarsen"
+	// Delay holds back each position until the delay has passed, the aircraft is then processed as if that
+	// position were current and the line records how old it is.
+	Delay = "delay"
+)
+
+// Config sets the actions for aircraft in the LADD and PIA programs, actions other than drop can be combined.
+type Config struct {
+	LADD        flagext.StringSliceCSV `yaml:"ladd"`
+	PIA         flagext.StringSliceCSV `yaml:"pia"`
+	GridDegrees float64                `yaml:"grid_degrees"`
+	Delay       time.Duration          `yaml:"delay"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Var(&c.LADD, "privacy.ladd", "Comma separated actions for aircraft in the LADD program, any of drop, redact, coarsen or delay")
+	f.Var(&c.PIA, "privacy.pia", "Comma separated actions for aircraft in the PIA program, any of drop, redact, coarsen or delay")
+	f.Float64Var(&c.GridDegrees, "privacy.grid-degrees", 0.1, "Size in degrees of the grid positions are rounded to by the coarsen action")
+	f.DurationVar(&c.Delay, "privacy.delay", 15*time.Minute, "How long the delay action holds back positions")
+}
+
+type policy struct {
+	drop, redact, coarsen, delay bool
+}
+
+func newPolicy(actions []string) (policy, error) {
+	p := policy{}
+	for _, a := range actions {
+		switch a {
+		case Drop:
+			p.drop = true
+		case Redact:
+			p.redact = true
+		case Coarsen:
+			p.coarsen = true
+		case Delay:
+			p.delay = true
+		default:
+			return p, fmt.Errorf("unknown privacy action %q", a)
+		}
+	}
+	if p.drop && len(actions) > 1 {
+		return p, fmt.Errorf("drop can't be combined with other privacy actions")
+	}
+	return p, nil
+}
+
+func (p policy) merge(o policy) policy {
+	return policy{
+		drop:    p.drop || o.drop,
+		redact:  p.redact || o.redact,
+		coarsen: p.coarsen || o.coarsen,
+		delay:   p.delay || o.delay,
+	}
+}
+
+type delayed struct {
+	t  time.Time
+	ac model.Aircraft
+}
+
+// Filter applies the privacy policies to each report.
+type Filter struct {
+	ladd, pia   policy
+	gridDegrees float64
+	delay       time.Duration
+	// held is the positions waiting out the delay for each hex, oldest first.
+	held map[string][]delayed
+}
+
+func New(config Config) (*Filter, error) {
+	ladd, err := newPolicy(config.LADD)
+	if err != nil {
+		return nil, fmt.Errorf("ladd: %s", err)
+	}
+	pia, err := newPolicy(config.PIA)
+	if err != nil {
+		return nil, fmt.Errorf("pia: %s", err)
+	}
+	if (ladd.coarsen || pia.coarsen) && config.GridDegrees <= 0 {
+		return nil, fmt.Errorf("grid degrees must be positive to coarsen positions")
+	}
+	return &Filter{
+		ladd:        ladd,
+		pia:         pia,
+		gridDegrees: config.GridDegrees,
+		delay:       config.Delay,
+		held:        map[string][]delayed{},
+	}, nil
+}
+
+func (f *Filter) policy(ac *model.Aircraft) policy {
+	p := policy{}
+	if ac.LADD != nil && *ac.LADD {
+		p = p.merge(f.ladd)
+	}
+	if ac.PIA != nil && *ac.PIA {
+		p = p.merge(f.pia)
+	}
+	return p
+}
+
+func (f *Filter) coarsen(v *float64) *float64 {
+	if v == nil {
+		return nil
+	}
+	c := math.Round(*v/f.gridDegrees) * f.gridDegrees
+	// Remove the floating point noise from the multiplication.
+	c = math.Round(c*1e6) / 1e6
+	return &c
+}
+
+// Apply filters the report in place, it must run after the aircraft have been enriched with their details
+// and before anything else sees the report.
+func (f *Filter) Apply(rpt *model.Report) {
+	now := rpt.Time()
+	kept := rpt.Aircraft[:0]
+	for _, ac := range rpt.Aircraft {
+		p := f.policy(&ac)
+		if p.drop {
+			continue
+		}
+		if p.redact {
+			ac.Registration, ac.Owner, ac.Flight = nil, nil, nil
+			ac.CallsignType, ac.Operator, ac.Route = nil, nil, nil
+		}
+		if p.coarsen {
+			ac.Lat, ac.Lon = f.coarsen(ac.Lat), f.coarsen(ac.Lon)
+		}
+		if p.delay {
+			f.held[ac.Hex] = append(f.held[ac.Hex], delayed{t: now, ac: ac})
+			continue
+		}
+		kept = append(kept, ac)
+	}
+	rpt.Aircraft = kept
+
+	// Release the newest position of each held aircraft which has waited out the delay.
+	for hex, held := range f.held {
+		i := 0
+		for i < len(held) && now.Sub(held[i].t) >= f.delay {
+			i++
+		}
+		if i == 0 {
+			continue
+		}
+		ac := held[i-1].ac
+		age := math.Round(now.Sub(held[i-1].t).Seconds())
+		ac.DelayedSeconds = &age
+		rpt.Aircraft = append(rpt.Aircraft, ac)
+		if i == len(held) {
+			delete(f.held, hex)
+		} else {
+			f.held[hex] = held[i:]
+		}
+	}
+}
+
+// Flush releases every held position which has waited out the delay by until, each in a report at the time it
+// was due as if reports had kept arriving, oldest first. Positions which aren't due yet stay held.
+// It's for the end of a run of reports, such as an import, so the last positions aren't lost.
+func (f *Filter) Flush(until time.Time) []*model.Report {
+	byDue := map[time.Time]*model.Report{}
+	for hex, held := range f.held {
+		i := 0
+		for ; i < len(held) && until.Sub(held[i].t) >= f.delay; i++ {
+			due := held[i].t.Add(f.delay)
+			rpt, ok := byDue[due]
+			if !ok {
+				rpt = &model.Report{Now: float64(due.UnixNano()) / 1e9}
+				byDue[due] = rpt
+			}
+			ac := held[i].ac
+			age := math.Round(f.delay.Seconds())
+			ac.DelayedSeconds = &age
+			rpt.Aircraft = append(rpt.Aircraft, ac)
+		}
+		if i == len(held) {
+			delete(f.held, hex)
+		} else {
+			f.held[hex] = held[i:]
+		}
+	}
+	rpts := make([]*model.Report, 0, len(byDue))
+	for _, rpt := range byDue {
+		rpts = append(rpts, rpt)
+	}
+	sort.Slice(rpts, func(i, j int) bool { return rpts[i].Now < rpts[j].Now })
+	return rpts
+}
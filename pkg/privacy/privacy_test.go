@@ -0,0 +1,111 @@
+package privacy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func stringP(v string) *string {
+	return &v
+}
+
+func float64P(v float64) *float64 {
+	return &v
+}
+
+func boolP(v bool) *bool {
+	return &v
+}
+
+func Test_Filter(t *testing.T) {
+	f, err := New(Config{
+		LADD:        []string{Redact, Coarsen},
+		PIA:         []string{Drop},
+		GridDegrees: 0.1,
+		Delay:       time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpt := &model.Report{Now: 1600000000, Aircraft: []model.Aircraft{
+		{Hex: "a00001", Flight: stringP("N1"), Lat: float64P(51.4712), Lon: float64P(-0.4543), Details: model.Details{Registration: stringP("N1"), Owner: stringP("Someone"), LADD: boolP(true)}},
+		{Hex: "a00002", Details: model.Details{PIA: boolP(true)}},
+		{Hex: "a00003", Flight: stringP("BAW1"), Lat: float64P(51.4712), Details: model.Details{Registration: stringP("G-ABCD"), LADD: boolP(false)}},
+	}}
+	f.Apply(rpt)
+	if len(rpt.Aircraft) != 2 {
+		t.Fatalf("expected the PIA aircraft to be dropped, got %+v", rpt.Aircraft)
+	}
+	ladd := rpt.Aircraft[0]
+	if ladd.Registration != nil || ladd.Owner != nil || ladd.Flight != nil || *ladd.Lat != 51.5 || *ladd.Lon != -0.5 {
+		t.Fatalf("expected LADD aircraft to be redacted and coarsened, got %+v", ladd)
+	}
+	if other := rpt.Aircraft[1]; *other.Registration != "G-ABCD" || *other.Lat != 51.4712 {
+		t.Fatalf("expected other aircraft to be unchanged, got %+v", other)
+	}
+
+	for _, c := range []Config{{LADD: []string{"hide"}}, {PIA: []string{Drop, Redact}}, {LADD: []string{Coarsen}}} {
+		if _, err := New(c); err == nil {
+			t.Errorf("expected invalid config %+v to be rejected", c)
+		}
+	}
+}
+
+func Test_Delay(t *testing.T) {
+	f, err := New(Config{LADD: []string{Delay}, Delay: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := func(now float64, lat ...float64) *model.Report {
+		rpt := &model.Report{Now: now}
+		for _, l := range lat {
+			rpt.Aircraft = append(rpt.Aircraft, model.Aircraft{Hex: "a00001", Lat: float64P(l), Details: model.Details{LADD: boolP(true)}})
+		}
+		f.Apply(rpt)
+		return rpt
+	}
+	for i := 0; i < 3; i++ {
+		if rpt := report(1600000000+float64(i)*20, float64(i)); len(rpt.Aircraft) != 0 {
+			t.Fatalf("%d: expected aircraft to be held back, got %+v", i, rpt.Aircraft)
+		}
+	}
+	// Released one minute late, and after a pause only the newest due position is released.
+	rpt := report(1600000060, 3)
+	if len(rpt.Aircraft) != 1 || *rpt.Aircraft[0].Lat != 0 || *rpt.Aircraft[0].DelayedSeconds != 60 {
+		t.Fatalf("expected first position to be released, got %+v", rpt.Aircraft)
+	}
+	rpt = report(1600000125)
+	if len(rpt.Aircraft) != 1 || *rpt.Aircraft[0].Lat != 3 || *rpt.Aircraft[0].DelayedSeconds != 65 {
+		t.Fatalf("expected last position to be released, got %+v", rpt.Aircraft)
+	}
+	if rpt := report(1600000200); len(rpt.Aircraft) != 0 || len(f.held) != 0 {
+		t.Fatalf("expected nothing left to release, got %+v", rpt.Aircraft)
+	}
+}
+
+func Test_Flush(t *testing.T) {
+	f, err := New(Config{PIA: []string{Delay}, Delay: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, hex := range []string{"a00001", "a00002", "a00001"} {
+		rpt := &model.Report{Now: 1600000000 + float64(i)*30, Aircraft: []model.Aircraft{{Hex: hex, Details: model.Details{PIA: boolP(true)}}}}
+		f.Apply(rpt)
+	}
+	// The first position was released by the last Apply, the last one isn't due until 1600000120 so it stays held.
+	rpts := f.Flush(time.Unix(1600000100, 0))
+	if len(rpts) != 1 || rpts[0].Now != 1600000090 {
+		t.Fatalf("expected a report at the due time, got %+v", rpts)
+	}
+	if ac := rpts[0].Aircraft; len(ac) != 1 || ac[0].Hex != "a00002" || *ac[0].DelayedSeconds != 60 {
+		t.Fatalf("unexpected release %+v", ac)
+	}
+	if len(f.held) != 1 || len(f.held["a00001"]) != 1 {
+		t.Fatalf("expected only the position which isn't due to be held, got %+v", f.held)
+	}
+	if rpts := f.Flush(time.Unix(1600000120, 0)); len(rpts) != 1 || len(f.held) != 0 {
+		t.Fatalf("expected the last position to be released, got %+v", rpts)
+	}
+}
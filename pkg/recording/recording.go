@@ -0,0 +1,116 @@
+package recording
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+type Config struct {
+	Dir         string        `yaml:"dir"`
+	Rotate      time.Duration `yaml:"rotate"`
+	Replay      string        `yaml:"replay"`
+	ReplaySpeed float64       `yaml:"replay_speed"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.Dir, "recording.dir", "", "Directory to record every fetched report to, recording is disabled if empty")
+	f.DurationVar(&c.Rotate, "recording.rotate", time.Hour, "How long each recording file covers before a new one is started")
+	f.StringVar(&c.Replay, "recording.replay", "", "Recording file, directory or glob to replay instead of fetching reports from adsb-url")
+	f.Float64Var(&c.ReplaySpeed, "recording.replay-speed", 1, "Speed to replay at, 1 is real time, 0 is as fast as possible keeping the original timestamps")
+}
+
+// record is one line of a recording file.
+type record struct {
+	Time   time.Time     `json:"time"`
+	Report *model.Report `json:"report"`
+}
+
+// Recorder writes every report to gzipped JSON lines files, starting a new file every rotate period.
+// Each report is flushed as it is written so a crash loses at most the gzip footer.
+type Recorder struct {
+	logger  log.Logger
+	config  Config
+	file    *os.File
+	gz      *gzip.Writer
+	w       *bufio.Writer
+	started time.Time
+}
+
+func NewRecorder(logger log.Logger, config Config) (*Recorder, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating recording directory: %s", err)
+	}
+	return &Recorder{
+		logger: log.With(logger, "component", "recorder"),
+		config: config,
+	}, nil
+}
+
+// Record writes the report as fetched at t, it must be called before the report is enriched.
+func (r *Recorder) Record(t time.Time, rpt *model.Report) {
+	if err := r.record(t, rpt); err != nil {
+		level.Error(r.logger).Log("msg", "failed to record report", "err", err)
+	}
+}
+
+func (r *Recorder) record(t time.Time, rpt *model.Report) error {
+	if r.file == nil || r.config.Rotate > 0 && t.Sub(r.started) >= r.config.Rotate {
+		if err := r.close(); err != nil {
+			level.Error(r.logger).Log("msg", "failed to close recording", "err", err)
+		}
+		name := filepath.Join(r.config.Dir, fmt.Sprintf("adsb-%s.jsonl.gz", t.UTC().Format("20060102T150405Z")))
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		r.file, r.gz, r.started = f, gzip.NewWriter(f), t
+		r.w = bufio.NewWriter(r.gz)
+		level.Info(r.logger).Log("msg", "started recording", "file", name)
+	}
+	bts, err := json.Marshal(record{Time: t, Report: rpt})
+	if err != nil {
+		return err
+	}
+	if _, err := r.w.Write(append(bts, '\n')); err != nil {
+		return err
+	}
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	return r.gz.Flush()
+}
+
+func (r *Recorder) close() error {
+	if r.file == nil {
+		return nil
+	}
+	defer func() {
+		r.file, r.gz, r.w = nil, nil, nil
+	}()
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	if err := r.gz.Close(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+func (r *Recorder) Stop() {
+	if err := r.close(); err != nil {
+		level.Error(r.logger).Log("msg", "failed to close recording", "err", err)
+	}
+}
@@ -0,0 +1,112 @@
+package recording
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func writeRecording(t *testing.T, config Config, start time.Time, n int) {
+	r, err := NewRecorder(log.NewNopLogger(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		r.Record(at, &model.Report{Now: float64(at.Unix()), Aircraft: []model.Aircraft{{Hex: "a00001"}}})
+	}
+	r.Stop()
+}
+
+func replayAll(t *testing.T, config Config) []*model.Report {
+	r, err := NewReplay(log.NewNopLogger(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	var rpts []*model.Report
+	for {
+		rpt, err := r.Next(context.Background())
+		if err == io.EOF {
+			return rpts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rpts = append(rpts, rpt)
+	}
+}
+
+func Test_RecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Unix(1600000000, 0)
+	writeRecording(t, Config{Dir: dir, Rotate: 1500 * time.Millisecond}, start, 4)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	if len(files) != 2 {
+		t.Fatalf("expected the recording to rotate into 2 files, got %d", len(files))
+	}
+
+	rpts := replayAll(t, Config{Replay: dir})
+	if len(rpts) != 4 {
+		t.Fatalf("expected 4 reports, got %d", len(rpts))
+	}
+	for i, rpt := range rpts {
+		if rpt.Now != float64(start.Unix()+int64(i)) || rpt.Aircraft[0].Hex != "a00001" {
+			t.Errorf("%d: expected the original report in order, got %+v", i, rpt)
+		}
+	}
+
+	// 3 seconds of reports at 60x takes 50ms and they are stamped with the time they are replayed.
+	before := time.Now()
+	rpts = replayAll(t, Config{Replay: filepath.Join(dir, "*.jsonl.gz"), ReplaySpeed: 60})
+	if elapsed := time.Since(before); elapsed < 45*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected replay to take about 50ms, took %s", elapsed)
+	}
+	if len(rpts) != 4 || rpts[0].Time().Before(before) || !rpts[3].Time().After(rpts[0].Time()) {
+		t.Errorf("expected reports stamped with the replay time")
+	}
+
+	// Cancelling stops a replay waiting for the next report.
+	r, err := NewReplay(log.NewNopLogger(), Config{Replay: dir, ReplaySpeed: 0.001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := r.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := r.Next(ctx); err != context.Canceled {
+		t.Errorf("expected replay to be cancelled, got %v", err)
+	}
+}
+
+func Test_ReplayTruncated(t *testing.T) {
+	dir := t.TempDir()
+	writeRecording(t, Config{Dir: dir}, time.Unix(1600000000, 0), 3)
+	files, _ := filepath.Glob(filepath.Join(dir, "*.jsonl.gz"))
+	fi, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Losing the gzip footer and part of the last report is what a crash while recording looks like.
+	if err := os.Truncate(files[0], fi.Size()-20); err != nil {
+		t.Fatal(err)
+	}
+	if rpts := replayAll(t, Config{Replay: files[0]}); len(rpts) != 2 {
+		t.Errorf("expected the complete reports from a truncated recording, got %d", len(rpts))
+	}
+
+	if _, err := NewReplay(log.NewNopLogger(), Config{Replay: filepath.Join(dir, "missing*")}); err == nil {
+		t.Error("expected an error when there is nothing to replay")
+	}
+}
@@ -0,0 +1,151 @@
+package recording
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Replay is a source.Source which plays back recordings in file name order, which is the order they were recorded.
+// At a positive speed reports are paced by their recorded times and stamped with the time they are replayed,
+// at speed 0 they are returned as fast as they are asked for with their original timestamps.
+type Replay struct {
+	logger log.Logger
+	speed  float64
+	files  []string
+
+	file *os.File
+	gz   *gzip.Reader
+	r    *bufio.Reader
+
+	// first is the recorded time of the first report and when it was replayed, later reports are due relative to it.
+	first, started time.Time
+}
+
+func NewReplay(logger log.Logger, config Config) (*Replay, error) {
+	files, err := ReplayFiles(config.Replay)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recordings found matching %s", config.Replay)
+	}
+	if config.ReplaySpeed < 0 {
+		return nil, fmt.Errorf("replay speed can't be negative")
+	}
+	return &Replay{
+		logger: log.With(logger, "component", "replay"),
+		speed:  config.ReplaySpeed,
+		files:  files,
+	}, nil
+}
+
+// ReplayFiles expands a file, directory of recordings or glob into the files which are replayed, in order.
+func ReplayFiles(path string) ([]string, error) {
+	if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+		path = filepath.Join(path, "*.jsonl.gz")
+	}
+	files, err := filepath.Glob(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid replay path: %s", err)
+	}
+	sort.Slice(files, func(i, j int) bool { return filepath.Base(files[i]) < filepath.Base(files[j]) })
+	return files, nil
+}
+
+func (r *Replay) Next(ctx context.Context) (*model.Report, error) {
+	rec, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+	if r.speed == 0 {
+		return rec.Report, nil
+	}
+	if r.started.IsZero() {
+		r.first, r.started = rec.Time, time.Now()
+	}
+	due := r.started.Add(time.Duration(float64(rec.Time.Sub(r.first)) / r.speed))
+	if wait := time.Until(due); wait > 0 {
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+	now := time.Now()
+	rec.Report.Now = float64(now.UnixNano()) / 1e9
+	return rec.Report, nil
+}
+
+// read returns the next record, moving on to the next file at the end of each one.
+// A truncated file, e.g. from a crash while recording, is read up to its last complete report.
+func (r *Replay) read() (*record, error) {
+	for {
+		if r.r == nil {
+			if len(r.files) == 0 {
+				return nil, io.EOF
+			}
+			if err := r.open(r.files[0]); err != nil {
+				level.Error(r.logger).Log("msg", "skipping recording", "file", r.files[0], "err", err)
+				r.files = r.files[1:]
+				continue
+			}
+			r.files = r.files[1:]
+		}
+		line, err := r.r.ReadBytes('\n')
+		if err == nil {
+			rec := &record{}
+			if err := json.Unmarshal(line, rec); err != nil || rec.Report == nil {
+				level.Warn(r.logger).Log("msg", "skipping invalid record", "file", r.file.Name(), "err", err)
+				continue
+			}
+			return rec, nil
+		}
+		if err != io.EOF {
+			level.Warn(r.logger).Log("msg", "recording ended unexpectedly", "file", r.file.Name(), "err", err)
+		}
+		r.close()
+	}
+}
+
+func (r *Replay) open(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	level.Info(r.logger).Log("msg", "replaying recording", "file", name)
+	r.file, r.gz, r.r = f, gz, bufio.NewReader(gz)
+	return nil
+}
+
+func (r *Replay) close() {
+	if r.file == nil {
+		return
+	}
+	r.gz.Close()
+	r.file.Close()
+	r.file, r.gz, r.r = nil, nil, nil
+}
+
+func (r *Replay) Stop() {
+	r.close()
+}
@@ -2,10 +2,10 @@ package registration
 
 import (
 	"archive/zip"
+	"context"
 	"flag"
 	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
 	"path"
 	"strings"
@@ -16,11 +16,13 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/gocarina/gocsv"
+
+	"github.com/slim-bean/adsb-loki/pkg/download"
 )
 
 const (
-	regfile     = "ReleasableAircraft.zip"
-	tempRegfile = "ReleasableAircraft.zip.tmp"
+	regfile    = "ReleasableAircraft.zip"
+	masterFile = "MASTER.txt"
 )
 
 //N-NUMBER,SERIAL NUMBER,MFR MDL CODE,ENG MFR MDL,YEAR MFR,TYPE REGISTRANT,NAME,STREET,STREET2,CITY,STATE,ZIP CODE,REGION,COUNTY,COUNTRY,LAST ACTION DATE,CERT ISSUE DATE,CERTIFICATION,TYPE AIRCRAFT,TYPE ENGINE,STATUS CODE,MODE S CODE,FRACT OWNER,AIR WORTH DATE,OTHER NAMES(1),OTHER NAMES(2),OTHER NAMES(3),OTHER NAMES(4),OTHER NAMES(5),EXPIRATION DATE,UNIQUE ID,KIT MFR, KIT MODEL,MODE S CODE HEX,
@@ -69,6 +71,8 @@ type DetailLookup interface {
 type RegManagerConfig struct {
 	Directory string `yaml:"directory"`
 	URL       string `yaml:"url"`
+
+	Download download.Config `yaml:"download,omitempty"`
 }
 
 func (c *RegManagerConfig) RegisterFlags(f *flag.FlagSet) {
@@ -78,11 +82,13 @@ func (c *RegManagerConfig) RegisterFlags(f *flag.FlagSet) {
 	}
 	f.StringVar(&c.Directory, "reg-manager.directory", path, "Where to save the downloaded registration zip file, defaults to the current working directory")
 	f.StringVar(&c.URL, "req-manager.url", "http://registry.faa.gov/database/ReleasableAircraft.zip", "Where to get aircraft information")
+	c.Download.RegisterFlagsWithPrefix("reg-manager", f)
 }
 
 type manager struct {
 	logger     log.Logger
 	config     RegManagerConfig
+	downloader *download.Downloader
 	deteMap    map[string]*Detail
 	deteMapMtx sync.Mutex
 	shutdown   chan struct{}
@@ -94,6 +100,7 @@ func NewManager(logger log.Logger, config RegManagerConfig) (*manager, error) {
 		logger: log.With(logger, "component", "manager"),
 		config: config,
 	}
+	m.downloader = download.New(m.logger, config.Download, config.URL, path.Join(config.Directory, regfile), download.ValidateZip(masterFile))
 
 	gocsv.SetCSVReader(func(in io.Reader) gocsv.CSVReader {
 		return gocsv.LazyCSVReader(in) // Allows use of quotes in CSV
@@ -141,52 +148,12 @@ func (m *manager) Stop() {
 }
 
 func (m *manager) checkAndUpdateRegistrationFile() bool {
-	fi, err := os.Stat(path.Join(m.config.Directory, regfile))
-	if err == nil {
-		if time.Since(fi.ModTime()) < 24*time.Hour {
-			return false
-		}
-	} else if !os.IsNotExist(err) {
-		level.Error(m.logger).Log("msg", "failed to stat registration file, cannot update", "err", err)
-		return false
-	}
-
-	// File does not exist or it's more than 24 hours old.
-	level.Info(m.logger).Log("msg", "downloading new registration file")
-
-	// Get the data
-	resp, err := http.Get(m.config.URL)
-	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to download new registration file", "url", m.config.URL, "err", err)
-		return false
-	}
-	defer resp.Body.Close()
-
-	// Create the file
-	out, err := os.Create(path.Join(m.config.Directory, tempRegfile))
+	updated, err := m.downloader.Update(context.Background())
 	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to create temp registration file", "err", err)
+		level.Error(m.logger).Log("msg", "failed to update registration file", "url", m.config.URL, "err", err)
 		return false
 	}
-	defer out.Close()
-	defer os.Remove(out.Name())
-
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to copy file to temp file", "err", err)
-		return false
-	}
-
-	err = os.Rename(path.Join(m.config.Directory, tempRegfile), path.Join(m.config.Directory, regfile))
-	if err != nil {
-		level.Error(m.logger).Log("msg", "failed to rename temp registration file to file", "err", err)
-		return false
-	}
-
-	level.Info(m.logger).Log("msg", "new registration file downloaded and replaced existing file")
-
-	return true
+	return updated
 }
 
 func (m *manager) loadRegistrationInfo() {
@@ -199,7 +166,7 @@ func (m *manager) loadRegistrationInfo() {
 
 	// Look through files until we find master file
 	for _, zipFile := range r.File {
-		if zipFile.Name != "MASTER.txt" {
+		if zipFile.Name != masterFile {
 			continue
 		}
 		level.Info(m.logger).Log("msg", "found MASTER.txt in downloaded zip file, updating aircraft details in memory")
@@ -0,0 +1,357 @@
+package route
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/slim-bean/adsb-loki/pkg/geo"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+var (
+	routesBucket   = []byte("routes")
+	airportsBucket = []byte("airports")
+	metaBucket     = []byte("meta")
+	sourceKey      = []byte("source")
+)
+
+type Config struct {
+	RoutesFile        string  `yaml:"routes_file"`
+	AirportsFile      string  `yaml:"airports_file"`
+	BoltDbFile        string  `yaml:"db_file"`
+	MaxOffTrackKm     float64 `yaml:"max_off_track_km"`
+	MaxTrackDeviation float64 `yaml:"max_track_deviation"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	path, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	f.StringVar(&c.RoutesFile, "routes.routes-file", "", "CSV file of callsign to route, e.g. the VRS standing data routes.csv, route lookups are disabled if empty")
+	f.StringVar(&c.AirportsFile, "routes.airports-file", "", "CSV file of airports with code, name and position, e.g. the VRS standing data airports.csv")
+	f.StringVar(&c.BoltDbFile, "routes.db-file", filepath.Join(path, "routes.db"), "Where to save the routes db, defaults to the current working directory ./routes.db")
+	f.Float64Var(&c.MaxOffTrackKm, "routes.max-off-track-km", 150, "How far in km an aircraft can be from the great circle path of its route and still be considered plausible")
+	f.Float64Var(&c.MaxTrackDeviation, "routes.max-track-deviation", 60, "How many degrees the aircraft's track can differ from the direction to the next airport and still be considered plausible")
+}
+
+// Provider resolves flight callsigns to routes stored in a local boltdb which is loaded from the configured CSV files.
+type Provider struct {
+	logger   log.Logger
+	config   Config
+	db       *bolt.DB
+	shutdown chan struct{}
+	done     chan struct{}
+}
+
+func New(logger log.Logger, config Config) (*Provider, error) {
+	db, err := bolt.Open(config.BoltDbFile, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening routes boltdb file: %s", err)
+	}
+	p := &Provider{
+		logger:   log.With(logger, "component", "routes"),
+		config:   config,
+		db:       db,
+		shutdown: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := p.importIfChanged(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	go p.run()
+	return p, nil
+}
+
+func (p *Provider) run() {
+	t := time.NewTicker(time.Minute)
+	defer func() {
+		t.Stop()
+		close(p.done)
+	}()
+	for {
+		select {
+		case <-p.shutdown:
+			return
+		case <-t.C:
+			if err := p.importIfChanged(); err != nil {
+				level.Error(p.logger).Log("msg", "failed to import routes, keeping previous routes", "err", err)
+			}
+		}
+	}
+}
+
+func (p *Provider) Stop() {
+	close(p.shutdown)
+	<-p.done
+	p.db.Close()
+}
+
+// source identifies the versions of the input files so they are only imported when they change.
+func (p *Provider) source() (string, error) {
+	parts := []string{}
+	for _, f := range []string{p.config.RoutesFile, p.config.AirportsFile} {
+		if f == "" {
+			continue
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", f, fi.Size(), fi.ModTime().UnixNano()))
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+func (p *Provider) importIfChanged() error {
+	src, err := p.source()
+	if err != nil {
+		return fmt.Errorf("error checking route files: %s", err)
+	}
+	unchanged := false
+	err = p.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(metaBucket); b != nil {
+			unchanged = string(b.Get(sourceKey)) == src
+		}
+		return nil
+	})
+	if err != nil || unchanged {
+		return err
+	}
+
+	airports := map[string]model.Airport{}
+	if p.config.AirportsFile != "" {
+		err = readCSV(p.config.AirportsFile, func(rec []string, cols map[string]int) {
+			lat, errLat := strconv.ParseFloat(get(rec, cols, "latitude", "lat"), 64)
+			lon, errLon := strconv.ParseFloat(get(rec, cols, "longitude", "lon", "lng"), 64)
+			if errLat != nil || errLon != nil {
+				return
+			}
+			a := model.Airport{
+				ICAO:    strings.ToUpper(get(rec, cols, "icao")),
+				IATA:    strings.ToUpper(get(rec, cols, "iata")),
+				Name:    get(rec, cols, "name"),
+				Country: get(rec, cols, "countryiso2", "country"),
+				Lat:     lat,
+				Lon:     lon,
+			}
+			// Routes may refer to an airport by any of its codes.
+			for _, code := range []string{strings.ToUpper(get(rec, cols, "code")), a.ICAO, a.IATA} {
+				if code != "" {
+					airports[code] = a
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	routes := map[string][]string{}
+	err = readCSV(p.config.RoutesFile, func(rec []string, cols map[string]int) {
+		cs := strings.ToUpper(get(rec, cols, "callsign"))
+		if cs == "" {
+			return
+		}
+		var codes []string
+		if r := get(rec, cols, "airportcodes", "route", "airports"); r != "" {
+			codes = strings.Split(strings.ToUpper(r), "-")
+		} else {
+			for _, c := range []string{get(rec, cols, "origin"), get(rec, cols, "destination")} {
+				if c != "" {
+					codes = append(codes, strings.ToUpper(c))
+				}
+			}
+		}
+		if len(codes) >= 2 {
+			routes[cs] = codes
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	err = p.db.Update(func(tx *bolt.Tx) error {
+		_ = tx.DeleteBucket(routesBucket)
+		_ = tx.DeleteBucket(airportsBucket)
+		rb, err := tx.CreateBucket(routesBucket)
+		if err != nil {
+			return err
+		}
+		ab, err := tx.CreateBucket(airportsBucket)
+		if err != nil {
+			return err
+		}
+		for cs, r := range routes {
+			if err := rb.Put([]byte(cs), []byte(strings.Join(r, "-"))); err != nil {
+				return err
+			}
+		}
+		for code, a := range airports {
+			bts, err := json.Marshal(a)
+			if err != nil {
+				return err
+			}
+			if err := ab.Put([]byte(code), bts); err != nil {
+				return err
+			}
+		}
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(sourceKey, []byte(src))
+	})
+	if err != nil {
+		return fmt.Errorf("error updating routes boltdb: %s", err)
+	}
+	level.Info(p.logger).Log("msg", "imported routes", "routes", len(routes), "airport_codes", len(airports))
+	return nil
+}
+
+func readCSV(file string, fn func(rec []string, cols map[string]int)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return fmt.Errorf("reading header of %s: %s", file, err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("parsing %s: %s", file, err)
+		}
+		fn(rec, cols)
+	}
+}
+
+// get returns the first of names which is a column in the record.
+func get(rec []string, cols map[string]int, names ...string) string {
+	for _, n := range names {
+		if i, ok := cols[n]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+	}
+	return ""
+}
+
+// Enrich adds the route of every aircraft with a known flight callsign, looking them all up in one transaction.
+func (p *Provider) Enrich(rpt *model.Report) {
+	err := p.db.View(func(tx *bolt.Tx) error {
+		rb, ab := tx.Bucket(routesBucket), tx.Bucket(airportsBucket)
+		if rb == nil || ab == nil {
+			return nil
+		}
+		airports := map[string]*model.Airport{}
+		airport := func(code string) (*model.Airport, error) {
+			if a, ok := airports[code]; ok {
+				return a, nil
+			}
+			var a *model.Airport
+			if v := ab.Get([]byte(code)); v != nil {
+				a = &model.Airport{}
+				if err := json.Unmarshal(v, a); err != nil {
+					return nil, err
+				}
+			}
+			airports[code] = a
+			return a, nil
+		}
+		for i := range rpt.Aircraft {
+			ac := &rpt.Aircraft[i]
+			if ac.Flight == nil || *ac.Flight == "" {
+				continue
+			}
+			v := rb.Get([]byte(strings.ToUpper(*ac.Flight)))
+			if v == nil {
+				continue
+			}
+			r := &model.Route{Airports: strings.Split(string(v), "-")}
+			stops := make([]*model.Airport, len(r.Airports))
+			for j, code := range r.Airports {
+				a, err := airport(code)
+				if err != nil {
+					return err
+				}
+				stops[j] = a
+			}
+			r.Origin, r.Destination = stops[0], stops[len(stops)-1]
+			p.checkPlausible(ac, r, stops)
+			ac.Route = r
+		}
+		return nil
+	})
+	if err != nil {
+		level.Error(p.logger).Log("msg", "failed to look up routes", "err", err)
+	}
+}
+
+// checkPlausible flags whether the aircraft's position and track fit any leg of the route.
+// Nothing is set if the position is unknown or any airport in the route is unknown.
+func (p *Provider) checkPlausible(ac *model.Aircraft, r *model.Route, stops []*model.Airport) {
+	if ac.Lat == nil || ac.Lon == nil {
+		return
+	}
+	for _, s := range stops {
+		if s == nil {
+			return
+		}
+	}
+	lat, lon := *ac.Lat, *ac.Lon
+	best := math.Inf(1)
+	plausible := false
+	for j := 0; j < len(stops)-1; j++ {
+		from, to := stops[j], stops[j+1]
+		legKm := geo.Distance(from.Lat, from.Lon, to.Lat, to.Lon)
+		xt, at := geo.CrossTrack(lat, lon, from.Lat, from.Lon, to.Lat, to.Lon)
+		off := math.Abs(xt)
+		// Beyond either end of the leg the distance to the nearest airport is what matters.
+		if at < 0 {
+			off = geo.Distance(lat, lon, from.Lat, from.Lon)
+		} else if at > legKm {
+			off = geo.Distance(lat, lon, to.Lat, to.Lon)
+		}
+		if off < best {
+			best = off
+		}
+		if off > p.config.MaxOffTrackKm {
+			continue
+		}
+		// Around the airports aircraft manoeuvre in every direction so only check the track en route.
+		nearAirport := geo.Distance(lat, lon, from.Lat, from.Lon) < p.config.MaxOffTrackKm ||
+			geo.Distance(lat, lon, to.Lat, to.Lon) < p.config.MaxOffTrackKm
+		if ac.Track == nil || nearAirport ||
+			geo.AngleDiff(*ac.Track, geo.Bearing(lat, lon, to.Lat, to.Lon)) <= p.config.MaxTrackDeviation {
+			plausible = true
+		}
+	}
+	best = math.Round(best*10) / 10
+	r.OffTrackKm = &best
+	r.Plausible = &plausible
+}
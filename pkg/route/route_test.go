@@ -0,0 +1,106 @@
+package route
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+const testAirports = `Code,Name,ICAO,IATA,Location,CountryISO2,Latitude,Longitude,AltitudeFeet
+EGLL,London Heathrow,EGLL,LHR,London,GB,51.4706,-0.461941,83
+KJFK,John F Kennedy International,KJFK,JFK,New York,US,40.6398,-73.7789,13
+BIKF,Keflavik International,BIKF,KEF,Reykjavik,IS,63.985,-22.6056,171
+`
+
+const testRoutes = `Callsign,Code,Number,AirlineCode,AirportCodes
+BAW117,BA,117,BAW,EGLL-KJFK
+ICE450,FI,450,ICE,EGLL-BIKF-KJFK
+XYZ1,XY,1,XYZ,EGLL-ZZZZ
+`
+
+func float64P(v float64) *float64 {
+	return &v
+}
+
+func stringP(v string) *string {
+	return &v
+}
+
+func newTestProvider(t *testing.T) *Provider {
+	dir := t.TempDir()
+	airports, routes := filepath.Join(dir, "airports.csv"), filepath.Join(dir, "routes.csv")
+	if err := ioutil.WriteFile(airports, []byte(testAirports), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(routes, []byte(testRoutes), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(log.NewNopLogger(), Config{
+		RoutesFile:        routes,
+		AirportsFile:      airports,
+		BoltDbFile:        filepath.Join(dir, "routes.db"),
+		MaxOffTrackKm:     150,
+		MaxTrackDeviation: 60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Stop)
+	return p
+}
+
+func Test_Enrich(t *testing.T) {
+	p := newTestProvider(t)
+	rpt := &model.Report{Aircraft: []model.Aircraft{
+		// Over the Atlantic on the great circle heading west.
+		{Hex: "400001", Flight: stringP("BAW117"), Lat: float64P(54.5), Lon: float64P(-30), Track: float64P(260)},
+		// Same position but heading back to London.
+		{Hex: "400002", Flight: stringP("BAW117"), Lat: float64P(54.5), Lon: float64P(-30), Track: float64P(90)},
+		// Over Paris.
+		{Hex: "400003", Flight: stringP("BAW117"), Lat: float64P(48.85), Lon: float64P(2.35), Track: float64P(260)},
+		// Near Iceland on the second leg of a multi stop route.
+		{Hex: "400004", Flight: stringP("ICE450"), Lat: float64P(61), Lon: float64P(-35)},
+		// Route with an unknown airport.
+		{Hex: "400005", Flight: stringP("XYZ1"), Lat: float64P(51), Lon: float64P(0)},
+		{Hex: "400006", Flight: stringP("NOROUTE")},
+	}}
+	p.Enrich(rpt)
+
+	expected := []struct {
+		origin, destination string
+		plausible           *bool
+	}{
+		{"EGLL", "KJFK", boolP(true)},
+		{"EGLL", "KJFK", boolP(false)},
+		{"EGLL", "KJFK", boolP(false)},
+		{"EGLL", "KJFK", boolP(true)},
+		{"EGLL", "", nil},
+	}
+	for i, e := range expected {
+		r := rpt.Aircraft[i].Route
+		if r == nil {
+			t.Fatalf("%d: expected a route", i)
+		}
+		dest := ""
+		if r.Destination != nil {
+			dest = r.Destination.ICAO
+		}
+		if r.Origin.ICAO != e.origin || dest != e.destination {
+			t.Errorf("%d: unexpected route %+v", i, r)
+		}
+		if (e.plausible == nil) != (r.Plausible == nil) || e.plausible != nil && *e.plausible != *r.Plausible {
+			t.Errorf("%d: expected plausible %v got %v (off track %v)", i, e.plausible, r.Plausible, r.OffTrackKm)
+		}
+	}
+	if rpt.Aircraft[5].Route != nil {
+		t.Errorf("expected no route for unknown callsign")
+	}
+}
+
+func boolP(v bool) *bool {
+	return &v
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/services"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Config struct {
+	HTTPListenAddress string `yaml:"http_listen_address"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.HTTPListenAddress, "server.http-listen-address", ":8090", "Address to serve metrics and the HTTP API on, the server is disabled if empty")
+}
+
+// Server serves /metrics and any handlers other components add to the Router.
+type Server struct {
+	services.Service
+	Router *mux.Router
+
+	logger   log.Logger
+	listener net.Listener
+	srv      *http.Server
+}
+
+// New listens on the configured address, requests are not served until the service is started.
+func New(logger log.Logger, config Config) (*Server, error) {
+	l, err := net.Listen("tcp", config.HTTPListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", config.HTTPListenAddress, err)
+	}
+	r := mux.NewRouter()
+	r.Handle("/metrics", promhttp.Handler())
+	s := &Server{
+		Router:   r,
+		logger:   log.With(logger, "component", "server"),
+		listener: l,
+		srv:      &http.Server{Handler: r},
+	}
+	s.Service = services.NewBasicService(nil, s.running, nil).WithName("server")
+	return s, nil
+}
+
+// Addr is the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// running serves requests until the service is stopped, then waits up to 10 seconds for in flight requests to finish.
+func (s *Server) running(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() {
+		level.Info(s.logger).Log("msg", "http server listening", "addr", s.listener.Addr())
+		errs <- s.srv.Serve(s.listener)
+	}()
+	select {
+	case err := <-errs:
+		return fmt.Errorf("http server failed: %s", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.srv.Shutdown(shutdownCtx); err != nil {
+		level.Warn(s.logger).Log("msg", "error shutting down http server", "err", err)
+	}
+	<-errs
+	return nil
+}
@@ -0,0 +1,283 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Extended squitter type codes.
+const (
+	tcSurfacePosition  = 6
+	tcAirbornePosition = 11
+	tcVelocity         = 19
+)
+
+const (
+	// nz is the number of latitude zones between the equator and a pole used by CPR encoding.
+	nz = 15
+	// cprMax is 2^17, the resolution of an encoded CPR coordinate.
+	cprMax = 131072
+
+	identChars = "#ABCDEFGHIJKLMNOPQRSTUVWXYZ##### ###############0123456789######"
+)
+
+// parity is the Mode S CRC of the message without its last 3 bytes, which is where it is stored.
+func parity(msg []byte) uint32 {
+	var crc uint32
+	for _, b := range msg[:len(msg)-3] {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1fff409
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// extendedSquitter builds a DF17 message from the 56 bits of ME.
+func extendedSquitter(hex string, me uint64) ([]byte, error) {
+	icao, err := strconv.ParseUint(hex, 16, 24)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex %q: %s", hex, err)
+	}
+	msg := make([]byte, 14)
+	// Capability 5 is a level 2 transponder which is airborne, the receiver doesn't care.
+	msg[0] = 17<<3 | 5
+	msg[1], msg[2], msg[3] = byte(icao>>16), byte(icao>>8), byte(icao)
+	for i := 0; i < 7; i++ {
+		msg[4+i] = byte(me >> uint(48-8*i))
+	}
+	p := parity(msg)
+	msg[11], msg[12], msg[13] = byte(p>>16), byte(p>>8), byte(p)
+	return msg, nil
+}
+
+// identification encodes the callsign and emitter category such as A3.
+func identification(hex, callsign, category string) ([]byte, error) {
+	tc, ca := uint64(4), uint64(0)
+	if len(category) == 2 && category[0] >= 'A' && category[0] <= 'D' && category[1] >= '0' && category[1] <= '7' {
+		tc, ca = uint64(4-(category[0]-'A')), uint64(category[1]-'0')
+	}
+	me := tc<<51 | ca<<48
+	cs := fmt.Sprintf("%-8s", strings.ToUpper(callsign))
+	for i := 0; i < 8; i++ {
+		c := strings.IndexByte(identChars, cs[i])
+		if c < 0 || cs[i] == '#' {
+			c = 32
+		}
+		me |= uint64(c) << uint(42-6*i)
+	}
+	return extendedSquitter(hex, me)
+}
+
+// nl is the number of longitude zones at a latitude.
+func nl(lat float64) int {
+	lat = math.Abs(lat)
+	switch {
+	case lat == 0:
+		return 59
+	case lat == 87:
+		return 2
+	case lat > 87:
+		return 1
+	}
+	a := 1 - math.Cos(math.Pi/(2*nz))
+	b := math.Pow(math.Cos(math.Pi/180*lat), 2)
+	return int(math.Floor(2 * math.Pi / math.Acos(1-a/b)))
+}
+
+func mod(x, y float64) float64 {
+	return x - y*math.Floor(x/y)
+}
+
+// cpr encodes a position into the even or odd compact position reporting format.
+// Surface positions use a quarter of the range so have four times the precision.
+func cpr(lat, lon float64, odd, surface bool) (uint64, uint64) {
+	i, span := 0.0, 360.0
+	if odd {
+		i = 1
+	}
+	if surface {
+		span = 90
+	}
+	dLat := span / (4*nz - i)
+	yz := math.Floor(cprMax*mod(lat, dLat)/dLat + 0.5)
+	rlat := dLat * (yz/cprMax + math.Floor(lat/dLat))
+	zones := float64(nl(rlat)) - i
+	if zones < 1 {
+		zones = 1
+	}
+	dLon := span / zones
+	xz := math.Floor(cprMax*mod(lon, dLon)/dLon + 0.5)
+	return uint64(yz) & (cprMax - 1), uint64(xz) & (cprMax - 1)
+}
+
+func boolBit(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// airbornePosition encodes a position with barometric altitude in 25ft steps.
+func airbornePosition(hex string, lat, lon, altFt float64, odd bool) ([]byte, error) {
+	n := uint64(math.Max(0, math.Min(2047, math.Round((altFt+1000)/25))))
+	// The Q bit is inserted between the 7th and 8th bits of the count.
+	alt := (n&0x7f0)<<1 | 0x10 | n&0xf
+	y, x := cpr(lat, lon, odd, false)
+	me := uint64(tcAirbornePosition)<<51 | alt<<36 | boolBit(odd)<<34 | y<<17 | x
+	return extendedSquitter(hex, me)
+}
+
+// movement quantises a ground speed in knots into the 7 bit surface movement field.
+func movement(kt float64) uint64 {
+	steps := []struct {
+		from, to, step float64
+		base           uint64
+	}{
+		{0.125, 1, 0.125, 2},
+		{1, 2, 0.25, 9},
+		{2, 15, 0.5, 13},
+		{15, 70, 1, 39},
+		{70, 100, 2, 94},
+		{100, 175, 5, 109},
+	}
+	if kt < 0.125 {
+		return 1
+	}
+	for _, s := range steps {
+		if kt < s.to {
+			return s.base + uint64((kt-s.from)/s.step)
+		}
+	}
+	return 124
+}
+
+func surfacePosition(hex string, lat, lon, gsKt, track float64, odd bool) ([]byte, error) {
+	trk := uint64(math.Round(mod(track, 360)*128/360)) & 0x7f
+	y, x := cpr(lat, lon, odd, true)
+	me := uint64(tcSurfacePosition)<<51 | movement(gsKt)<<44 | 1<<43 | trk<<36 | boolBit(odd)<<34 | y<<17 | x
+	return extendedSquitter(hex, me)
+}
+
+// velocity encodes the ground speed, track and barometric vertical rate of an airborne aircraft.
+func velocity(hex string, gsKt, track, vrFpm float64) ([]byte, error) {
+	// East and north are positive, the sign bits are set for west and south.
+	component := func(v float64) (sign, value uint64) {
+		return boolBit(v < 0), uint64(math.Min(1022, math.Round(math.Abs(v)))) + 1
+	}
+	rad := track * math.Pi / 180
+	dew, vew := component(gsKt * math.Sin(rad))
+	dns, vns := component(gsKt * math.Cos(rad))
+	svr, vr := boolBit(vrFpm < 0), uint64(math.Min(510, math.Round(math.Abs(vrFpm)/64)))+1
+	me := uint64(tcVelocity)<<51 | 1<<48 | dew<<42 | vew<<32 | dns<<31 | vns<<21 | 1<<20 | svr<<19 | vr<<10
+	return extendedSquitter(hex, me)
+}
+
+// messages returns the extended squitters a receiver would hear from the aircraft in one update.
+func (p *plane) messages(odd bool) ([][]byte, error) {
+	ident, err := identification(p.hex, p.flight, p.category)
+	if err != nil {
+		return nil, err
+	}
+	msgs := [][]byte{ident}
+	if p.ground() {
+		pos, err := surfacePosition(p.hex, p.lat, p.lon, p.gsKt, p.trackDg, odd)
+		if err != nil {
+			return nil, err
+		}
+		return append(msgs, pos), nil
+	}
+	pos, err := airbornePosition(p.hex, p.lat, p.lon, p.altFt, odd)
+	if err != nil {
+		return nil, err
+	}
+	vel, err := velocity(p.hex, p.gsKt, p.trackDg, p.vrFpm)
+	if err != nil {
+		return nil, err
+	}
+	return append(msgs, pos, vel), nil
+}
+
+// beastFrame wraps a long Mode S message in the Beast binary format with a 12MHz timestamp and signal level,
+// escaping any 0x1a in the payload.
+func beastFrame(t time.Time, signal byte, msg []byte) []byte {
+	ts := uint64(t.UnixNano()/1000*12) & 0xffffffffffff
+	payload := make([]byte, 0, 7+len(msg))
+	for i := 5; i >= 0; i-- {
+		payload = append(payload, byte(ts>>uint(8*i)))
+	}
+	payload = append(payload, signal)
+	payload = append(payload, msg...)
+	frame := []byte{0x1a, '3'}
+	for _, b := range payload {
+		if b == 0x1a {
+			frame = append(frame, 0x1a)
+		}
+		frame = append(frame, b)
+	}
+	return frame
+}
+
+// sbsLines formats the aircraft as BaseStation messages, the same as dump1090 writes on port 30003.
+func (p *plane) sbsLines(t time.Time) []string {
+	date, tod := t.UTC().Format("2006/01/02"), t.UTC().Format("15:04:05.000")
+	line := func(typ int, fields ...string) string {
+		all := append([]string{"MSG", strconv.Itoa(typ), "1", "1", strings.ToUpper(p.hex), "1", date, tod, date, tod}, fields...)
+		for len(all) < 22 {
+			all = append(all, "")
+		}
+		return strings.Join(all, ",")
+	}
+	f := func(v float64, prec int) string {
+		return strconv.FormatFloat(v, 'f', prec, 64)
+	}
+	flag := func(b bool) string {
+		if b {
+			return "-1"
+		}
+		return "0"
+	}
+	sq := p.currentSquawk()
+	_, emergency := emergencies[sq]
+	lines := []string{line(1, p.flight)}
+	if p.ground() {
+		lines = append(lines, line(2, "", "", f(p.gsKt, 0), f(p.trackDg, 0), f(p.lat, 5), f(p.lon, 5), "", "", "", "", "", flag(true)))
+	} else {
+		lines = append(lines,
+			line(3, "", f(math.Round(p.altFt/25)*25, 0), "", "", f(p.lat, 5), f(p.lon, 5), "", "", flag(emergency), flag(emergency), flag(false), flag(false)),
+			line(4, "", "", f(p.gsKt, 0), f(p.trackDg, 0), "", "", f(math.Round(p.vrFpm/64)*64, 0)),
+		)
+	}
+	return append(lines, line(6, "", "", "", "", "", "", "", sq, flag(emergency), flag(emergency), flag(false), flag(p.ground())))
+}
+
+// Streams returns what the receiver would send to Beast and SBS clients for the current state of every aircraft.
+// Successive calls alternate between even and odd positions so a decoder can resolve them.
+func (s *Simulator) Streams() (beast []byte, sbs []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.odd = !s.odd
+	var b, l bytes.Buffer
+	for _, p := range s.planes {
+		msgs, err := p.messages(s.odd)
+		if err != nil {
+			continue
+		}
+		signal := byte(s.between(40, 200))
+		for _, m := range msgs {
+			b.Write(beastFrame(s.now, signal, m))
+		}
+		for _, line := range p.sbsLines(s.now) {
+			l.WriteString(line)
+			l.WriteString("\r\n")
+		}
+	}
+	return b.Bytes(), l.Bytes()
+}
@@ -0,0 +1,221 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/mux"
+)
+
+// Server serves the simulator's aircraft.json the same as dump1090, with faults which can be injected over HTTP:
+//
+//  POST   /faults/stale                  freeze aircraft.json, including now
+//  DELETE /faults/stale
+//  POST   /faults/malformed?rate=0.5     cut short that fraction of responses
+//  POST   /faults/emergency?squawk=7700  make a random aircraft squawk an emergency, its hex is returned
+//  DELETE /faults/emergency
+type Server struct {
+	logger log.Logger
+	sim    *Simulator
+	router *mux.Router
+
+	mtx           sync.Mutex
+	rnd           *rand.Rand
+	frozen        []byte
+	malformedRate float64
+}
+
+func NewServer(logger log.Logger, sim *Simulator) *Server {
+	s := &Server{
+		logger: log.With(logger, "component", "server"),
+		sim:    sim,
+		router: mux.NewRouter(),
+		rnd:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, path := range []string{"/data/aircraft.json", "/aircraft.json"} {
+		s.router.HandleFunc(path, s.aircraft).Methods(http.MethodGet)
+	}
+	s.router.HandleFunc("/faults/stale", s.stale).Methods(http.MethodPost, http.MethodDelete)
+	s.router.HandleFunc("/faults/malformed", s.malformed).Methods(http.MethodPost, http.MethodDelete)
+	s.router.HandleFunc("/faults/emergency", s.emergency).Methods(http.MethodPost, http.MethodDelete)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// SetStale freezes aircraft.json as it is now, or unfreezes it.
+func (s *Server) SetStale(stale bool) error {
+	var frozen []byte
+	if stale {
+		var err error
+		if frozen, err = json.Marshal(s.sim.Report()); err != nil {
+			return err
+		}
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	// Staying stale keeps the original snapshot.
+	if !stale || s.frozen == nil {
+		s.frozen = frozen
+	}
+	return nil
+}
+
+// Stale is whether aircraft.json is frozen, nothing should be streamed while it is.
+func (s *Server) Stale() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.frozen != nil
+}
+
+// SetMalformedRate sets the fraction of aircraft.json responses which are cut short.
+func (s *Server) SetMalformedRate(rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("malformed rate must be between 0 and 1")
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.malformedRate = rate
+	return nil
+}
+
+func (s *Server) aircraft(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	bts, malformed := s.frozen, s.rnd.Float64() < s.malformedRate
+	s.mtx.Unlock()
+	if bts == nil {
+		var err error
+		if bts, err = json.Marshal(s.sim.Report()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if malformed {
+		bts = bts[:len(bts)/2]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bts)
+}
+
+func (s *Server) stale(w http.ResponseWriter, r *http.Request) {
+	if err := s.SetStale(r.Method == http.MethodPost); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	level.Info(s.logger).Log("msg", "stale fault", "enabled", r.Method == http.MethodPost)
+}
+
+func (s *Server) malformed(w http.ResponseWriter, r *http.Request) {
+	rate := 0.0
+	if r.Method == http.MethodPost {
+		rate = 1
+		if v := r.URL.Query().Get("rate"); v != "" {
+			var err error
+			if rate, err = strconv.ParseFloat(v, 64); err != nil {
+				http.Error(w, "invalid rate", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if err := s.SetMalformedRate(rate); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level.Info(s.logger).Log("msg", "malformed fault", "rate", rate)
+}
+
+func (s *Server) emergency(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		s.sim.ClearEmergencies()
+		level.Info(s.logger).Log("msg", "cleared emergencies")
+		return
+	}
+	squawk := r.URL.Query().Get("squawk")
+	if squawk == "" {
+		squawk = "7700"
+	}
+	hex, err := s.sim.Emergency(squawk)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level.Info(s.logger).Log("msg", "emergency fault", "hex", hex, "squawk", squawk)
+	fmt.Fprintln(w, hex)
+}
+
+// Stream sends the same data to every client connected to a TCP port, like dump1090's Beast and SBS outputs.
+type Stream struct {
+	logger log.Logger
+	ln     net.Listener
+	mtx    sync.Mutex
+	conns  map[net.Conn]struct{}
+	done   chan struct{}
+}
+
+func Listen(logger log.Logger, addr string) (*Stream, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", addr, err)
+	}
+	s := &Stream{
+		logger: log.With(logger, "listen_address", ln.Addr().String()),
+		ln:     ln,
+		conns:  map[net.Conn]struct{}{},
+		done:   make(chan struct{}),
+	}
+	go s.accept()
+	return s, nil
+}
+
+func (s *Stream) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *Stream) accept() {
+	defer close(s.done)
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		level.Info(s.logger).Log("msg", "client connected", "client", conn.RemoteAddr())
+		s.mtx.Lock()
+		s.conns[conn] = struct{}{}
+		s.mtx.Unlock()
+	}
+}
+
+// Send writes data to every client, a client which can't keep up is disconnected.
+func (s *Stream) Send(data []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for conn := range s.conns {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write(data); err != nil {
+			level.Info(s.logger).Log("msg", "client disconnected", "client", conn.RemoteAddr(), "err", err)
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+}
+
+func (s *Stream) Close() {
+	s.ln.Close()
+	<-s.done
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = map[net.Conn]struct{}{}
+}
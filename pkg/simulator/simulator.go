@@ -0,0 +1,357 @@
+package simulator
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/geo"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Phases of flight the simulated aircraft move through.
+const (
+	Climb    = "climb"
+	Cruise   = "cruise"
+	Approach = "approach"
+	Holding  = "holding"
+	Taxi     = "taxi"
+)
+
+var phases = []string{Climb, Cruise, Approach, Holding, Taxi}
+
+// emergencies maps the emergency squawks to the emergency field dump1090 reports for them.
+var emergencies = map[string]string{
+	"7500": "unlawful",
+	"7600": "nordo",
+	"7700": "general",
+}
+
+var airlines = []string{"AAL", "BAW", "DAL", "DLH", "SWA", "UAL"}
+
+type Config struct {
+	Lat      float64 `yaml:"lat"`
+	Lon      float64 `yaml:"lon"`
+	Aircraft int     `yaml:"aircraft"`
+	RangeKm  float64 `yaml:"range_km"`
+	Seed     int64   `yaml:"seed"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.Float64Var(&c.Lat, "receiver.lat", 39.8561, "Latitude of the simulated receiver, which is also the airport aircraft depart from and land at")
+	f.Float64Var(&c.Lon, "receiver.lon", -104.6737, "Longitude of the simulated receiver")
+	f.IntVar(&c.Aircraft, "aircraft", 20, "How many aircraft to simulate at once, an aircraft which leaves is replaced by a new one")
+	f.Float64Var(&c.RangeKm, "range-km", 250, "How far from the receiver aircraft are tracked")
+	f.Int64Var(&c.Seed, "seed", 0, "Random seed so runs can be repeated, 0 picks one from the time")
+}
+
+// plane is the state of one simulated aircraft.
+type plane struct {
+	hex      string
+	flight   string
+	squawk   string
+	category string
+	phase    string
+
+	lat, lon      float64
+	altFt, vrFpm  float64
+	gsKt, trackDg float64
+	targetAltFt   float64
+
+	// remaining is how long is left taxiing, or how much of the current holding leg or turn is left.
+	remaining time.Duration
+	turning   bool
+	// turns is how many more 180 degree turns to fly before leaving the hold, two for each lap.
+	turns int
+	// emergency holds the squawk set by an injected emergency so it survives phase changes.
+	emergency string
+}
+
+func (p *plane) ground() bool {
+	return p.phase == Taxi
+}
+
+// Simulator moves a set of aircraft around a receiver, replacing any which leave its range.
+// It is safe to use from multiple goroutines.
+type Simulator struct {
+	config Config
+	mtx    sync.Mutex
+	rnd    *rand.Rand
+	now    time.Time
+	planes []*plane
+	// messages counts the Mode S messages the aircraft would have sent, like the receiver's counter.
+	messages uint64
+	// odd is whether the last positions streamed were odd.
+	odd bool
+}
+
+// New creates a simulator at start with the configured number of aircraft spread across every phase of flight.
+func New(config Config, start time.Time) *Simulator {
+	seed := config.Seed
+	if seed == 0 {
+		seed = start.UnixNano()
+	}
+	s := &Simulator{
+		config: config,
+		rnd:    rand.New(rand.NewSource(seed)),
+		now:    start,
+	}
+	for i := 0; i < config.Aircraft; i++ {
+		s.planes = append(s.planes, s.spawn(phases[i%len(phases)]))
+	}
+	return s
+}
+
+func (s *Simulator) between(min, max float64) float64 {
+	return min + s.rnd.Float64()*(max-min)
+}
+
+func (s *Simulator) hex() string {
+	for {
+		h := fmt.Sprintf("%06x", s.rnd.Intn(1<<24))
+		unique := true
+		for _, p := range s.planes {
+			unique = unique && p.hex != h
+		}
+		if unique {
+			return h
+		}
+	}
+}
+
+// squawk returns a random code which isn't one of the emergency codes.
+func (s *Simulator) squawk() string {
+	for {
+		sq := fmt.Sprintf("%04o", s.rnd.Intn(010000))
+		if _, ok := emergencies[sq]; !ok {
+			return sq
+		}
+	}
+}
+
+func (s *Simulator) spawn(phase string) *plane {
+	p := &plane{
+		hex:      s.hex(),
+		flight:   fmt.Sprintf("%s%d", airlines[s.rnd.Intn(len(airlines))], 1+s.rnd.Intn(2999)),
+		squawk:   s.squawk(),
+		category: "A3",
+		phase:    phase,
+	}
+	rcvLat, rcvLon := s.config.Lat, s.config.Lon
+	switch phase {
+	case Taxi:
+		// Light aircraft which taxi about the airport before departing.
+		p.flight = fmt.Sprintf("N%d%c%c", 100+s.rnd.Intn(900), 'A'+s.rnd.Intn(26), 'A'+s.rnd.Intn(26))
+		p.category = "A1"
+		p.lat, p.lon = geo.Destination(rcvLat, rcvLon, s.between(0, 360), s.between(0, 2))
+		p.gsKt, p.trackDg = s.between(5, 20), s.between(0, 360)
+		p.remaining = time.Duration(s.between(60, 300)) * time.Second
+	case Climb:
+		p.lat, p.lon = geo.Destination(rcvLat, rcvLon, s.between(0, 360), s.between(0, 3))
+		p.altFt, p.vrFpm, p.gsKt, p.trackDg = 0, 2500, 150, s.between(0, 360)
+		p.targetAltFt = math.Round(s.between(250, 390)) * 100
+	case Cruise:
+		// Start at the edge of the range heading roughly across it.
+		from := s.between(0, 360)
+		p.lat, p.lon = geo.Destination(rcvLat, rcvLon, from, s.config.RangeKm*0.95)
+		p.trackDg = math.Mod(from+180+s.between(-45, 45)+360, 360)
+		p.altFt, p.gsKt = math.Round(s.between(250, 410))*100, s.between(420, 500)
+	case Approach:
+		p.lat, p.lon = geo.Destination(rcvLat, rcvLon, s.between(0, 360), s.config.RangeKm*0.6)
+		p.trackDg = geo.Bearing(p.lat, p.lon, rcvLat, rcvLon)
+		p.altFt, p.gsKt = math.Round(s.between(100, 150))*100, 250
+	case Holding:
+		p.lat, p.lon = geo.Destination(rcvLat, rcvLon, s.between(0, 360), s.between(20, 40))
+		p.altFt, p.gsKt, p.trackDg = math.Round(s.between(80, 140))*100, 220, s.between(0, 360)
+		p.turns = 2 * (2 + s.rnd.Intn(3))
+		p.remaining = time.Minute
+	}
+	return p
+}
+
+// Advance moves every aircraft on to t, aircraft which leave the receiver's range are replaced.
+func (s *Simulator) Advance(t time.Time) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	dt := t.Sub(s.now)
+	if dt <= 0 {
+		return
+	}
+	s.now = t
+	for i, p := range s.planes {
+		s.step(p, dt)
+		if geo.Distance(s.config.Lat, s.config.Lon, p.lat, p.lon) > s.config.RangeKm {
+			s.planes[i] = s.spawn(phases[s.rnd.Intn(len(phases))])
+		}
+		// Each aircraft sends a few messages a second.
+		s.messages += uint64(dt.Seconds() * 6)
+	}
+}
+
+// turnTowards turns the aircraft at the standard rate of 3 degrees a second towards a heading.
+func (p *plane) turnTowards(heading float64, dt time.Duration) {
+	diff := math.Mod(heading-p.trackDg+540, 360) - 180
+	max := 3 * dt.Seconds()
+	p.trackDg = math.Mod(p.trackDg+math.Max(-max, math.Min(max, diff))+360, 360)
+}
+
+func (p *plane) move(dt time.Duration) {
+	km := p.gsKt * geo.KmPerNauticalMile * dt.Hours()
+	p.lat, p.lon = geo.Destination(p.lat, p.lon, p.trackDg, km)
+}
+
+func (s *Simulator) step(p *plane, dt time.Duration) {
+	rcvLat, rcvLon := s.config.Lat, s.config.Lon
+	switch p.phase {
+	case Taxi:
+		p.remaining -= dt
+		if p.remaining <= 0 {
+			p.phase, p.vrFpm, p.gsKt = Climb, 1500, 90
+			p.targetAltFt = math.Round(s.between(45, 125)) * 100
+			break
+		}
+		// Wander about but stay on the airport.
+		if geo.Distance(rcvLat, rcvLon, p.lat, p.lon) > 2 {
+			p.turnTowards(geo.Bearing(p.lat, p.lon, rcvLat, rcvLon), dt)
+		} else if s.rnd.Float64() < dt.Seconds()/30 {
+			p.trackDg = math.Mod(p.trackDg+s.between(-90, 90)+360, 360)
+		}
+	case Climb:
+		p.altFt += p.vrFpm * dt.Minutes()
+		p.gsKt = math.Min(p.gsKt+2*dt.Seconds(), 450)
+		if p.altFt >= p.targetAltFt {
+			p.altFt, p.vrFpm, p.phase = p.targetAltFt, 0, Cruise
+		}
+	case Approach:
+		dist := geo.Distance(p.lat, p.lon, rcvLat, rcvLon)
+		if dist < 1 || p.altFt <= 0 {
+			p.phase, p.altFt, p.vrFpm, p.gsKt = Taxi, 0, 0, 15
+			p.remaining = time.Duration(s.between(60, 300)) * time.Second
+			break
+		}
+		p.turnTowards(geo.Bearing(p.lat, p.lon, rcvLat, rcvLon), dt)
+		if dist < 30 {
+			p.gsKt = math.Max(p.gsKt-dt.Seconds(), 140)
+		}
+		// Descend on a path which reaches the ground at the airport.
+		minutesToGo := dist / (p.gsKt * geo.KmPerNauticalMile) * 60
+		p.vrFpm = math.Max(-p.altFt/minutesToGo, -3000)
+		p.altFt = math.Max(p.altFt+p.vrFpm*dt.Minutes(), 0)
+	case Holding:
+		// A racetrack of one minute legs joined by 180 degree right turns.
+		p.remaining -= dt
+		if p.turning {
+			p.trackDg = math.Mod(p.trackDg+3*dt.Seconds(), 360)
+		}
+		if p.remaining <= 0 {
+			if p.turning {
+				p.turns--
+			}
+			p.turning = !p.turning
+			p.remaining = time.Minute
+		}
+		if p.turns <= 0 {
+			p.phase, p.turning = Approach, false
+		}
+	}
+	p.move(dt)
+}
+
+// Emergency makes a random airborne aircraft squawk the emergency code and returns its hex.
+func (s *Simulator) Emergency(squawk string) (string, error) {
+	if _, ok := emergencies[squawk]; !ok {
+		return "", fmt.Errorf("%s is not an emergency squawk", squawk)
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var airborne []*plane
+	for _, p := range s.planes {
+		if !p.ground() && p.emergency == "" {
+			airborne = append(airborne, p)
+		}
+	}
+	if len(airborne) == 0 {
+		return "", fmt.Errorf("no aircraft available for an emergency")
+	}
+	p := airborne[s.rnd.Intn(len(airborne))]
+	p.emergency = squawk
+	return p.hex, nil
+}
+
+// ClearEmergencies returns every aircraft to its normal squawk.
+func (s *Simulator) ClearEmergencies() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, p := range s.planes {
+		p.emergency = ""
+	}
+}
+
+func (p *plane) currentSquawk() string {
+	if p.emergency != "" {
+		return p.emergency
+	}
+	return p.squawk
+}
+
+func round(v, unit float64) *float64 {
+	r := math.Round(v/unit) * unit
+	// Remove the floating point noise from the multiplication.
+	r = math.Round(r*1e6) / 1e6
+	return &r
+}
+
+// Report returns the aircraft as dump1090 would write them to aircraft.json.
+func (s *Simulator) Report() *model.Report {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	rpt := &model.Report{
+		Now:      math.Round(float64(s.now.UnixNano())/1e8) / 10,
+		Messages: s.messages,
+		Aircraft: make([]model.Aircraft, 0, len(s.planes)),
+	}
+	for _, p := range s.planes {
+		// dump1090 pads callsigns to 8 characters.
+		flight := fmt.Sprintf("%-8s", p.flight)
+		sq, cat := p.currentSquawk(), p.category
+		emergency := "none"
+		if e, ok := emergencies[sq]; ok {
+			emergency = e
+		}
+		rssi := float32(-math.Round(s.between(3, 30)*10) / 10)
+		ac := model.Aircraft{
+			Hex:         p.hex,
+			Flight:      &flight,
+			Squawk:      &sq,
+			Emergency:   &emergency,
+			Category:    &cat,
+			Lat:         round(p.lat, 0.000001),
+			Lon:         round(p.lon, 0.000001),
+			GroundSpeed: round(p.gsKt, 0.1),
+			Track:       round(p.trackDg, 0.1),
+			Rssi:        &rssi,
+		}
+		if p.ground() {
+			ac.BarometerAltitude = "ground"
+		} else {
+			ac.BarometerAltitude = *round(p.altFt, 25)
+			ac.GeometricAltitude = round(p.altFt+150, 25)
+		}
+		rpt.Aircraft = append(rpt.Aircraft, ac)
+	}
+	return rpt
+}
+
+// Phases returns the phase of flight of every aircraft keyed on hex.
+func (s *Simulator) Phases() map[string]string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	m := make(map[string]string, len(s.planes))
+	for _, p := range s.planes {
+		m[p.hex] = p.phase
+	}
+	return m
+}
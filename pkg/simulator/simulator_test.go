@@ -0,0 +1,273 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	"github.com/slim-bean/adsb-loki/pkg/geo"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+var testConfig = Config{Lat: 39.8561, Lon: -104.6737, Aircraft: 10, RangeKm: 250, Seed: 42}
+
+func Test_Simulator(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	sim := New(testConfig, start)
+	seen := map[string]bool{}
+	// Every phase should move on to the next within an hour, holding into approach and taxi into climb.
+	changed := map[string]bool{}
+	last := sim.Phases()
+	for i := 1; i <= 3600; i++ {
+		sim.Advance(start.Add(time.Duration(i) * time.Second))
+		for h, p := range sim.Phases() {
+			seen[p] = true
+			if prev, ok := last[h]; ok && prev != p {
+				changed[prev+">"+p] = true
+			}
+		}
+		last = sim.Phases()
+
+		for _, ac := range sim.Report().Aircraft {
+			if d := geo.Distance(testConfig.Lat, testConfig.Lon, *ac.Lat, *ac.Lon); d > testConfig.RangeKm {
+				t.Fatalf("%s is %.0fkm away, beyond the range", ac.Hex, d)
+			}
+			if alt, ok := ac.Altitude(); !ok || alt < 0 || alt > 41000 {
+				t.Fatalf("%s has an invalid altitude %v", ac.Hex, ac.BarometerAltitude)
+			}
+		}
+	}
+	for _, p := range phases {
+		if !seen[p] {
+			t.Errorf("no aircraft in phase %s", p)
+		}
+	}
+	for _, c := range []string{"holding>approach", "approach>taxi", "taxi>climb", "climb>cruise"} {
+		if !changed[c] {
+			t.Errorf("expected a change %s, got %v", c, changed)
+		}
+	}
+
+	rpt := sim.Report()
+	if len(rpt.Aircraft) != testConfig.Aircraft || rpt.Now != 1600003600 || rpt.Messages == 0 {
+		t.Errorf("unexpected report now %v messages %d aircraft %d", rpt.Now, rpt.Messages, len(rpt.Aircraft))
+	}
+	if f := *rpt.Aircraft[0].Flight; len(f) != 8 {
+		t.Errorf("expected the callsign padded to 8 characters, got %q", f)
+	}
+}
+
+func Test_Identification(t *testing.T) {
+	// The example from The 1090 Megahertz Riddle.
+	msg, err := identification("4840d6", "KLM1023", "A0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := strings.ToUpper(hex.EncodeToString(msg)); h != "8D4840D6202CC371C32CE0576098" {
+		t.Errorf("unexpected message %s", h)
+	}
+}
+
+// decodeCPR is local decoding relative to a reference position within half a zone.
+func decodeCPR(y, x uint64, odd, surface bool, refLat, refLon float64) (float64, float64) {
+	i, span := 0.0, 360.0
+	if odd {
+		i = 1
+	}
+	if surface {
+		span = 90
+	}
+	yz, xz := float64(y)/cprMax, float64(x)/cprMax
+	dLat := span / (4*nz - i)
+	j := math.Floor(refLat/dLat) + math.Floor(0.5+mod(refLat, dLat)/dLat-yz)
+	lat := dLat * (j + yz)
+	dLon := span / math.Max(float64(nl(lat))-i, 1)
+	m := math.Floor(refLon/dLon) + math.Floor(0.5+mod(refLon, dLon)/dLon-xz)
+	return lat, dLon * (m + xz)
+}
+
+func me(msg []byte) uint64 {
+	var v uint64
+	for _, b := range msg[4:11] {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func Test_Positions(t *testing.T) {
+	lat, lon := 52.2572, 3.91937
+	for _, odd := range []bool{false, true} {
+		msg, err := airbornePosition("40621d", lat, lon, 38000, odd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parity(append(msg[:11:11], 0, 0, 0)) != uint32(msg[11])<<16|uint32(msg[12])<<8|uint32(msg[13]) {
+			t.Fatal("parity mismatch")
+		}
+		v := me(msg)
+		if tc := v >> 51; tc != tcAirbornePosition {
+			t.Errorf("unexpected type code %d", tc)
+		}
+		alt := (v >> 36) & 0xfff
+		n := (alt>>1)&0x7f0 | alt&0xf
+		if ft := float64(n)*25 - 1000; ft != 38000 {
+			t.Errorf("unexpected altitude %v", ft)
+		}
+		if (v>>34)&1 != boolBit(odd) {
+			t.Error("unexpected odd flag")
+		}
+		dLat, dLon := decodeCPR((v>>17)&0x1ffff, v&0x1ffff, odd, false, 52, 4)
+		if math.Abs(dLat-lat) > 0.0002 || math.Abs(dLon-lon) > 0.0002 {
+			t.Errorf("odd %v decoded to %f %f", odd, dLat, dLon)
+		}
+
+		msg, err = surfacePosition("40621d", lat, lon, 12, 90, odd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v = me(msg)
+		if mov, trk := (v>>44)&0x7f, (v>>36)&0x7f; mov != 33 || trk != 32 {
+			t.Errorf("unexpected movement %d or track %d", mov, trk)
+		}
+		dLat, dLon = decodeCPR((v>>17)&0x1ffff, v&0x1ffff, odd, true, 52, 4)
+		if math.Abs(dLat-lat) > 0.0001 || math.Abs(dLon-lon) > 0.0001 {
+			t.Errorf("surface odd %v decoded to %f %f", odd, dLat, dLon)
+		}
+	}
+
+	msg, err := velocity("485020", 159.2, 182.88, -832)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := me(msg)
+	ew, ns := float64((v>>32)&0x3ff)-1, float64((v>>21)&0x3ff)-1
+	if (v>>42)&1 == 1 {
+		ew = -ew
+	}
+	if (v>>31)&1 == 1 {
+		ns = -ns
+	}
+	if gs := math.Hypot(ew, ns); math.Abs(gs-159.2) > 1 {
+		t.Errorf("unexpected ground speed %f", gs)
+	}
+	if vr := float64((v>>10)&0x1ff-1) * 64; (v>>19)&1 != 1 || vr != 832 {
+		t.Errorf("unexpected vertical rate %f", vr)
+	}
+}
+
+func Test_Streams(t *testing.T) {
+	frame := beastFrame(time.Unix(0, 0), 0x1a, []byte{1, 0x1a, 2})
+	if !bytes.Equal(frame, []byte{0x1a, '3', 0, 0, 0, 0, 0, 0, 0x1a, 0x1a, 1, 0x1a, 0x1a, 2}) {
+		t.Errorf("unexpected frame %x", frame)
+	}
+
+	sim := New(testConfig, time.Unix(1600000000, 0))
+	if _, err := sim.Emergency("7700"); err != nil {
+		t.Fatal(err)
+	}
+	beast, sbs := sim.Streams()
+	if len(beast) == 0 || beast[0] != 0x1a {
+		t.Fatal("expected beast frames")
+	}
+	lines := strings.Split(strings.TrimSpace(string(sbs)), "\r\n")
+	emergencies := 0
+	for _, l := range lines {
+		fields := strings.Split(l, ",")
+		if len(fields) != 22 || fields[0] != "MSG" {
+			t.Fatalf("invalid sbs line %q", l)
+		}
+		if fields[1] == "6" && fields[17] == "7700" && fields[19] == "-1" {
+			emergencies++
+		}
+	}
+	if emergencies != 1 {
+		t.Errorf("expected one aircraft squawking 7700, got %d", emergencies)
+	}
+}
+
+func get(t *testing.T, url string) []byte {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func post(t *testing.T, method, url string) string {
+	req, _ := http.NewRequest(method, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: %d %s", method, url, resp.StatusCode, body)
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func Test_Server(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	sim := New(testConfig, start)
+	srv := httptest.NewServer(NewServer(log.NewNopLogger(), sim))
+	defer srv.Close()
+
+	rpt := model.Report{}
+	if err := json.Unmarshal(get(t, srv.URL+"/data/aircraft.json"), &rpt); err != nil {
+		t.Fatal(err)
+	}
+	if len(rpt.Aircraft) != testConfig.Aircraft {
+		t.Fatalf("expected %d aircraft, got %d", testConfig.Aircraft, len(rpt.Aircraft))
+	}
+
+	post(t, http.MethodPost, srv.URL+"/faults/stale")
+	stale := get(t, srv.URL+"/data/aircraft.json")
+	sim.Advance(start.Add(10 * time.Second))
+	if !bytes.Equal(stale, get(t, srv.URL+"/data/aircraft.json")) {
+		t.Error("expected aircraft.json to be frozen")
+	}
+	post(t, http.MethodDelete, srv.URL+"/faults/stale")
+	if bytes.Equal(stale, get(t, srv.URL+"/data/aircraft.json")) {
+		t.Error("expected aircraft.json to move on")
+	}
+
+	post(t, http.MethodPost, srv.URL+"/faults/malformed?rate=1")
+	if json.Unmarshal(get(t, srv.URL+"/data/aircraft.json"), &rpt) == nil {
+		t.Error("expected malformed json")
+	}
+	post(t, http.MethodDelete, srv.URL+"/faults/malformed")
+
+	hex := post(t, http.MethodPost, srv.URL+"/faults/emergency?squawk=7600")
+	if err := json.Unmarshal(get(t, srv.URL+"/data/aircraft.json"), &rpt); err != nil {
+		t.Fatal(err)
+	}
+	for _, ac := range rpt.Aircraft {
+		if squawking := *ac.Squawk == "7600"; squawking != (ac.Hex == hex) || squawking && *ac.Emergency != "nordo" {
+			t.Errorf("unexpected squawk %s for %s", *ac.Squawk, ac.Hex)
+		}
+	}
+	post(t, http.MethodDelete, srv.URL+"/faults/emergency")
+	if err := json.Unmarshal(get(t, srv.URL+"/data/aircraft.json"), &rpt); err != nil {
+		t.Fatal(err)
+	}
+	for _, ac := range rpt.Aircraft {
+		if *ac.Emergency != "none" {
+			t.Errorf("expected emergencies to be cleared, %s is %s", ac.Hex, *ac.Emergency)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// ErrNotModified is returned by a Fetcher when the receiver says the report hasn't changed since the last fetch.
+var ErrNotModified = errors.New("report not modified")
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "adsb_loki",
+		Name:      "source_requests_total",
+		Help:      "Number of reports fetched from the receiver by result, ok, unchanged or error.",
+	}, []string{"result"})
+	feedStale = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "adsb_loki",
+		Name:      "source_feed_stale",
+		Help:      "Whether the receiver has stopped sending new reports.",
+	})
+	lastReport = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "adsb_loki",
+		Name:      "source_last_report_timestamp_seconds",
+		Help:      "The receiver's timestamp of the last new report.",
+	})
+)
+
+// Source provides the reports which are run through the pipeline.
+type Source interface {
+	// Next blocks until the next report is due, it returns io.EOF when there are no more reports.
+	Next(ctx context.Context) (*model.Report, error)
+}
+
+// Fetcher fetches the current report, e.g. the aircraft.json from a receiver.
+type Fetcher interface {
+	GetReport(ctx context.Context) (*model.Report, error)
+}
+
+// Config controls how often the receiver is polled.
+type Config struct {
+	Interval   time.Duration `yaml:"interval"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+// RegisterFlagsWithPrefix registers flags where every name is prefixed by prefix, prefix should not end with a period.
+func (c *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.DurationVar(&c.Interval, prefix+".interval", time.Second, "How often to fetch a report from the receiver")
+	f.DurationVar(&c.MaxBackoff, prefix+".max-backoff", 30*time.Second, "Longest wait between attempts while the receiver is failing, the wait doubles from the interval after each failure")
+	f.DurationVar(&c.StaleAfter, prefix+".stale-after", 30*time.Second, "How long without a new report before the feed is stale, 0 disables the check")
+}
+
+// Poller is a Source which fetches a report every interval, starting immediately.
+// Reports with the same now as the last one are skipped, so a receiver which keeps serving the same
+// aircraft.json is not sent to Loki again. After StaleAfter without a new report a feed stale event is
+// sent to sink, and a feed resumed event when new reports arrive again.
+type Poller struct {
+	fetcher Fetcher
+	config  Config
+	sink    event.Sink
+
+	next     time.Time
+	failures int
+	lastNow  float64
+	lastNew  time.Time
+	stale    bool
+}
+
+func NewPoller(f Fetcher, config Config, sink event.Sink) *Poller {
+	feedStale.Set(0)
+	return &Poller{fetcher: f, config: config, sink: sink}
+}
+
+func (p *Poller) Next(ctx context.Context) (*model.Report, error) {
+	for {
+		if err := p.wait(ctx); err != nil {
+			return nil, err
+		}
+		rpt, err := p.fetcher.GetReport(ctx)
+		now := time.Now()
+		if p.lastNew.IsZero() {
+			p.lastNew = now
+		}
+		switch {
+		case err == nil && rpt.Now != p.lastNow:
+			requests.WithLabelValues("ok").Inc()
+			p.failures = 0
+			p.lastNow = rpt.Now
+			lastReport.Set(rpt.Now)
+			// The resumed event covers the time since the last new report, so it's sent before lastNew moves on.
+			if p.stale {
+				p.setStale(false, now)
+			}
+			p.lastNew = now
+			return rpt, nil
+		case err == nil || err == ErrNotModified:
+			requests.WithLabelValues("unchanged").Inc()
+			p.failures = 0
+			p.checkStale(now)
+		default:
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			requests.WithLabelValues("error").Inc()
+			p.failures++
+			p.next = now.Add(p.backoff())
+			p.checkStale(now)
+			return nil, err
+		}
+	}
+}
+
+// wait sleeps until the next fetch is due and schedules the one after it.
+func (p *Poller) wait(ctx context.Context) error {
+	if wait := time.Until(p.next); wait > 0 {
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	now := time.Now()
+	p.next = p.next.Add(p.config.Interval)
+	// A slow fetch must not cause a burst of fetches to catch up.
+	if p.next.Before(now) {
+		p.next = now.Add(p.config.Interval)
+	}
+	return nil
+}
+
+// backoff doubles the interval for each consecutive failure, up to MaxBackoff.
+func (p *Poller) backoff() time.Duration {
+	d := p.config.Interval
+	for i := 0; i < p.failures && d < p.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.config.MaxBackoff {
+		d = p.config.MaxBackoff
+	}
+	return d
+}
+
+func (p *Poller) checkStale(now time.Time) {
+	if !p.stale && p.config.StaleAfter > 0 && now.Sub(p.lastNew) >= p.config.StaleAfter {
+		p.setStale(true, now)
+	}
+}
+
+func (p *Poller) setStale(stale bool, now time.Time) {
+	p.stale = stale
+	since := now.Sub(p.lastNew).Round(time.Second)
+	e := event.Event{
+		Time:   now,
+		Fields: map[string]string{"last_report": p.lastNew.UTC().Format(time.RFC3339)},
+	}
+	if stale {
+		feedStale.Set(1)
+		e.Type = event.FeedStale
+		e.Message = fmt.Sprintf("no new reports from the receiver for %s", since)
+	} else {
+		feedStale.Set(0)
+		e.Type = event.FeedResumed
+		e.Message = fmt.Sprintf("reports from the receiver resumed after %s", since)
+	}
+	if p.sink != nil {
+		p.sink.Send(e)
+	}
+}
@@ -0,0 +1,136 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// fetcher returns each result in turn, then repeats the last one.
+type fetcher struct {
+	results []result
+	calls   []time.Time
+}
+
+type result struct {
+	now float64
+	err error
+}
+
+func (f *fetcher) GetReport(_ context.Context) (*model.Report, error) {
+	f.calls = append(f.calls, time.Now())
+	r := f.results[0]
+	if len(f.results) > 1 {
+		f.results = f.results[1:]
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &model.Report{Now: r.now}, nil
+}
+
+type sink struct {
+	mtx    sync.Mutex
+	events []event.Event
+}
+
+func (s *sink) Send(e event.Event) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.events = append(s.events, e)
+}
+
+func Test_PollerSkipsUnchanged(t *testing.T) {
+	f := &fetcher{results: []result{{now: 1}, {now: 1}, {err: ErrNotModified}, {now: 2}}}
+	p := NewPoller(f, Config{Interval: time.Millisecond, MaxBackoff: time.Millisecond}, nil)
+	for _, expected := range []float64{1, 2} {
+		rpt, err := p.Next(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rpt.Now != expected {
+			t.Errorf("expected the report from %v, got %v", expected, rpt.Now)
+		}
+	}
+	if len(f.calls) != 4 {
+		t.Errorf("expected 4 fetches, got %d", len(f.calls))
+	}
+}
+
+func Test_PollerBackoff(t *testing.T) {
+	down := errors.New("connection refused")
+	f := &fetcher{results: []result{{err: down}, {err: down}, {err: down}, {err: down}, {now: 1}, {now: 2}}}
+	p := NewPoller(f, Config{Interval: 10 * time.Millisecond, MaxBackoff: 40 * time.Millisecond}, nil)
+	for i := 0; i < 4; i++ {
+		if _, err := p.Next(context.Background()); err != down {
+			t.Fatalf("expected the fetch error, got %v", err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.Next(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Waits double after each failure up to the maximum, then go back to the interval.
+	expected := []time.Duration{20, 40, 40, 40, 10}
+	for i, e := range expected {
+		wait := f.calls[i+1].Sub(f.calls[i])
+		if wait < e*time.Millisecond || wait > e*time.Millisecond+50*time.Millisecond {
+			t.Errorf("wait %d: expected about %dms, got %s", i, e, wait)
+		}
+	}
+}
+
+func Test_PollerStale(t *testing.T) {
+	// The same report for 1.5s then a new one, the feed is stale after 1s and resumes after about 1.75s.
+	f := &fetcher{results: []result{{now: 1}, {now: 1}, {now: 1}, {now: 1}, {now: 1}, {now: 1}, {now: 1}, {now: 2}}}
+	s := &sink{}
+	p := NewPoller(f, Config{Interval: 250 * time.Millisecond, MaxBackoff: 250 * time.Millisecond, StaleAfter: time.Second}, s)
+	if _, err := p.Next(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rpt, err := p.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rpt.Now != 2 {
+		t.Fatalf("expected the new report, got %v", rpt.Now)
+	}
+	if len(s.events) != 2 || s.events[0].Type != event.FeedStale || s.events[1].Type != event.FeedResumed {
+		t.Fatalf("expected stale then resumed events, got %+v", s.events)
+	}
+	if m := s.events[0].Message; m != "no new reports from the receiver for 1s" {
+		t.Errorf("unexpected stale message %q", m)
+	}
+	if m := s.events[1].Message; m != "reports from the receiver resumed after 2s" {
+		t.Errorf("unexpected resumed message %q", m)
+	}
+	// Both events point at the first report, the last new one before the outage.
+	for _, e := range s.events {
+		last, err := time.Parse(time.RFC3339, e.Fields["last_report"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := last.Sub(f.calls[0]); d < -time.Second || d > time.Second {
+			t.Errorf("%s: expected the last report at %s, got %s", e.Type, f.calls[0].UTC(), last)
+		}
+	}
+}
+
+func Test_PollerCanceled(t *testing.T) {
+	f := &fetcher{results: []result{{now: 1}}}
+	p := NewPoller(f, Config{Interval: time.Hour, MaxBackoff: time.Hour}, nil)
+	if _, err := p.Next(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Next(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the wait to be canceled, got %v", err)
+	}
+}
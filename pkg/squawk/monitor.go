@@ -0,0 +1,94 @@
+package squawk
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+type emergency struct {
+	name     string
+	message  string
+	since    time.Time
+	lastSeen time.Time
+}
+
+// Monitor tracks which aircraft have an emergency squawk or emergency status and produces an event when
+// one first appears and another when it clears.
+type Monitor struct {
+	lostAfter time.Duration
+	active    map[string]*emergency
+}
+
+func NewMonitor(config Config) *Monitor {
+	return &Monitor{
+		lostAfter: config.LostAfter,
+		active:    map[string]*emergency{},
+	}
+}
+
+// current returns the emergency an aircraft is reporting, ok is false if the aircraft's state is unknown
+// because it sent neither a squawk nor an emergency status.
+func current(ac *model.Aircraft) (name, message string, ok bool) {
+	if ac.SquawkInfo != nil && ac.SquawkInfo.Emergency {
+		return *ac.Squawk, fmt.Sprintf("squawking %s %s", *ac.Squawk, ac.SquawkInfo.Description), true
+	}
+	if ac.Emergency != nil && *ac.Emergency != "" && *ac.Emergency != "none" {
+		return *ac.Emergency, fmt.Sprintf("emergency status %s", *ac.Emergency), true
+	}
+	return "", "", ac.Squawk != nil || ac.Emergency != nil
+}
+
+// Process checks the report, which must already be enriched with the squawk table, and returns any events.
+func (m *Monitor) Process(rpt *model.Report) []event.Event {
+	now := rpt.Time()
+	var events []event.Event
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		name, message, known := current(ac)
+		prev := m.active[ac.Hex]
+		if prev != nil && (name != "" || !known) {
+			prev.lastSeen = now
+		}
+		if prev != nil && known && name != prev.name {
+			events = append(events, m.end(ac.Hex, prev, now, "cleared", ac))
+			prev = nil
+		}
+		if prev == nil && name != "" {
+			e := &emergency{name: name, message: message, since: now, lastSeen: now}
+			m.active[ac.Hex] = e
+			events = append(events, event.Event{
+				Type:     event.EmergencyStart,
+				Time:     now,
+				Hex:      ac.Hex,
+				Name:     name,
+				Message:  message,
+				Aircraft: ac,
+			})
+		}
+	}
+	for hex, e := range m.active {
+		if now.Sub(e.lastSeen) > m.lostAfter {
+			events = append(events, m.end(hex, e, now, "lost", nil))
+		}
+	}
+	return events
+}
+
+func (m *Monitor) end(hex string, e *emergency, now time.Time, reason string, ac *model.Aircraft) event.Event {
+	delete(m.active, hex)
+	return event.Event{
+		Type:    event.EmergencyEnd,
+		Time:    now,
+		Hex:     hex,
+		Name:    e.name,
+		Message: fmt.Sprintf("%s ended, %s", e.message, reason),
+		Fields: map[string]string{
+			"reason":   reason,
+			"duration": now.Sub(e.since).Truncate(time.Second).String(),
+		},
+		Aircraft: ac,
+	}
+}
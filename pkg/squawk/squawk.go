@@ -0,0 +1,168 @@
+package squawk
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Categories used by the default table.
+const (
+	CategoryEmergency   = "emergency"
+	CategoryVFR         = "vfr"
+	CategoryConspicuity = "conspicuity"
+	CategorySpecial     = "special"
+)
+
+// Code describes a single squawk code or an inclusive range of codes such as "4601-4677".
+type Code struct {
+	Code        string `yaml:"code"`
+	Description string `yaml:"description"`
+	Category    string `yaml:"category"`
+	Emergency   bool   `yaml:"emergency"`
+	// Regions the code applies in, only used for the defaults which can be narrowed with the region setting.
+	Regions []string `yaml:"-"`
+}
+
+// defaults are the internationally or regionally reserved codes.
+var defaults = []Code{
+	{Code: "7500", Description: "Unlawful interference", Category: CategoryEmergency, Emergency: true},
+	{Code: "7600", Description: "Radio failure", Category: CategoryEmergency, Emergency: true},
+	{Code: "7700", Description: "General emergency", Category: CategoryEmergency, Emergency: true},
+	{Code: "7400", Description: "Unmanned aircraft lost link", Category: CategorySpecial},
+	{Code: "1200", Description: "VFR", Category: CategoryVFR, Regions: []string{"us"}},
+	{Code: "7777", Description: "Military interceptor operations", Category: CategorySpecial, Regions: []string{"us"}},
+	{Code: "7000", Description: "VFR conspicuity", Category: CategoryVFR, Regions: []string{"eu"}},
+	{Code: "2000", Description: "Entering SSR area without an assigned code", Category: CategoryConspicuity, Regions: []string{"eu"}},
+	{Code: "1000", Description: "Mode S conspicuity", Category: CategoryConspicuity, Regions: []string{"eu"}},
+}
+
+// Config is the squawk table, codes listed here are checked before the defaults so they can replace them.
+//
+//  squawks:
+//    region: eu
+//    codes:
+//      - code: "4601-4677"
+//        description: London Heathrow approach
+//        category: atc
+type Config struct {
+	Codes           []Code        `yaml:"codes"`
+	Region          string        `yaml:"region"`
+	DisableDefaults bool          `yaml:"disable_defaults"`
+	LostAfter       time.Duration `yaml:"lost_after"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.StringVar(&c.Region, "squawks.region", "", "Only use the default regional codes for this region, us or eu, all regions are used if empty")
+	f.BoolVar(&c.DisableDefaults, "squawks.disable-defaults", false, "Only use the codes from the config file")
+	f.DurationVar(&c.LostAfter, "squawks.lost-after", 5*time.Minute, "How long an aircraft with an emergency can be missing from the reports before the emergency is ended as lost")
+}
+
+type entry struct {
+	lo, hi int
+	info   model.SquawkInfo
+}
+
+// Table interprets squawk codes.
+type Table struct {
+	entries []entry
+}
+
+func New(config Config) (*Table, error) {
+	t := &Table{}
+	for _, c := range config.Codes {
+		if err := t.add(c); err != nil {
+			return nil, err
+		}
+	}
+	if config.DisableDefaults {
+		return t, nil
+	}
+	for _, c := range defaults {
+		if config.Region != "" && len(c.Regions) > 0 && !contains(c.Regions, strings.ToLower(config.Region)) {
+			continue
+		}
+		if err := t.add(c); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Table) add(c Code) error {
+	lo, hi := c.Code, c.Code
+	if i := strings.Index(c.Code, "-"); i >= 0 {
+		lo, hi = c.Code[:i], c.Code[i+1:]
+	}
+	l, err := parse(lo)
+	if err != nil {
+		return fmt.Errorf("invalid squawk code %q: %s", c.Code, err)
+	}
+	h, err := parse(hi)
+	if err != nil {
+		return fmt.Errorf("invalid squawk code %q: %s", c.Code, err)
+	}
+	if h < l {
+		return fmt.Errorf("invalid squawk code range %q", c.Code)
+	}
+	t.entries = append(t.entries, entry{
+		lo: l,
+		hi: h,
+		info: model.SquawkInfo{
+			Description: c.Description,
+			Category:    c.Category,
+			Emergency:   c.Emergency,
+		},
+	})
+	return nil
+}
+
+// parse converts a four digit octal squawk code into its value.
+func parse(code string) (int, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != 4 {
+		return 0, fmt.Errorf("must be 4 octal digits")
+	}
+	v, err := strconv.ParseUint(code, 8, 16)
+	if err != nil {
+		return 0, fmt.Errorf("must be 4 octal digits")
+	}
+	return int(v), nil
+}
+
+// Lookup returns the first entry in the table matching code, or nil if there isn't one.
+func (t *Table) Lookup(code string) *model.SquawkInfo {
+	v, err := parse(code)
+	if err != nil {
+		return nil
+	}
+	for i := range t.entries {
+		if e := &t.entries[i]; v >= e.lo && v <= e.hi {
+			info := e.info
+			return &info
+		}
+	}
+	return nil
+}
+
+func (t *Table) Enrich(rpt *model.Report) {
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		if ac.Squawk != nil {
+			ac.SquawkInfo = t.Lookup(*ac.Squawk)
+		}
+	}
+}
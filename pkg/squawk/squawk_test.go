@@ -0,0 +1,109 @@
+package squawk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func stringP(v string) *string {
+	return &v
+}
+
+func Test_Lookup(t *testing.T) {
+	table, err := New(Config{
+		Region: "eu",
+		Codes: []Code{
+			{Code: "4601-4677", Description: "Heathrow approach", Category: "atc"},
+			{Code: "7000", Description: "Local VFR", Category: CategoryVFR},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		code        string
+		description string
+		emergency   bool
+	}{
+		{"7700", "General emergency", true},
+		{"7500", "Unlawful interference", true},
+		{"4601", "Heathrow approach", false},
+		{"4677", "Heathrow approach", false},
+		{"7000", "Local VFR", false},
+		{"2000", "Entering SSR area without an assigned code", false},
+		{"1200", "", false},
+		{"4700", "", false},
+		{"7780", "", false},
+	}
+	for _, tt := range tests {
+		info := table.Lookup(tt.code)
+		if tt.description == "" {
+			if info != nil {
+				t.Errorf("%s: expected no match got %+v", tt.code, info)
+			}
+			continue
+		}
+		if info == nil || info.Description != tt.description || info.Emergency != tt.emergency {
+			t.Errorf("%s: unexpected match %+v", tt.code, info)
+		}
+	}
+
+	for _, c := range []string{"8000", "123", "4677-4601"} {
+		if _, err := New(Config{Codes: []Code{{Code: c}}}); err == nil {
+			t.Errorf("%s: expected invalid code to be rejected", c)
+		}
+	}
+}
+
+func Test_Monitor(t *testing.T) {
+	table, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMonitor(Config{LostAfter: time.Minute})
+	now := 1600000000.0
+	process := func(aircraft ...model.Aircraft) []event.Event {
+		rpt := &model.Report{Now: now, Aircraft: aircraft}
+		table.Enrich(rpt)
+		now += 10
+		return m.Process(rpt)
+	}
+	expect := func(events []event.Event, expected ...string) {
+		t.Helper()
+		if len(events) != len(expected) {
+			t.Fatalf("expected %v got %+v", expected, events)
+		}
+		for i, e := range events {
+			if e.Type+" "+e.Name != expected[i] {
+				t.Fatalf("expected %v got %+v", expected, events)
+			}
+		}
+	}
+
+	expect(process(model.Aircraft{Hex: "a", Squawk: stringP("1200")}))
+	expect(process(model.Aircraft{Hex: "a", Squawk: stringP("7700"), Emergency: stringP("general")}), "emergency_start 7700")
+	// Still in an emergency, and a report without a squawk doesn't change anything.
+	expect(process(model.Aircraft{Hex: "a", Squawk: stringP("7700")}))
+	expect(process(model.Aircraft{Hex: "a"}))
+	// Changing emergency ends the first.
+	expect(process(model.Aircraft{Hex: "a", Squawk: stringP("7600")}), "emergency_end 7700", "emergency_start 7600")
+	events := process(model.Aircraft{Hex: "a", Squawk: stringP("1200"), Emergency: stringP("none")})
+	expect(events, "emergency_end 7600")
+	if events[0].Fields["reason"] != "cleared" || events[0].Fields["duration"] != "10s" {
+		t.Fatalf("unexpected end event %+v", events[0])
+	}
+
+	// Emergency status without an emergency squawk.
+	expect(process(model.Aircraft{Hex: "b", Squawk: stringP("2345"), Emergency: stringP("minfuel")}), "emergency_start minfuel")
+	for i := 0; i < 6; i++ {
+		expect(process())
+	}
+	events = process()
+	expect(events, "emergency_end minfuel")
+	if events[0].Fields["reason"] != "lost" {
+		t.Fatalf("unexpected end event %+v", events[0])
+	}
+}
@@ -0,0 +1,257 @@
+package track
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/geo"
+)
+
+// Export formats.
+const (
+	GeoJSON = "geojson"
+	KML     = "kml"
+	GPX     = "gpx"
+)
+
+// ContentType returns the MIME type of the format.
+func ContentType(format string) string {
+	switch format {
+	case KML:
+		return "application/vnd.google-earth.kml+xml"
+	case GPX:
+		return "application/gpx+xml"
+	default:
+		return "application/geo+json"
+	}
+}
+
+// Select picks sessions from tracks, which is oldest first, session is all, last or the 1 based number of a session.
+func Select(tracks []Track, session string) ([]Track, error) {
+	switch session {
+	case "", "all":
+		return tracks, nil
+	case "last":
+		if len(tracks) == 0 {
+			return nil, nil
+		}
+		return tracks[len(tracks)-1:], nil
+	}
+	n, err := strconv.Atoi(session)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid session %q, must be all, last or a number", session)
+	}
+	if n > len(tracks) {
+		return nil, fmt.Errorf("session %d doesn't exist, there are %d sessions", n, len(tracks))
+	}
+	return tracks[n-1 : n], nil
+}
+
+// Write renders the tracks in the format.
+func Write(w io.Writer, format string, tracks []Track) error {
+	switch format {
+	case GeoJSON:
+		return writeGeoJSON(w, tracks)
+	case KML:
+		return writeKML(w, tracks)
+	case GPX:
+		return writeGPX(w, tracks)
+	default:
+		return fmt.Errorf("unknown format %q, must be one of geojson, kml or gpx", format)
+	}
+}
+
+func meters(ft float64) float64 {
+	return math.Round(ft*geo.MetersPerFoot*10) / 10
+}
+
+func name(t Track) string {
+	parts := []string{t.Hex}
+	if t.Flight != "" {
+		parts = append(parts, t.Flight)
+	}
+	if t.Registration != "" {
+		parts = append(parts, t.Registration)
+	}
+	return strings.Join(parts, " ")
+}
+
+type feature struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Geometry   struct {
+		Type        string      `json:"type"`
+		Coordinates [][]float64 `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// writeGeoJSON writes a LineString feature for each track, the altitude in metres is the third coordinate
+// when known and the per point times, altitudes and speeds are properties indexed like the coordinates.
+func writeGeoJSON(w io.Writer, tracks []Track) error {
+	fc := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+	for _, t := range tracks {
+		f := feature{Type: "Feature"}
+		f.Geometry.Type = "LineString"
+		times := make([]string, 0, len(t.Points))
+		alts := make([]*float64, 0, len(t.Points))
+		speeds := make([]*float64, 0, len(t.Points))
+		for _, p := range t.Points {
+			c := []float64{p.Lon, p.Lat}
+			if p.AltitudeFt != nil {
+				c = append(c, meters(*p.AltitudeFt))
+			}
+			f.Geometry.Coordinates = append(f.Geometry.Coordinates, c)
+			times = append(times, p.Time.UTC().Format(time.RFC3339Nano))
+			alts = append(alts, p.AltitudeFt)
+			speeds = append(speeds, p.SpeedKt)
+		}
+		// A LineString needs at least two positions.
+		if len(f.Geometry.Coordinates) == 1 {
+			f.Geometry.Coordinates = append(f.Geometry.Coordinates, f.Geometry.Coordinates[0])
+		}
+		f.Properties = map[string]interface{}{
+			"hex":          t.Hex,
+			"flight":       t.Flight,
+			"registration": t.Registration,
+			"start":        t.Start().UTC().Format(time.RFC3339),
+			"end":          t.End().UTC().Format(time.RFC3339),
+			"coordTimes":   times,
+			"altitudes_ft": alts,
+			"speeds_kt":    speeds,
+		}
+		fc.Features = append(fc.Features, f)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
+
+// writeKML writes a placemark for each track with the line extruded to the ground so the altitude profile
+// shows in Google Earth. Positions without an altitude use the previous known altitude.
+func writeKML(w io.Writer, tracks []Track) error {
+	type lineString struct {
+		Extrude      int    `xml:"extrude"`
+		Tessellate   int    `xml:"tessellate"`
+		AltitudeMode string `xml:"altitudeMode"`
+		Coordinates  string `xml:"coordinates"`
+	}
+	type timeSpan struct {
+		Begin string `xml:"begin"`
+		End   string `xml:"end"`
+	}
+	type placemark struct {
+		Name        string     `xml:"name"`
+		Description string     `xml:"description"`
+		TimeSpan    timeSpan   `xml:"TimeSpan"`
+		StyleURL    string     `xml:"styleUrl"`
+		LineString  lineString `xml:"LineString"`
+	}
+	type lineStyle struct {
+		Color string `xml:"color"`
+		Width int    `xml:"width"`
+	}
+	type polyStyle struct {
+		Color string `xml:"color"`
+	}
+	type style struct {
+		ID        string    `xml:"id,attr"`
+		LineStyle lineStyle `xml:"LineStyle"`
+		PolyStyle polyStyle `xml:"PolyStyle"`
+	}
+	type document struct {
+		Name       string      `xml:"name"`
+		Style      style       `xml:"Style"`
+		Placemarks []placemark `xml:"Placemark"`
+	}
+	doc := struct {
+		XMLName  xml.Name `xml:"http://www.opengis.net/kml/2.2 kml"`
+		Document document `xml:"Document"`
+	}{Document: document{
+		Name: "adsb-loki tracks",
+		Style: style{
+			ID:        "track",
+			LineStyle: lineStyle{Color: "ff00aaff", Width: 2},
+			PolyStyle: polyStyle{Color: "4000aaff"},
+		},
+	}}
+	for _, t := range tracks {
+		coords := &strings.Builder{}
+		alt := 0.0
+		for i, p := range t.Points {
+			if p.AltitudeFt != nil {
+				alt = meters(*p.AltitudeFt)
+			}
+			if i > 0 {
+				coords.WriteByte(' ')
+			}
+			fmt.Fprintf(coords, "%s,%s,%s", formatFloat(p.Lon), formatFloat(p.Lat), formatFloat(alt))
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, placemark{
+			Name:        name(t),
+			Description: fmt.Sprintf("%d positions", len(t.Points)),
+			TimeSpan:    timeSpan{Begin: t.Start().UTC().Format(time.RFC3339), End: t.End().UTC().Format(time.RFC3339)},
+			StyleURL:    "#track",
+			LineString:  lineString{Extrude: 1, Tessellate: 1, AltitudeMode: "absolute", Coordinates: coords.String()},
+		})
+	}
+	return writeXML(w, doc)
+}
+
+// writeGPX writes a track for each session, GPX 1.1 has no speed so only the altitude and time are included.
+func writeGPX(w io.Writer, tracks []Track) error {
+	type trkpt struct {
+		Lat  string   `xml:"lat,attr"`
+		Lon  string   `xml:"lon,attr"`
+		Ele  *float64 `xml:"ele,omitempty"`
+		Time string   `xml:"time"`
+	}
+	type trk struct {
+		Name   string  `xml:"name"`
+		Trkseg []trkpt `xml:"trkseg>trkpt"`
+	}
+	doc := struct {
+		XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+		Version string   `xml:"version,attr"`
+		Creator string   `xml:"creator,attr"`
+		Tracks  []trk    `xml:"trk"`
+	}{Version: "1.1", Creator: "adsb-loki"}
+	for _, t := range tracks {
+		tr := trk{Name: name(t)}
+		for _, p := range t.Points {
+			pt := trkpt{Lat: formatFloat(p.Lat), Lon: formatFloat(p.Lon), Time: p.Time.UTC().Format(time.RFC3339Nano)}
+			if p.AltitudeFt != nil {
+				m := meters(*p.AltitudeFt)
+				pt.Ele = &m
+			}
+			tr.Trkseg = append(tr.Trkseg, pt)
+		}
+		doc.Tracks = append(doc.Tracks, tr)
+	}
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
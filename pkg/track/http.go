@@ -0,0 +1,41 @@
+package track
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ServeHTTP exports the in memory track of an aircraft, it expects a hex route variable, e.g. /tracks/{hex},
+// and takes optional format and session query parameters, without a hex the aircraft with tracks are listed.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hex := mux.Vars(r)["hex"]
+	if hex == "" {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Hexes())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = GeoJSON
+	}
+	sessions := s.Sessions(hex)
+	if len(sessions) == 0 {
+		http.Error(w, "no track for "+hex, http.StatusNotFound)
+		return
+	}
+	tracks, err := Select(sessions, r.URL.Query().Get("session"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	buf := &bytes.Buffer{}
+	if err := Write(buf, format, tracks); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType(format))
+	_, _ = w.Write(buf.Bytes())
+}
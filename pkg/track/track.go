@@ -0,0 +1,195 @@
+package track
+
+import (
+	"flag"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+// Point is one position of an aircraft.
+type Point struct {
+	Time       time.Time `json:"time"`
+	Lat        float64   `json:"lat"`
+	Lon        float64   `json:"lon"`
+	AltitudeFt *float64  `json:"altitude_ft,omitempty"`
+	SpeedKt    *float64  `json:"speed_kt,omitempty"`
+	Track      *float64  `json:"track,omitempty"`
+}
+
+// Track is a continuous run of positions of one aircraft.
+type Track struct {
+	Hex          string  `json:"hex"`
+	Flight       string  `json:"flight,omitempty"`
+	Registration string  `json:"registration,omitempty"`
+	Points       []Point `json:"points"`
+}
+
+// Start is the time of the first point.
+func (t Track) Start() time.Time {
+	return t.Points[0].Time
+}
+
+// End is the time of the last point.
+func (t Track) End() time.Time {
+	return t.Points[len(t.Points)-1].Time
+}
+
+// sample is a point with the identity of the aircraft at the time, used to split and name sessions.
+type sample struct {
+	Point
+	flight, registration string
+}
+
+// newSample returns false if the aircraft has no position.
+func newSample(t time.Time, ac *model.Aircraft) (sample, bool) {
+	if ac.Lat == nil || ac.Lon == nil {
+		return sample{}, false
+	}
+	s := sample{Point: Point{Time: t, Lat: *ac.Lat, Lon: *ac.Lon, SpeedKt: ac.GroundSpeed, Track: ac.Track}}
+	if alt, ok := ac.Altitude(); ok {
+		s.AltitudeFt = &alt
+	}
+	if ac.Flight != nil {
+		s.flight = strings.TrimSpace(*ac.Flight)
+	}
+	if ac.Registration != nil {
+		s.registration = *ac.Registration
+	}
+	return s, true
+}
+
+// Builder collects the positions of an aircraft, in time order, and splits them into sessions.
+type Builder struct {
+	hex     string
+	samples []sample
+}
+
+func NewBuilder(hex string) *Builder {
+	return &Builder{hex: hex}
+}
+
+// Add appends the aircraft's position, it is ignored if the aircraft hasn't moved since the last one.
+func (b *Builder) Add(t time.Time, ac *model.Aircraft) {
+	s, ok := newSample(t, ac)
+	if !ok {
+		return
+	}
+	if n := len(b.samples); n > 0 && b.samples[n-1].Lat == s.Lat && b.samples[n-1].Lon == s.Lon {
+		return
+	}
+	b.samples = append(b.samples, s)
+}
+
+// Len is the number of positions added.
+func (b *Builder) Len() int {
+	return len(b.samples)
+}
+
+// Sessions splits the positions into a track for each flight, a new flight starts after a gap longer than
+// gap or when the callsign changes.
+func (b *Builder) Sessions(gap time.Duration) []Track {
+	var tracks []Track
+	var cur *Track
+	var last sample
+	for i, s := range b.samples {
+		if i == 0 || s.Time.Sub(last.Time) > gap || s.flight != "" && last.flight != "" && s.flight != last.flight {
+			tracks = append(tracks, Track{Hex: b.hex})
+			cur = &tracks[len(tracks)-1]
+		}
+		if cur.Flight == "" {
+			cur.Flight = s.flight
+		}
+		if cur.Registration == "" {
+			cur.Registration = s.registration
+		}
+		cur.Points = append(cur.Points, s.Point)
+		last = s
+	}
+	return tracks
+}
+
+type Config struct {
+	Retention  time.Duration `yaml:"retention"`
+	SessionGap time.Duration `yaml:"session_gap"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&c.Retention, "tracks.retention", time.Hour, "How long positions are kept in memory for track exports, 0 disables the in memory tracks")
+	f.DurationVar(&c.SessionGap, "tracks.session-gap", 10*time.Minute, "How long an aircraft can go without a position before its track is split into a new session")
+}
+
+// Store keeps the recent positions of every aircraft in memory.
+type Store struct {
+	config Config
+	mtx    sync.Mutex
+	tracks map[string]*Builder
+}
+
+func NewStore(config Config) *Store {
+	return &Store{
+		config: config,
+		tracks: map[string]*Builder{},
+	}
+}
+
+// Add records the positions in the report and forgets positions older than the retention.
+func (s *Store) Add(rpt *model.Report) {
+	now := rpt.Time()
+	cutoff := now.Add(-s.config.Retention)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		if ac.Lat == nil || ac.Lon == nil {
+			continue
+		}
+		hex := strings.ToLower(ac.Hex)
+		b := s.tracks[hex]
+		if b == nil {
+			b = NewBuilder(hex)
+			s.tracks[hex] = b
+		}
+		b.Add(now, ac)
+	}
+	for hex, b := range s.tracks {
+		i := sort.Search(len(b.samples), func(i int) bool { return !b.samples[i].Time.Before(cutoff) })
+		if i == len(b.samples) {
+			delete(s.tracks, hex)
+			continue
+		}
+		// Usually only a sample or two expire, which is a reslice. The dropped samples are freed when an append
+		// next grows the slice, which copies only the retained ones. Mostly expired tracks are copied straight away.
+		if i > len(b.samples)/2 {
+			b.samples = append([]sample(nil), b.samples[i:]...)
+		} else if i > 0 {
+			b.samples = b.samples[i:]
+		}
+	}
+}
+
+// Sessions returns the tracks of the aircraft split into sessions, oldest first.
+func (s *Store) Sessions(hex string) []Track {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	b, ok := s.tracks[strings.ToLower(hex)]
+	if !ok {
+		return nil
+	}
+	return b.Sessions(s.config.SessionGap)
+}
+
+// Hexes returns the aircraft with a track, sorted.
+func (s *Store) Hexes() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	hexes := make([]string, 0, len(s.tracks))
+	for h := range s.tracks {
+		hexes = append(hexes, h)
+	}
+	sort.Strings(hexes)
+	return hexes
+}
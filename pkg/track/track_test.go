@@ -0,0 +1,227 @@
+package track
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/gorilla/mux"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func float64P(v float64) *float64 {
+	return &v
+}
+
+func stringP(v string) *string {
+	return &v
+}
+
+func aircraft(flight string, lat, lon, alt float64) model.Aircraft {
+	return model.Aircraft{Hex: "a00001", Flight: stringP(flight), Lat: float64P(lat), Lon: float64P(lon), BarometerAltitude: alt, GroundSpeed: float64P(150)}
+}
+
+func testTracks() []Track {
+	b := NewBuilder("a00001")
+	start := time.Unix(1600000000, 0)
+	for i := 0; i < 3; i++ {
+		ac := aircraft("N1", 51+float64(i)*0.01, 0, 1000+float64(i)*100)
+		b.Add(start.Add(time.Duration(i)*10*time.Second), &ac)
+	}
+	// Same position again is ignored.
+	ac := aircraft("N1", 51.02, 0, 1200)
+	b.Add(start.Add(30*time.Second), &ac)
+	// A long gap starts a new session, as does a new callsign.
+	ac = aircraft("N1", 52, 1, 2000)
+	b.Add(start.Add(time.Hour), &ac)
+	ac = aircraft("N2", 52.1, 1, 2000)
+	b.Add(start.Add(time.Hour+10*time.Second), &ac)
+	ac = model.Aircraft{Hex: "a00001", Flight: stringP("N2"), Lat: float64P(52.2), Lon: float64P(1)}
+	b.Add(start.Add(time.Hour+20*time.Second), &ac)
+	return b.Sessions(10 * time.Minute)
+}
+
+func Test_Sessions(t *testing.T) {
+	tracks := testTracks()
+	if len(tracks) != 3 {
+		t.Fatalf("expected 3 sessions got %d", len(tracks))
+	}
+	for i, n := range []int{3, 1, 2} {
+		if len(tracks[i].Points) != n {
+			t.Errorf("session %d: expected %d points got %d", i, n, len(tracks[i].Points))
+		}
+	}
+	if tracks[2].Flight != "N2" || *tracks[0].Points[2].AltitudeFt != 1200 {
+		t.Errorf("unexpected tracks %+v", tracks)
+	}
+
+	if s, err := Select(tracks, "last"); err != nil || len(s) != 1 || s[0].Flight != "N2" {
+		t.Errorf("unexpected last session %+v: %v", s, err)
+	}
+	if s, err := Select(tracks, "2"); err != nil || len(s) != 1 || len(s[0].Points) != 1 {
+		t.Errorf("unexpected second session %+v: %v", s, err)
+	}
+	for _, bad := range []string{"0", "4", "first"} {
+		if _, err := Select(tracks, bad); err == nil {
+			t.Errorf("expected session %q to be rejected", bad)
+		}
+	}
+}
+
+func Test_Write(t *testing.T) {
+	tracks := testTracks()
+
+	buf := &bytes.Buffer{}
+	if err := Write(buf, GeoJSON, tracks); err != nil {
+		t.Fatal(err)
+	}
+	fc := struct {
+		Features []feature `json:"features"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+	if len(fc.Features) != 3 {
+		t.Fatalf("expected 3 features got %d", len(fc.Features))
+	}
+	f := fc.Features[0]
+	if f.Geometry.Type != "LineString" || len(f.Geometry.Coordinates) != 3 || f.Geometry.Coordinates[2][2] != 365.8 ||
+		len(f.Properties["coordTimes"].([]interface{})) != 3 {
+		t.Fatalf("unexpected feature %+v", f)
+	}
+	// The single point session is still a valid LineString, the last point has no altitude.
+	if len(fc.Features[1].Geometry.Coordinates) != 2 || len(fc.Features[2].Geometry.Coordinates[1]) != 2 {
+		t.Fatalf("unexpected features %+v", fc.Features[1:])
+	}
+
+	buf.Reset()
+	if err := Write(buf, KML, tracks); err != nil {
+		t.Fatal(err)
+	}
+	kml := struct {
+		Placemarks []struct {
+			Name       string `xml:"name"`
+			LineString struct {
+				Extrude     int    `xml:"extrude"`
+				Coordinates string `xml:"coordinates"`
+			} `xml:"LineString"`
+		} `xml:"Document>Placemark"`
+	}{}
+	if err := xml.Unmarshal(buf.Bytes(), &kml); err != nil {
+		t.Fatal(err)
+	}
+	if len(kml.Placemarks) != 3 || kml.Placemarks[0].Name != "a00001 N1" || kml.Placemarks[0].LineString.Extrude != 1 {
+		t.Fatalf("unexpected kml %+v", kml)
+	}
+	// Missing altitude carries the previous one forward.
+	if c := kml.Placemarks[2].LineString.Coordinates; c != "1,52.1,609.6 1,52.2,609.6" {
+		t.Fatalf("unexpected kml coordinates %s", c)
+	}
+
+	buf.Reset()
+	if err := Write(buf, GPX, tracks[:1]); err != nil {
+		t.Fatal(err)
+	}
+	gpx := struct {
+		Points []struct {
+			Lat  float64 `xml:"lat,attr"`
+			Ele  float64 `xml:"ele"`
+			Time string  `xml:"time"`
+		} `xml:"trk>trkseg>trkpt"`
+	}{}
+	if err := xml.Unmarshal(buf.Bytes(), &gpx); err != nil {
+		t.Fatal(err)
+	}
+	if len(gpx.Points) != 3 || gpx.Points[1].Lat != 51.01 || gpx.Points[1].Ele != 335.3 || gpx.Points[1].Time != "2020-09-13T12:26:50Z" {
+		t.Fatalf("unexpected gpx %+v", gpx)
+	}
+
+	if err := Write(buf, "shapefile", tracks); err == nil {
+		t.Fatal("expected unknown format to be rejected")
+	}
+}
+
+func Test_Store(t *testing.T) {
+	s := NewStore(Config{Retention: time.Minute, SessionGap: time.Minute})
+	router := mux.NewRouter()
+	router.Handle("/tracks", s)
+	router.Handle("/tracks/{hex}", s)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	for i := 0; i < 10; i++ {
+		s.Add(&model.Report{Now: 1600000000 + float64(i)*10, Aircraft: []model.Aircraft{
+			aircraft("N1", 51+float64(i)*0.01, 0, 1000),
+			{Hex: "a00002"},
+		}})
+	}
+	sessions := s.Sessions("A00001")
+	if len(sessions) != 1 || len(sessions[0].Points) != 7 {
+		t.Fatalf("expected positions older than the retention to be forgotten, got %+v", sessions)
+	}
+	if hexes := s.Hexes(); len(hexes) != 1 {
+		t.Fatalf("expected only aircraft with a position to be stored, got %v", hexes)
+	}
+
+	get := func(path string) (int, string, string) {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf := &bytes.Buffer{}
+		_, _ = buf.ReadFrom(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), buf.String()
+	}
+	if code, ct, body := get("/tracks/a00001?format=gpx&session=last"); code != 200 || ct != "application/gpx+xml" || !strings.Contains(body, "<trkpt") {
+		t.Fatalf("unexpected gpx response %d %s %s", code, ct, body)
+	}
+	if code, _, body := get("/tracks"); code != 200 || strings.TrimSpace(body) != `["a00001"]` {
+		t.Fatalf("unexpected list response %d %s", code, body)
+	}
+	if code, _, _ := get("/tracks/a00002"); code != http.StatusNotFound {
+		t.Fatalf("expected not found for an aircraft without a track, got %d", code)
+	}
+	if code, _, _ := get("/tracks/a00001?format=csv"); code != http.StatusBadRequest {
+		t.Fatalf("expected bad request for an unknown format, got %d", code)
+	}
+
+	// Everything is forgotten once it's older than the retention.
+	s.Add(&model.Report{Now: 1600001000})
+	if len(s.Hexes()) != 0 {
+		t.Fatal("expected all tracks to be forgotten")
+	}
+}
+
+func Test_StoreRetentionAllocs(t *testing.T) {
+	s := NewStore(Config{Retention: 1000 * time.Second, SessionGap: time.Minute})
+	rpts := make([]*model.Report, 3000)
+	for i := range rpts {
+		rpts[i] = &model.Report{Now: 1600000000 + float64(i), Aircraft: []model.Aircraft{aircraft("N1", 51+float64(i)*0.0001, 0, 1000)}}
+	}
+	for _, rpt := range rpts[:1000] {
+		s.Add(rpt)
+	}
+	// Once at the retention each report expires one sample, which mustn't copy the whole track.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for _, rpt := range rpts[1000:] {
+		s.Add(rpt)
+	}
+	runtime.ReadMemStats(&after)
+	perReport := (after.TotalAlloc - before.TotalAlloc) / 2000
+	if size := uint64(unsafe.Sizeof(sample{})); perReport > 10*size {
+		t.Errorf("expected expiring samples to be cheap, allocated %d bytes per report with %d byte samples", perReport, size)
+	}
+	if sessions := s.Sessions("a00001"); len(sessions) != 1 || len(sessions[0].Points) != 1001 {
+		t.Fatalf("expected the retention to be kept, got %d sessions", len(sessions))
+	}
+}
@@ -0,0 +1,206 @@
+package watchlist
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/slim-bean/adsb-loki/pkg/event"
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+var matches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "adsb_loki_watchlist_matches_total",
+	Help: "Number of times an aircraft was spotted matching each watchlist rule, an aircraft is counted again once it has been forgotten.",
+}, []string{"rule"})
+
+// Rule matches aircraft on every condition which is set, a rule must have at least one condition.
+type Rule struct {
+	Name string   `yaml:"name"`
+	Hex  []string `yaml:"hex"`
+	// Registration is a glob such as N*PD, matched case insensitively.
+	Registration string `yaml:"registration"`
+	// Callsign is a regular expression matched against the flight callsign.
+	Callsign  string   `yaml:"callsign"`
+	TypeCodes []string `yaml:"type_codes"`
+	// Owner is a case insensitive substring of the registered owner.
+	Owner       string `yaml:"owner"`
+	Military    *bool  `yaml:"military"`
+	Interesting *bool  `yaml:"interesting"`
+	PIA         *bool  `yaml:"pia"`
+	LADD        *bool  `yaml:"ladd"`
+}
+
+// Config is the list of watchlist rules.
+//
+//  watchlist:
+//    rules:
+//      - name: police
+//        owner: sheriff
+//      - name: heavy-lift
+//        type_codes: [C5M, A124, A225]
+type Config struct {
+	Rules       []Rule        `yaml:"rules"`
+	ForgetAfter time.Duration `yaml:"forget_after"`
+}
+
+func (c *Config) RegisterFlags(f *flag.FlagSet) {
+	f.DurationVar(&c.ForgetAfter, "watchlist.forget-after", 30*time.Minute, "How long an aircraft must be gone before matching a rule again produces a new event")
+}
+
+type rule struct {
+	Rule
+	hex       map[string]bool
+	callsign  *regexp.Regexp
+	typeCodes map[string]bool
+	owner     string
+}
+
+// Watchlist marks aircraft with the rules they match and produces an event the first time each is spotted.
+type Watchlist struct {
+	rules       []rule
+	forgetAfter time.Duration
+	// lastSeen is keyed on rule name and hex.
+	lastSeen map[string]time.Time
+}
+
+func New(config Config) (*Watchlist, error) {
+	w := &Watchlist{
+		forgetAfter: config.ForgetAfter,
+		lastSeen:    map[string]time.Time{},
+	}
+	names := map[string]bool{}
+	for _, r := range config.Rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("watchlist rule must have a name")
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate watchlist rule %s", r.Name)
+		}
+		names[r.Name] = true
+		cr, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("watchlist rule %s: %s", r.Name, err)
+		}
+		w.rules = append(w.rules, cr)
+	}
+	return w, nil
+}
+
+// Update replaces the rules with those of next, which must not be used afterwards.
+// Aircraft which were already spotted aren't reported again for rules which are kept.
+func (w *Watchlist) Update(next *Watchlist) {
+	w.rules = next.rules
+	w.forgetAfter = next.forgetAfter
+}
+
+func compile(r Rule) (rule, error) {
+	cr := rule{Rule: r, owner: strings.ToLower(r.Owner)}
+	if len(r.Hex) > 0 {
+		cr.hex = map[string]bool{}
+		for _, h := range r.Hex {
+			cr.hex[strings.ToLower(strings.TrimSpace(h))] = true
+		}
+	}
+	if r.Registration != "" {
+		if _, err := path.Match(r.Registration, ""); err != nil {
+			return cr, fmt.Errorf("invalid registration glob: %s", err)
+		}
+		cr.Registration = strings.ToUpper(r.Registration)
+	}
+	if r.Callsign != "" {
+		re, err := regexp.Compile(r.Callsign)
+		if err != nil {
+			return cr, fmt.Errorf("invalid callsign regex: %s", err)
+		}
+		cr.callsign = re
+	}
+	if len(r.TypeCodes) > 0 {
+		cr.typeCodes = map[string]bool{}
+		for _, t := range r.TypeCodes {
+			cr.typeCodes[strings.ToUpper(strings.TrimSpace(t))] = true
+		}
+	}
+	if cr.hex == nil && cr.Registration == "" && cr.callsign == nil && cr.typeCodes == nil && cr.owner == "" &&
+		r.Military == nil && r.Interesting == nil && r.PIA == nil && r.LADD == nil {
+		return cr, fmt.Errorf("rule has no conditions")
+	}
+	return cr, nil
+}
+
+func boolMatches(want, got *bool) bool {
+	return want == nil || *want == (got != nil && *got)
+}
+
+func (r *rule) matches(ac *model.Aircraft) bool {
+	if r.hex != nil && !r.hex[strings.ToLower(ac.Hex)] {
+		return false
+	}
+	if r.Registration != "" {
+		if ac.Registration == nil {
+			return false
+		}
+		if ok, _ := path.Match(r.Registration, strings.ToUpper(*ac.Registration)); !ok {
+			return false
+		}
+	}
+	if r.callsign != nil && (ac.Flight == nil || !r.callsign.MatchString(*ac.Flight)) {
+		return false
+	}
+	if r.typeCodes != nil && (ac.TypeCode == nil || !r.typeCodes[strings.ToUpper(*ac.TypeCode)]) {
+		return false
+	}
+	if r.owner != "" && (ac.Owner == nil || !strings.Contains(strings.ToLower(*ac.Owner), r.owner)) {
+		return false
+	}
+	return boolMatches(r.Military, ac.Military) && boolMatches(r.Interesting, ac.Interesting) &&
+		boolMatches(r.PIA, ac.PIA) && boolMatches(r.LADD, ac.LADD)
+}
+
+// Enrich sets the names of the matching rules on each aircraft.
+func (w *Watchlist) Enrich(rpt *model.Report) {
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		for j := range w.rules {
+			if w.rules[j].matches(ac) {
+				ac.Watchlist = append(ac.Watchlist, w.rules[j].Name)
+			}
+		}
+	}
+}
+
+// Process returns an event for every rule an aircraft matches which it had not matched within the forget after period.
+func (w *Watchlist) Process(rpt *model.Report) []event.Event {
+	now := rpt.Time()
+	var events []event.Event
+	for i := range rpt.Aircraft {
+		ac := &rpt.Aircraft[i]
+		for _, name := range ac.Watchlist {
+			key := name + "/" + ac.Hex
+			if last, ok := w.lastSeen[key]; !ok || now.Sub(last) > w.forgetAfter {
+				matches.WithLabelValues(name).Inc()
+				events = append(events, event.Event{
+					Type:     event.Watchlist,
+					Time:     now,
+					Hex:      ac.Hex,
+					Name:     name,
+					Message:  fmt.Sprintf("spotted %s", name),
+					Aircraft: ac,
+				})
+			}
+			w.lastSeen[key] = now
+		}
+	}
+	for key, last := range w.lastSeen {
+		if now.Sub(last) > w.forgetAfter {
+			delete(w.lastSeen, key)
+		}
+	}
+	return events
+}
@@ -0,0 +1,86 @@
+package watchlist
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/slim-bean/adsb-loki/pkg/model"
+)
+
+func stringP(v string) *string {
+	return &v
+}
+
+func boolP(v bool) *bool {
+	return &v
+}
+
+func Test_Watchlist(t *testing.T) {
+	w, err := New(Config{
+		ForgetAfter: time.Minute,
+		Rules: []Rule{
+			{Name: "police", Owner: "sheriff"},
+			{Name: "pd-reg", Registration: "n*pd"},
+			{Name: "heavy", TypeCodes: []string{"c5m", "A124"}, Military: boolP(true)},
+			{Name: "medevac", Callsign: "^(LIFE|MEDIC)[0-9]+$"},
+			{Name: "friend", Hex: []string{"A1B2C3"}},
+			{Name: "not-ladd", Hex: []string{"a1b2c3", "a00001"}, LADD: boolP(false)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpt := &model.Report{Now: 1600000000, Aircraft: []model.Aircraft{
+		{Hex: "a00001", Details: model.Details{Registration: stringP("N123PD"), Owner: stringP("County Sheriff"), LADD: boolP(true)}},
+		{Hex: "ae0001", Details: model.Details{TypeCode: stringP("C5M"), Military: boolP(true)}},
+		{Hex: "ae0002", Details: model.Details{TypeCode: stringP("C5M")}},
+		{Hex: "a00002", Flight: stringP("LIFE42")},
+		{Hex: "a1b2c3", Flight: stringP("LIFE42X")},
+	}}
+	w.Enrich(rpt)
+	expected := [][]string{
+		{"police", "pd-reg"},
+		{"heavy"},
+		nil,
+		{"medevac"},
+		{"friend", "not-ladd"},
+	}
+	for i, e := range expected {
+		if !reflect.DeepEqual(rpt.Aircraft[i].Watchlist, e) {
+			t.Errorf("%s: expected %v got %v", rpt.Aircraft[i].Hex, e, rpt.Aircraft[i].Watchlist)
+		}
+	}
+
+	if events := w.Process(rpt); len(events) != 6 || events[0].Name != "police" || events[0].Hex != "a00001" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	rpt.Now += 30
+	if events := w.Process(rpt); len(events) != 0 {
+		t.Fatalf("expected no events for aircraft already spotted, got %+v", events)
+	}
+	// Gone for longer than forget after, then spotted again.
+	rpt.Now += 120
+	if events := w.Process(&model.Report{Now: rpt.Now, Aircraft: rpt.Aircraft[:1]}); len(events) != 2 {
+		t.Fatalf("expected forgotten aircraft to be spotted again, got %+v", events)
+	}
+
+	// Reloading keeps what was spotted for rules which are kept, only the new rule is reported.
+	next, err := New(Config{ForgetAfter: time.Minute, Rules: []Rule{{Name: "police", Owner: "sheriff"}, {Name: "county", Owner: "county"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Update(next)
+	rpt = &model.Report{Now: rpt.Now + 10, Aircraft: []model.Aircraft{rpt.Aircraft[0]}}
+	rpt.Aircraft[0].Watchlist = nil
+	w.Enrich(rpt)
+	if events := w.Process(rpt); len(events) != 1 || events[0].Name != "county" {
+		t.Fatalf("expected only the new rule to be reported, got %+v", events)
+	}
+
+	for _, r := range []Rule{{Hex: []string{"a00001"}}, {Name: "empty"}, {Name: "bad", Callsign: "("}, {Name: "bad", Registration: "["}} {
+		if _, err := New(Config{Rules: []Rule{r}}); err == nil {
+			t.Errorf("expected invalid rule %+v to be rejected", r)
+		}
+	}
+}